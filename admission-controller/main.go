@@ -25,11 +25,14 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 
+	miprofilev1alpha1 "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	miprofilev1beta1 "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1beta1"
 	ac "github.com/IBM/integrity-shield/admission-controller/pkg/controller"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -42,6 +45,8 @@ var (
 
 const tlsDir = `/run/secrets/tls`
 
+// +kubebuilder:webhook:path=/validate-profile,mutating=false,failurePolicy=ignore,sideEffects=None,groups=apis.integrityshield.io,resources=manifestintegrityprofiles;namespacedmanifestintegrityprofiles,verbs=create;update,versions=v1alpha1,name=profile.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:webhook:path=/mutate-resource,mutating=true,failurePolicy=ignore,sideEffects=None,groups=*,resources=*,verbs=create;update,versions=*,name=stamp.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
 // +kubebuilder:webhook:path=/validate-resource,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
 
 type k8sManifestHandler struct {
@@ -49,21 +54,33 @@ type k8sManifestHandler struct {
 }
 
 func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
-	res := ac.ProcessRequest(req)
+	res := ac.ProcessRequest(ctx, req)
 	return res
 }
 
+// stampHandler adds verification annotations to objects verified by profiles
+type stampHandler struct{}
+
+func (h *stampHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	return ac.MutateRequest(ctx, req)
+}
+
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = corev1.AddToScheme(scheme)
+	// all versions of ManifestIntegrityProfile are needed to convert between them
+	_ = miprofilev1alpha1.AddToScheme(scheme)
+	_ = miprofilev1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
+	var probeAddr string
 	var enableLeaderElection bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -71,13 +88,15 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "22a603b9.sigstore.dev",
-		CertDir:            tlsDir,
+	restConfig := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: probeAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "22a603b9.sigstore.dev",
+		CertDir:                tlsDir,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -86,9 +105,46 @@ func main() {
 
 	hookServer := mgr.GetWebhookServer()
 	hookServer.Register("/validate-resource", &webhook.Admission{Handler: &k8sManifestHandler{Client: mgr.GetClient()}})
+	hookServer.Register("/mutate-resource", &webhook.Admission{Handler: &stampHandler{}})
+	simulationHandler, err := ac.NewSimulationHandler(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to set up simulation handler")
+		os.Exit(1)
+	}
+	hookServer.Register("/simulate", simulationHandler)
+	profileValidator, err := ac.NewProfileValidator(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to set up profile validator")
+		os.Exit(1)
+	}
+	hookServer.Register("/validate-profile", &webhook.Admission{Handler: profileValidator})
+	// v1alpha1 is the storage version; other versions are converted via the hub version v1beta1 at /convert
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&miprofilev1beta1.ManifestIntegrityProfile{}).Complete(); err != nil {
+		setupLog.Error(err, "unable to set up conversion webhook")
+		os.Exit(1)
+	}
 
 	// +kubebuilder:scaffold:builder
 
+	// profiles and namespaces are served from informer caches; the pod is ready once they are synced
+	if err := mgr.Add(&ac.ResourceCacheRunnable{Config: restConfig, Resync: ac.DefaultCacheResyncPeriod}); err != nil {
+		setupLog.Error(err, "unable to set up informer caches")
+		os.Exit(1)
+	}
+	// results of profiles are written to their status in batches
+	if err := mgr.Add(&ac.StatusRecorderRunnable{Config: restConfig, Interval: ac.DefaultStatusFlushInterval}); err != nil {
+		setupLog.Error(err, "unable to set up status recorder")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("informers", ac.CacheSyncCheck); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
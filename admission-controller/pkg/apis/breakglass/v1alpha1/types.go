@@ -0,0 +1,76 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package v1alpha1
+
+import (
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BreakGlassSpec defines requests which bypass verification until ExpiresAt.
+// A request is bypassed when it matches all of the non-empty conditions.
+// A grant without any condition matches nothing.
+type BreakGlassSpec struct {
+	// why the bypass is granted; recorded with every bypassed request
+	Reason string `json:"reason,omitempty"`
+	// the grant is ignored after this time
+	ExpiresAt  metav1.Time       `json:"expiresAt"`
+	Namespaces []string          `json:"namespaces,omitempty"`
+	Users      []string          `json:"users,omitempty"`
+	Groups     []string          `json:"groups,omitempty"`
+	Kinds      []miprofile.Kinds `json:"kinds,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +resource:path=breakglass,scope=Cluster
+
+// BreakGlass grants a time-limited bypass of verification during incidents.
+// It is cluster scoped, so only users allowed to create it by RBAC can grant a bypass.
+type BreakGlass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BreakGlassSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BreakGlassList contains a list of BreakGlass
+type BreakGlassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BreakGlass `json:"items"`
+}
+
+// IsActive returns true if the grant has not expired yet.
+func (bg *BreakGlass) IsActive(now time.Time) bool {
+	if bg.Spec.ExpiresAt.IsZero() {
+		return false
+	}
+	return now.Before(bg.Spec.ExpiresAt.Time)
+}
+
+// HasCondition returns true if the grant has at least one condition.
+func (bg *BreakGlass) HasCondition() bool {
+	s := bg.Spec
+	return len(s.Namespaces) != 0 || len(s.Users) != 0 || len(s.Groups) != 0 || len(s.Kinds) != 0
+}
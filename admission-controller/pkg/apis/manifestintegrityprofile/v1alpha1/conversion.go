@@ -0,0 +1,305 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package v1alpha1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1beta1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// UnconvertedParametersAnnotation keeps v1alpha1 parameters which have no field in v1beta1,
+// e.g. options of k8s-manifest-sigstore, so that they survive a round trip through v1beta1.
+const UnconvertedParametersAnnotation = "apis.integrityshield.io/v1alpha1-parameters"
+
+var _ conversion.Convertible = &ManifestIntegrityProfile{}
+
+// ConvertTo converts this profile to the hub version v1beta1.
+func (src *ManifestIntegrityProfile) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.ManifestIntegrityProfile)
+	if !ok {
+		return errors.New(fmt.Sprintf("unexpected conversion hub %T", dstRaw))
+	}
+	params, unconverted, err := convertParametersToV1beta1(src.Spec.Parameters)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to convert parameters of ManifestIntegrityProfile `%s`", src.Name))
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	if unconverted != "" {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[UnconvertedParametersAnnotation] = unconverted
+	} else {
+		delete(dst.Annotations, UnconvertedParametersAnnotation)
+	}
+	dst.Spec.Match = convertMatchConditionToV1beta1(src.Spec.Match)
+	dst.Spec.Parameters = params
+	dst.Spec.Action = src.Spec.Action
+	dst.Status = convertStatusToV1beta1(src.Status)
+	return nil
+}
+
+// ConvertFrom converts a profile of the hub version v1beta1 to this version.
+func (dst *ManifestIntegrityProfile) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.ManifestIntegrityProfile)
+	if !ok {
+		return errors.New(fmt.Sprintf("unexpected conversion hub %T", srcRaw))
+	}
+	params, err := convertParametersFromV1beta1(src.Spec.Parameters, src.Annotations[UnconvertedParametersAnnotation])
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to convert parameters of ManifestIntegrityProfile `%s`", src.Name))
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	delete(dst.Annotations, UnconvertedParametersAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	dst.Spec.Match = convertMatchConditionFromV1beta1(src.Spec.Match)
+	dst.Spec.Parameters = params
+	dst.Spec.Action = src.Spec.Action
+	dst.Status = convertStatusFromV1beta1(src.Status)
+	return nil
+}
+
+func convertMatchConditionToV1beta1(in MatchCondition) v1beta1.MatchCondition {
+	out := v1beta1.MatchCondition{
+		Namespaces:         append([]string(nil), in.Namespaces...),
+		ExcludedNamespaces: append([]string(nil), in.ExcludedNamespaces...),
+		LabelSelector:      in.LabelSelector.DeepCopy(),
+		NamespaceSelector:  in.NamespaceSelector.DeepCopy(),
+		Operations:         append([]string(nil), in.Operations...),
+		Users:              append([]string(nil), in.Users...),
+		Groups:             append([]string(nil), in.Groups...),
+		Names:              append([]string(nil), in.Names...),
+		AnnotationSelector: in.AnnotationSelector.DeepCopy(),
+	}
+	for _, k := range in.Kinds {
+		out.Kinds = append(out.Kinds, v1beta1.Kinds{
+			Kinds:     append([]string(nil), k.Kinds...),
+			ApiGroups: append([]string(nil), k.ApiGroups...),
+		})
+	}
+	return out
+}
+
+func convertMatchConditionFromV1beta1(in v1beta1.MatchCondition) MatchCondition {
+	out := MatchCondition{
+		Namespaces:         append([]string(nil), in.Namespaces...),
+		ExcludedNamespaces: append([]string(nil), in.ExcludedNamespaces...),
+		LabelSelector:      in.LabelSelector.DeepCopy(),
+		NamespaceSelector:  in.NamespaceSelector.DeepCopy(),
+		Operations:         append([]string(nil), in.Operations...),
+		Users:              append([]string(nil), in.Users...),
+		Groups:             append([]string(nil), in.Groups...),
+		Names:              append([]string(nil), in.Names...),
+		AnnotationSelector: in.AnnotationSelector.DeepCopy(),
+	}
+	for _, k := range in.Kinds {
+		out.Kinds = append(out.Kinds, Kinds{
+			Kinds:     append([]string(nil), k.Kinds...),
+			ApiGroups: append([]string(nil), k.ApiGroups...),
+		})
+	}
+	return out
+}
+
+// Parameters are converted through JSON because fields of v1beta1 have the same names as those of
+// ParameterObject and the options of k8s-manifest-sigstore embedded in it.
+// Fields which v1beta1 does not have are returned as a JSON object to be kept in an annotation.
+func convertParametersToV1beta1(in k8smnfconfig.ParameterObject) (v1beta1.Parameters, string, error) {
+	out := v1beta1.Parameters{}
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return out, "", err
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return out, "", err
+	}
+	pruneEmptyReferences(&out)
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return out, "", err
+	}
+	converted := v1beta1ParameterNames()
+	for name, value := range fields {
+		if converted[name] || isEmptyJSON(value) {
+			delete(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return out, "", nil
+	}
+	unconverted, err := json.Marshal(fields)
+	if err != nil {
+		return out, "", err
+	}
+	return out, string(unconverted), nil
+}
+
+// convertParametersFromV1beta1 restores unconverted fields first, so fields of v1beta1 take precedence.
+func convertParametersFromV1beta1(in v1beta1.Parameters, unconverted string) (k8smnfconfig.ParameterObject, error) {
+	out := k8smnfconfig.ParameterObject{}
+	fields := map[string]json.RawMessage{}
+	if unconverted != "" {
+		if err := json.Unmarshal([]byte(unconverted), &fields); err != nil {
+			return out, errors.Wrap(err, fmt.Sprintf("annotation `%s` is invalid", UnconvertedParametersAnnotation))
+		}
+	}
+	converted, err := jsonFields(in)
+	if err != nil {
+		return out, err
+	}
+	for name, value := range converted {
+		fields[name] = value
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+// v1beta1ParameterNames returns the JSON names of the fields of v1beta1 parameters.
+func v1beta1ParameterNames() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(v1beta1.Parameters{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// pruneEmptyReferences drops references which are empty because structs of ParameterObject are always encoded.
+func pruneEmptyReferences(p *v1beta1.Parameters) {
+	ref := p.SignatureRef
+	if ref == nil {
+		return
+	}
+	ref.SignatureResourceRef = nonEmptyResourceRef(ref.SignatureResourceRef)
+	ref.ProvenanceResourceRef = nonEmptyResourceRef(ref.ProvenanceResourceRef)
+	for i := range ref.Refs {
+		ref.Refs[i].SignatureResourceRef = nonEmptyResourceRef(ref.Refs[i].SignatureResourceRef)
+		ref.Refs[i].ProvenanceResourceRef = nonEmptyResourceRef(ref.Refs[i].ProvenanceResourceRef)
+	}
+	if ref.ImageRef == "" && ref.SignatureResourceRef == nil && ref.ProvenanceResourceRef == nil && len(ref.Refs) == 0 {
+		p.SignatureRef = nil
+	}
+}
+
+func nonEmptyResourceRef(ref *v1beta1.ResourceRef) *v1beta1.ResourceRef {
+	if ref == nil || *ref == (v1beta1.ResourceRef{}) {
+		return nil
+	}
+	return ref
+}
+
+func jsonFields(obj interface{}) (map[string]json.RawMessage, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// isEmptyJSON reports whether a value is a zero value which is not worth keeping.
+func isEmptyJSON(value json.RawMessage) bool {
+	switch string(bytes.TrimSpace(value)) {
+	case "", "null", "{}", "[]", `""`, "false", "0":
+		return true
+	}
+	return false
+}
+
+func convertStatusToV1beta1(in ManifestIntegrityProfileStatus) v1beta1.ManifestIntegrityProfileStatus {
+	out := v1beta1.ManifestIntegrityProfileStatus{
+		AllowCount:  in.AllowCount,
+		DenyCount:   in.DenyCount,
+		ErrorCount:  in.ErrorCount,
+		DetectCount: in.DetectCount,
+		Action:      in.Action,
+	}
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+	if in.LastEvaluatedTime != nil {
+		out.LastEvaluatedTime = in.LastEvaluatedTime.DeepCopy()
+	}
+	for _, v := range in.Violations {
+		if v == nil {
+			continue
+		}
+		out.Violations = append(out.Violations, v1beta1.ViolationDetail{
+			Namespace: v.Namespace,
+			Kind:      v.Kind,
+			Name:      v.Name,
+			Message:   v.Message,
+			Timestamp: v.Timestamp,
+		})
+	}
+	return out
+}
+
+func convertStatusFromV1beta1(in v1beta1.ManifestIntegrityProfileStatus) ManifestIntegrityProfileStatus {
+	out := ManifestIntegrityProfileStatus{
+		AllowCount:  in.AllowCount,
+		DenyCount:   in.DenyCount,
+		ErrorCount:  in.ErrorCount,
+		DetectCount: in.DetectCount,
+		Action:      in.Action,
+	}
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+	if in.LastEvaluatedTime != nil {
+		out.LastEvaluatedTime = in.LastEvaluatedTime.DeepCopy()
+	}
+	for _, v := range in.Violations {
+		out.Violations = append(out.Violations, &ViolationDetail{
+			Namespace: v.Namespace,
+			Kind:      v.Kind,
+			Name:      v.Name,
+			Message:   v.Message,
+			Timestamp: v.Timestamp,
+		})
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1beta1"
+)
+
+func TestConversionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		// v1alpha1 profile as JSON, so that options of k8s-manifest-sigstore are given as users write them
+		profile string
+		// whether some parameters have no field in v1beta1 and are kept in the annotation
+		unconverted bool
+	}{
+		{
+			name:    "empty",
+			profile: `{"metadata": {"name": "empty"}}`,
+		},
+		{
+			name: "converted fields",
+			profile: `{
+  "metadata": {"name": "sample", "labels": {"app": "sample"}, "annotations": {"owner": "team-a"}},
+  "spec": {
+    "action": "detect",
+    "match": {
+      "kinds": [{"kinds": ["ConfigMap"], "apiGroups": [""]}],
+      "namespaces": ["sample-*"],
+      "excludedNamespaces": ["kube-*"],
+      "labelSelector": {"matchLabels": {"app": "sample"}},
+      "operations": ["CREATE"],
+      "users": ["system:admin"],
+      "names": ["sample-cm"],
+      "annotationSelector": {"matchExpressions": [{"key": "skip", "operator": "DoesNotExist"}]}
+    },
+    "parameters": {
+      "signatureRef": {
+        "imageRef": "registry.example.com/manifests/{{.Name}}:latest",
+        "provenanceResourceRef": {"name": "provenance", "namespace": "{{.Namespace}}"},
+        "refs": [{"signatureResourceRef": {"kind": "Secret", "name": "sig-{{.Name}}"}}]
+      },
+      "annotationDomains": ["cosign.sigstore.dev"],
+      "provenanceRequirements": {"builderIDs": ["https://github.com/Attestations/*"]},
+      "failurePolicy": "fail-closed",
+      "keyConfigs": [
+        {"keySecretName": "keyring", "keySecretNamespace": "integrity-shield-operator-system"},
+        {"keySecretName": "keyring-without-namespace"}
+      ],
+      "signers": ["signer@example.com"],
+      "ignoreFields": [{"fields": ["data.generated"], "objects": [{"kind": "ConfigMap"}]}],
+      "skipUsers": [{"users": ["system:serviceaccount:kube-system:*"]}],
+      "targetServiceAccount": ["system:serviceaccount:sample-ns:deployer"]
+    }
+  },
+  "status": {
+    "allowCount": 3,
+    "denyCount": 1,
+    "action": "detect",
+    "conditions": [{"type": "Valid", "status": "True", "reason": "Valid", "message": "", "lastTransitionTime": "2021-09-01T00:00:00Z"}],
+    "violations": [{"namespace": "sample-ns", "kind": "ConfigMap", "name": "sample-cm", "message": "no signature found"}]
+  }
+}`,
+		},
+		{
+			name: "unconverted options of k8s-manifest-sigstore",
+			profile: `{
+  "metadata": {"name": "options"},
+  "spec": {
+    "parameters": {
+      "signers": ["signer@example.com"],
+      "maxResourceManifestNum": 5,
+      "skipObjects": [{"kind": "Secret", "name": "generated-*"}]
+    }
+  }
+}`,
+			unconverted: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &ManifestIntegrityProfile{}
+			if err := json.Unmarshal([]byte(tc.profile), src); err != nil {
+				t.Fatal(err)
+			}
+			hub := &v1beta1.ManifestIntegrityProfile{}
+			if err := src.ConvertTo(hub); err != nil {
+				t.Fatalf("failed to convert to v1beta1: %s", err.Error())
+			}
+			if _, found := hub.Annotations[UnconvertedParametersAnnotation]; found != tc.unconverted {
+				t.Errorf("expected the annotation of unconverted parameters to be found=%v, but got %v", tc.unconverted, hub.Annotations)
+			}
+			hubJSON, err := json.Marshal(hub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(hubJSON), `"keySecretNamespace":""`) {
+				t.Errorf("empty keySecretNamespace is encoded in v1beta1: %s", string(hubJSON))
+			}
+
+			dst := &ManifestIntegrityProfile{}
+			if err := dst.ConvertFrom(hub); err != nil {
+				t.Fatalf("failed to convert from v1beta1: %s", err.Error())
+			}
+			if want, got := toGenericJSON(t, src), toGenericJSON(t, dst); !reflect.DeepEqual(want, got) {
+				wantBytes, _ := json.Marshal(want)
+				gotBytes, _ := json.Marshal(got)
+				t.Errorf("profile is changed by the round trip\nwant: %s\ngot:  %s", string(wantBytes), string(gotBytes))
+			}
+		})
+	}
+}
+
+func toGenericJSON(t *testing.T, obj interface{}) map[string]interface{} {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generic := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		t.Fatal(err)
+	}
+	return generic
+}
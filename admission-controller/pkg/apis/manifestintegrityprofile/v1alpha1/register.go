@@ -48,6 +48,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&ManifestIntegrityProfile{},
 		&ManifestIntegrityProfileList{},
+		&NamespacedManifestIntegrityProfile{},
+		&NamespacedManifestIntegrityProfileList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -27,12 +27,16 @@ import (
 
 var layout = "2006-01-02 15:04:05"
 
-const maxHistoryLength = 10
+// DefaultHistorySize is the number of violations kept in the status by default
+const DefaultHistorySize = 10
 
 // ManifestIntegrityProfileSpec defines the desired state of AppEnforcePolicy
 type ManifestIntegrityProfileSpec struct {
 	Match      MatchCondition               `json:"match,omitempty"`
 	Parameters k8smnfconfig.ParameterObject `json:"parameters,omitempty"`
+	// action for requests which the profile would deny: enforce, detect or audit-only.
+	// if empty, constraint-config decides whether the profile is enforced.
+	Action string `json:"action,omitempty"`
 }
 
 type MatchCondition struct {
@@ -41,6 +45,15 @@ type MatchCondition struct {
 	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"`
 	LabelSelector      *metav1.LabelSelector `json:"labelSelector,omitempty"`
 	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// operations of requests, e.g. CREATE or UPDATE. DELETE is matched only if it is listed
+	Operations []string `json:"operations,omitempty"`
+	// patterns of the requesting user name and of any of its groups
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// patterns of the name of the requested resource
+	Names []string `json:"names,omitempty"`
+	// selector on annotations of the requested resource
+	AnnotationSelector *metav1.LabelSelector `json:"annotationSelector,omitempty"`
 }
 
 type Kinds struct {
@@ -50,10 +63,27 @@ type Kinds struct {
 
 // ManifestIntegrityProfileStatus defines the observed state of ManifestIntegrityProfile
 type ManifestIntegrityProfileStatus struct {
-	DenyCount  int                `json:"denyCount,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// number of requests which the profile allowed, denied, failed to evaluate and allowed only in detection
+	AllowCount        int          `json:"allowCount,omitempty"`
+	DenyCount         int          `json:"denyCount,omitempty"`
+	ErrorCount        int          `json:"errorCount,omitempty"`
+	DetectCount       int          `json:"detectCount,omitempty"`
+	LastEvaluatedTime *metav1.Time `json:"lastEvaluatedTime,omitempty"`
+	// action applied to the last evaluated request
+	Action string `json:"action,omitempty"`
+	// latest violations, newest first
 	Violations []*ViolationDetail `json:"violations,omitempty"`
 }
 
+// Condition types of ManifestIntegrityProfile
+const (
+	// the profile is loaded and used for admission requests
+	ConditionReady = "Ready"
+	// the profile has an invalid match condition or parameters
+	ConditionInvalid = "Invalid"
+)
+
 type ViolationDetail struct {
 	Namespace string `json:"namespace,omitempty"`
 	Kind      string `json:"kind,omitempty"`
@@ -72,6 +102,7 @@ type ViolationDetail struct {
 // For more details of code-generator, please visit https://github.com/kubernetes/code-generator
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // ManifestIntegrityProfile is the CRD. Use this command to generate deepcopy for it:
+// +kubebuilder:storageversion
 type ManifestIntegrityProfile struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -89,29 +120,75 @@ type ManifestIntegrityProfileList struct {
 	Items           []ManifestIntegrityProfile `json:"items"`
 }
 
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +resource:path=namespacedmanifestintegrityprofile,scope=Namespaced
+
+// NamespacedManifestIntegrityProfile is a profile which tenants manage in their own namespace.
+// It only verifies resources in its namespace, and its results are combined with those of cluster profiles,
+// so it can tighten but never loosen the policy of the cluster.
+type NamespacedManifestIntegrityProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ManifestIntegrityProfileSpec   `json:"spec,omitempty"`
+	Status ManifestIntegrityProfileStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NamespacedManifestIntegrityProfileList contains a list of NamespacedManifestIntegrityProfile
+type NamespacedManifestIntegrityProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacedManifestIntegrityProfile `json:"items"`
+}
+
+// AsProfile returns a copy of the profile as a ManifestIntegrityProfile with the namespace set,
+// so that it is evaluated in the same way as cluster profiles.
+func (p *NamespacedManifestIntegrityProfile) AsProfile() ManifestIntegrityProfile {
+	mip := ManifestIntegrityProfile{}
+	p.ObjectMeta.DeepCopyInto(&mip.ObjectMeta)
+	p.Spec.DeepCopyInto(&mip.Spec)
+	p.Status.DeepCopyInto(&mip.Status)
+	mip.SetGroupVersionKind(SchemeGroupVersion.WithKind("ManifestIntegrityProfile"))
+	return mip
+}
+
+// QualifiedName returns the name of the profile in results, events and status updates:
+// `<namespace>/<name>` for a namespaced profile and `<name>` for a cluster profile.
+func (self *ManifestIntegrityProfile) QualifiedName() string {
+	if self.Namespace == "" {
+		return self.Name
+	}
+	return self.Namespace + "/" + self.Name
+}
+
 func (p *MatchCondition) DeepCopyInto(p2 *MatchCondition) {
 	copier.Copy(&p2, &p)
 }
 
-func (self *ManifestIntegrityProfile) UpdateStatus(request admission.Request, errMsg string) *ManifestIntegrityProfile {
-
-	// Increment DenyCount
-	self.Status.DenyCount = self.Status.DenyCount + 1
+// AddViolations puts violations at the head of the history and keeps at most historySize of them.
+// Violations must be ordered newest first.
+func (self *ManifestIntegrityProfile) AddViolations(violations []*ViolationDetail, historySize int) {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	newLatestEvents := []*ViolationDetail{}
+	newLatestEvents = append(newLatestEvents, violations...)
+	newLatestEvents = append(newLatestEvents, self.Status.Violations...)
+	if len(newLatestEvents) > historySize {
+		newLatestEvents = newLatestEvents[:historySize]
+	}
+	self.Status.Violations = newLatestEvents
+}
 
-	// Update Latest events
-	violation := &ViolationDetail{
+// NewViolationDetail returns a violation of the request which is observed now.
+func NewViolationDetail(request admission.Request, errMsg string) *ViolationDetail {
+	return &ViolationDetail{
 		Kind:      request.Kind.Kind,
 		Namespace: request.Namespace,
 		Name:      request.Name,
 		Message:   errMsg,
 		Timestamp: time.Now().UTC().Format(layout),
 	}
-	newLatestEvents := []*ViolationDetail{}
-	newLatestEvents = append(newLatestEvents, violation)
-	newLatestEvents = append(newLatestEvents, self.Status.Violations...)
-	if len(newLatestEvents) > maxHistoryLength {
-		newLatestEvents = newLatestEvents[:maxHistoryLength]
-	}
-	self.Status.Violations = newLatestEvents
-	return self
 }
@@ -21,6 +21,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -56,7 +57,7 @@ func (in *ManifestIntegrityProfile) DeepCopyInto(out *ManifestIntegrityProfile)
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -132,6 +133,28 @@ func (in *ManifestIntegrityProfileSpec) DeepCopy() *ManifestIntegrityProfileSpec
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ManifestIntegrityProfileStatus) DeepCopyInto(out *ManifestIntegrityProfileStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastEvaluatedTime != nil {
+		in, out := &in.LastEvaluatedTime, &out.LastEvaluatedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Violations != nil {
+		in, out := &in.Violations, &out.Violations
+		*out = make([]*ViolationDetail, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(ViolationDetail)
+				**out = **in
+			}
+		}
+	}
 	return
 }
 
@@ -154,3 +177,80 @@ func (in *MatchCondition) DeepCopy() *MatchCondition {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedManifestIntegrityProfile) DeepCopyInto(out *NamespacedManifestIntegrityProfile) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedManifestIntegrityProfile.
+func (in *NamespacedManifestIntegrityProfile) DeepCopy() *NamespacedManifestIntegrityProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedManifestIntegrityProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedManifestIntegrityProfile) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacedManifestIntegrityProfileList) DeepCopyInto(out *NamespacedManifestIntegrityProfileList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NamespacedManifestIntegrityProfile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedManifestIntegrityProfileList.
+func (in *NamespacedManifestIntegrityProfileList) DeepCopy() *NamespacedManifestIntegrityProfileList {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacedManifestIntegrityProfileList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NamespacedManifestIntegrityProfileList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ViolationDetail) DeepCopyInto(out *ViolationDetail) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ViolationDetail.
+func (in *ViolationDetail) DeepCopy() *ViolationDetail {
+	if in == nil {
+		return nil
+	}
+	out := new(ViolationDetail)
+	in.DeepCopyInto(out)
+	return out
+}
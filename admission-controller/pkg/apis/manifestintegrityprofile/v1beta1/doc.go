@@ -0,0 +1,23 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// +k8s:deepcopy-gen=package
+
+// Package v1beta1 is the v1beta1 version of the API.
+// Its types are curated and do not embed types of k8s-manifest-sigstore,
+// so that the schema of the API changes only when this package changes.
+// +groupName=apis.integrityshield.io
+package v1beta1
@@ -0,0 +1,212 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Failure policies of a profile
+const (
+	FailurePolicyOpen   = "fail-open"
+	FailurePolicyClosed = "fail-closed"
+)
+
+// Actions of a profile for requests which it would deny
+const (
+	ActionEnforce   = "enforce"
+	ActionDetect    = "detect"
+	ActionAuditOnly = "audit-only"
+)
+
+// ManifestIntegrityProfileSpec defines the desired state of ManifestIntegrityProfile
+type ManifestIntegrityProfileSpec struct {
+	// Match selects requests which are verified by the profile
+	Match MatchCondition `json:"match,omitempty"`
+	// Parameters tell how the requested resources are verified
+	Parameters Parameters `json:"parameters,omitempty"`
+	// Action is applied to requests which the profile would deny. `enforce` denies them, `detect` allows
+	// them with a warning and `audit-only` allows them silently; all of them are recorded in the status.
+	// If empty, constraint-config decides whether the profile is enforced.
+	// +kubebuilder:validation:Enum=enforce;detect;audit-only
+	Action string `json:"action,omitempty"`
+}
+
+// MatchCondition selects requests by the kind, namespace and labels of the requested resource.
+// Names of namespaces and kinds accept a wildcard `*`.
+type MatchCondition struct {
+	Kinds              []Kinds               `json:"kinds,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty"`
+	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"`
+	LabelSelector      *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// operations of requests, e.g. CREATE or UPDATE. DELETE is matched only if it is listed
+	Operations []string `json:"operations,omitempty"`
+	// patterns of the requesting user name and of any of its groups
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// patterns of the name of the requested resource
+	Names []string `json:"names,omitempty"`
+	// selector on annotations of the requested resource
+	AnnotationSelector *metav1.LabelSelector `json:"annotationSelector,omitempty"`
+}
+
+type Kinds struct {
+	Kinds     []string `json:"kinds,omitempty"`
+	ApiGroups []string `json:"apiGroups,omitempty"`
+}
+
+// Parameters tell how resources matched by the profile are verified.
+type Parameters struct {
+	// SignatureRef tells where signatures are stored when they are not in annotations of the resource
+	SignatureRef *SignatureRef `json:"signatureRef,omitempty"`
+	// AnnotationDomains are domains of signature annotations, tried in order
+	AnnotationDomains []string `json:"annotationDomains,omitempty"`
+	// Signers are patterns of signers which are allowed to sign the resource
+	Signers []string `json:"signers,omitempty"`
+	// KeyConfigs are Secrets which contain public keys to verify signatures
+	KeyConfigs []KeyConfig `json:"keyConfigs,omitempty"`
+	// ProvenanceRequirements are rules on SLSA provenance of the resource
+	ProvenanceRequirements *ProvenanceRequirements `json:"provenanceRequirements,omitempty"`
+	// FailurePolicy decides the result when the profile cannot be evaluated; the failure policy of the admission controller by default
+	// +kubebuilder:validation:Enum=fail-open;fail-closed
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// IgnoreFields are fields which may differ from the signed manifest
+	IgnoreFields []ObjectFieldBinding `json:"ignoreFields,omitempty"`
+	// InScopeObjects limit verification to these objects if specified
+	InScopeObjects []ObjectReference `json:"inScopeObjects,omitempty"`
+	// SkipObjects are objects which are not verified
+	SkipObjects []ObjectReference `json:"skipObjects,omitempty"`
+	// SkipUsers are users whose requests on the objects are not verified
+	SkipUsers []ObjectUserBinding `json:"skipUsers,omitempty"`
+	// TargetServiceAccount are service accounts whose requests are verified
+	TargetServiceAccount []string `json:"targetServiceAccount,omitempty"`
+}
+
+// SignatureRef points to signature stores. All fields accept Go-template placeholders
+// which are expanded with the verified resource, e.g. `{{.Namespace}}` and `{{.Name}}`.
+type SignatureRef struct {
+	// ImageRef is an OCI image which contains the signed manifest
+	ImageRef              string       `json:"imageRef,omitempty"`
+	SignatureResourceRef  *ResourceRef `json:"signatureResourceRef,omitempty"`
+	ProvenanceResourceRef *ResourceRef `json:"provenanceResourceRef,omitempty"`
+	// Refs are tried in order after the reference above until one of them verifies the resource
+	Refs []SignatureSource `json:"refs,omitempty"`
+}
+
+type SignatureSource struct {
+	ImageRef              string       `json:"imageRef,omitempty"`
+	SignatureResourceRef  *ResourceRef `json:"signatureResourceRef,omitempty"`
+	ProvenanceResourceRef *ResourceRef `json:"provenanceResourceRef,omitempty"`
+}
+
+// ResourceRef points to a ConfigMap or a Secret.
+type ResourceRef struct {
+	// Kind is ConfigMap (default) or Secret
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ProvenanceRequirements are rules on SLSA provenance predicates of a resource.
+// Patterns accept a wildcard `*`. A resource is allowed if at least one attestation satisfies all rules.
+type ProvenanceRequirements struct {
+	BuilderIDs         []string            `json:"builderIDs,omitempty"`
+	SourceRepositories []string            `json:"sourceRepositories,omitempty"`
+	BuildTypes         []string            `json:"buildTypes,omitempty"`
+	Materials          []MaterialReference `json:"materials,omitempty"`
+}
+
+// MaterialReference requires a material whose URI matches the pattern and whose digests contain all given digests.
+type MaterialReference struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type KeyConfig struct {
+	KeySecretName      string `json:"keySecretName"`
+	KeySecretNamespace string `json:"keySecretNamespace,omitempty"`
+}
+
+// ObjectReference selects objects. All fields accept a wildcard `*` and empty fields match any object.
+type ObjectReference struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ObjectFieldBinding binds fields to the objects which have them.
+type ObjectFieldBinding struct {
+	Fields  []string          `json:"fields"`
+	Objects []ObjectReference `json:"objects,omitempty"`
+}
+
+// ObjectUserBinding binds users to the objects which they operate.
+type ObjectUserBinding struct {
+	Objects []ObjectReference `json:"objects,omitempty"`
+	Users   []string          `json:"users"`
+}
+
+// ManifestIntegrityProfileStatus defines the observed state of ManifestIntegrityProfile
+type ManifestIntegrityProfileStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// number of requests which the profile allowed, denied, failed to evaluate and allowed only in detection
+	AllowCount        int          `json:"allowCount,omitempty"`
+	DenyCount         int          `json:"denyCount,omitempty"`
+	ErrorCount        int          `json:"errorCount,omitempty"`
+	DetectCount       int          `json:"detectCount,omitempty"`
+	LastEvaluatedTime *metav1.Time `json:"lastEvaluatedTime,omitempty"`
+	// action applied to the last evaluated request
+	Action string `json:"action,omitempty"`
+	// latest violations, newest first
+	Violations []ViolationDetail `json:"violations,omitempty"`
+}
+
+type ViolationDetail struct {
+	Namespace string `json:"namespace,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=manifestintegrityprofiles,scope=Cluster,shortName=mip
+// +kubebuilder:subresource:status
+
+// ManifestIntegrityProfile selects requests and tells how the requested resources are verified.
+type ManifestIntegrityProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ManifestIntegrityProfileSpec   `json:"spec,omitempty"`
+	Status ManifestIntegrityProfileStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ManifestIntegrityProfileList contains a list of ManifestIntegrityProfile
+type ManifestIntegrityProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ManifestIntegrityProfile `json:"items"`
+}
@@ -17,16 +17,85 @@
 package config
 
 import (
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	shieldclient "github.com/IBM/integrity-shield/integrity-shield-server/pkg/client"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
 	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type AdmissionControllerConfig struct {
-	InScopeNamespaceSelector NamespaceSelector `json:"inScopeNamespaceSelector,omitempty"`
-	Allow                    Allow             `json:"allow,omitempty"`
-	SideEffect               SideEffectConfig  `json:"sideEffect,omitempty"`
-	Mode                     string            `json:"mode,omitempty"`
-	Options                  []string          `json:"option,omitempty"`
+	InScopeNamespaceSelector NamespaceSelector        `json:"inScopeNamespaceSelector,omitempty"`
+	Allow                    Allow                    `json:"allow,omitempty"`
+	SideEffect               SideEffectConfig         `json:"sideEffect,omitempty"`
+	Mode                     string                   `json:"mode,omitempty"`
+	Options                  []string                 `json:"option,omitempty"`
+	Audit                    k8smnfconfig.AuditConfig `json:"audit,omitempty"`
+	// failure policy applied when profiles cannot be loaded; fail-open by default
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// max number of profiles evaluated concurrently for a request
+	MaxConcurrentProfiles int `json:"maxConcurrentProfiles,omitempty"`
+	// request handler which verifies matched requests; in-process by default
+	RequestHandler RequestHandlerConfig `json:"requestHandler,omitempty"`
+	// cache of decisions for identical requests
+	DecisionCache DecisionCacheConfig `json:"decisionCache,omitempty"`
+	// verification annotations stamped by the mutating webhook
+	VerificationAnnotation VerificationAnnotationConfig `json:"verificationAnnotation,omitempty"`
+}
+
+const defaultMaxConcurrentProfiles = 8
+
+const (
+	defaultDecisionCacheTTLSeconds = 5
+	defaultDecisionCacheMaxEntries = 1000
+)
+
+// Modes of the request handler
+const (
+	RequestHandlerModeInProcess = "in-process"
+	RequestHandlerModeRemote    = "remote"
+)
+
+type RequestHandlerConfig struct {
+	Mode string `json:"mode,omitempty"`
+	// client of integrity shield server used in remote mode
+	Remote shieldclient.Config `json:"remote,omitempty"`
+}
+
+func (c RequestHandlerConfig) IsRemote() bool {
+	return c.Mode == RequestHandlerModeRemote
+}
+
+type DecisionCacheConfig struct {
+	// seconds for which a decision is reused; a negative value disables the cache
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+func (c DecisionCacheConfig) Enabled() bool {
+	return c.TTLSeconds >= 0
+}
+
+func (c DecisionCacheConfig) GetTTL() time.Duration {
+	if c.TTLSeconds == 0 {
+		return defaultDecisionCacheTTLSeconds * time.Second
+	}
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+func (c DecisionCacheConfig) GetMaxEntries() int {
+	if c.MaxEntries <= 0 {
+		return defaultDecisionCacheMaxEntries
+	}
+	return c.MaxEntries
+}
+
+type VerificationAnnotationConfig struct {
+	// must be enabled together with the mutating webhook; verification annotations are neither stamped
+	// nor checked otherwise
+	Enabled bool `json:"enabled,omitempty"`
 }
 
 type NamespaceSelector struct {
@@ -41,6 +110,8 @@ type Allow struct {
 type SideEffectConfig struct {
 	// ManifestIntegrityProfile
 	UpdateMIPStatusForDeniedRequest bool `json:"updateMIPStatusForDeniedRequest"`
+	// number of violations kept in the status of ManifestIntegrityProfile
+	MIPStatusHistorySize int `json:"mipStatusHistorySize,omitempty"`
 }
 
 func (ns NamespaceSelector) Match(rns string) bool {
@@ -89,6 +160,27 @@ func (allow Allow) Match(kind metav1.GroupVersionKind) bool {
 	return false
 }
 
+func (c *AdmissionControllerConfig) GetFailurePolicy() string {
+	if c.FailurePolicy == k8smnfconfig.FailurePolicyClosed {
+		return k8smnfconfig.FailurePolicyClosed
+	}
+	return k8smnfconfig.FailurePolicyOpen
+}
+
+func (c *AdmissionControllerConfig) GetMaxConcurrentProfiles() int {
+	if c.MaxConcurrentProfiles <= 0 {
+		return defaultMaxConcurrentProfiles
+	}
+	return c.MaxConcurrentProfiles
+}
+
+func (c *AdmissionControllerConfig) GetMIPStatusHistorySize() int {
+	if c.SideEffect.MIPStatusHistorySize <= 0 {
+		return miprofile.DefaultHistorySize
+	}
+	return c.SideEffect.MIPStatusHistorySize
+}
+
 func CheckIfDetectOnly(mode string) bool {
 	return mode == "detect"
 }
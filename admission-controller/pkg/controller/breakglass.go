@@ -0,0 +1,145 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	breakglass "github.com/IBM/integrity-shield/admission-controller/pkg/apis/breakglass"
+	bgv1alpha1 "github.com/IBM/integrity-shield/admission-controller/pkg/apis/breakglass/v1alpha1"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var breakGlassGVR = schema.GroupVersionResource{
+	Group:    breakglass.GroupName,
+	Version:  "v1alpha1",
+	Resource: "breakglasses",
+}
+
+// LoadBreakGlasses returns break-glass grants which have not expired yet.
+// They are read from the informer cache once it is synced, and from the API server before that.
+// If the BreakGlass CRD is not installed, no grant is returned.
+func LoadBreakGlasses(ctx context.Context) ([]bgv1alpha1.BreakGlass, error) {
+	var objs []runtime.Object
+	if rc := getSyncedCache(); rc != nil {
+		// the CRD was not served when the cache started
+		if rc.breakGlassLister == nil {
+			return nil, nil
+		}
+		cached, err := rc.breakGlassLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		objs = cached
+	} else {
+		config, err := kubeutil.GetKubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		list, err := client.Resource(breakGlassGVR).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for i := range list.Items {
+			objs = append(objs, &list.Items[i])
+		}
+	}
+	now := time.Now()
+	grants := []bgv1alpha1.BreakGlass{}
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		var bg bgv1alpha1.BreakGlass
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &bg); err != nil {
+			log.Warningf("failed to convert BreakGlass `%s`; %s", u.GetName(), err.Error())
+			continue
+		}
+		// expired grants are ignored
+		if !bg.IsActive(now) {
+			continue
+		}
+		grants = append(grants, bg)
+	}
+	// sorted by name as the API server returns them, so that the same grant matches first
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].Name < grants[j].Name
+	})
+	return grants, nil
+}
+
+// matchBreakGlass returns the first grant which matches the request.
+func matchBreakGlass(req admission.Request, grants []bgv1alpha1.BreakGlass) *bgv1alpha1.BreakGlass {
+	for i := range grants {
+		bg := &grants[i]
+		if !bg.HasCondition() {
+			continue
+		}
+		if len(bg.Spec.Namespaces) != 0 && !k8smnfutil.MatchWithPatternArray(req.Namespace, bg.Spec.Namespaces) {
+			continue
+		}
+		if len(bg.Spec.Users) != 0 && !k8smnfutil.MatchWithPatternArray(req.UserInfo.Username, bg.Spec.Users) {
+			continue
+		}
+		if len(bg.Spec.Groups) != 0 && !matchAnyGroup(req.UserInfo.Groups, bg.Spec.Groups) {
+			continue
+		}
+		if len(bg.Spec.Kinds) != 0 && !checkKindMatch(req, bg.Spec.Kinds) {
+			continue
+		}
+		return bg
+	}
+	return nil
+}
+
+func matchAnyGroup(groups, patterns []string) bool {
+	for _, g := range groups {
+		if k8smnfutil.MatchWithPatternArray(g, patterns) {
+			return true
+		}
+	}
+	return false
+}
+
+func breakGlassMessage(bg *bgv1alpha1.BreakGlass) string {
+	msg := fmt.Sprintf("verification is bypassed by break-glass `%s` until %s", bg.Name, bg.Spec.ExpiresAt.UTC().Format(time.RFC3339))
+	if bg.Spec.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, bg.Spec.Reason)
+	}
+	return msg
+}
@@ -0,0 +1,275 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	mipclientset "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned"
+	mipinformers "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/informers/externalversions"
+	miplisters "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/listers/manifestintegrityprofile/v1alpha1"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	kubeinformers "k8s.io/client-go/informers"
+	kubeclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DefaultCacheResyncPeriod is the resync period of the informers for profiles and namespaces.
+const DefaultCacheResyncPeriod = 10 * time.Minute
+
+// resourceCache serves ManifestIntegrityProfiles and Namespaces to admission requests from informer caches.
+type resourceCache struct {
+	profileLister   miplisters.ManifestIntegrityProfileLister
+	namespaceLister corelisters.NamespaceLister
+	// nil if namespaced profiles were not served when the cache started
+	namespacedProfileLister cache.GenericLister
+	// nil if break-glass grants were not served when the cache started
+	breakGlassLister cache.GenericLister
+	synced           []cache.InformerSynced
+}
+
+var (
+	sharedCache   *resourceCache
+	sharedCacheMu sync.RWMutex
+)
+
+// RunResourceCache starts shared informers for profiles and namespaces and blocks until ctx is done.
+// Until the caches are synced, profiles and namespaces are read from the API server.
+func RunResourceCache(ctx context.Context, config *rest.Config, resync time.Duration) error {
+	mipClient, err := mipclientset.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a client for ManifestIntegrityProfile")
+	}
+	kubeClient, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a kubernetes client")
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a metadata client")
+	}
+	mipFactory := mipinformers.NewSharedInformerFactory(mipClient, resync)
+	kubeFactory := kubeinformers.NewSharedInformerFactory(kubeClient, resync)
+	// configs of the admission controller and the request handler are in its namespace
+	configFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resync, kubeinformers.WithNamespace(podNamespace()))
+	// only metadata of secrets is cached to find changes of keys
+	metadataFactory := metadatainformer.NewSharedInformerFactory(metadataClient, resync)
+	profileInformer := mipFactory.Apis().V1alpha1().ManifestIntegrityProfiles()
+	namespaceInformer := kubeFactory.Core().V1().Namespaces()
+	configMapInformer := configFactory.Core().V1().ConfigMaps()
+	secretInformer := metadataFactory.ForResource(secretGVR)
+
+	rc := &resourceCache{
+		profileLister:   profileInformer.Lister(),
+		namespaceLister: namespaceInformer.Lister(),
+		synced: []cache.InformerSynced{
+			profileInformer.Informer().HasSynced,
+			namespaceInformer.Informer().HasSynced,
+			configMapInformer.Informer().HasSynced,
+			secretInformer.Informer().HasSynced,
+		},
+	}
+	// namespaced profiles and break-glass grants are optional; without their CRDs informers would never sync
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a dynamic client")
+	}
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resync)
+	served, err := namespacedProfilesServed(kubeClient.Discovery())
+	if err != nil {
+		log.Warningf("failed to check if NamespacedManifestIntegrityProfile is served; %s", err.Error())
+	}
+	if served {
+		nsProfileInformer := dynamicFactory.ForResource(namespacedProfileGVR)
+		rc.namespacedProfileLister = nsProfileInformer.Lister()
+		rc.synced = append(rc.synced, nsProfileInformer.Informer().HasSynced)
+		nsProfileInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				touchNamespacedProfileStatus(obj)
+			},
+			UpdateFunc: func(_, newObj interface{}) {
+				touchNamespacedProfileStatus(newObj)
+			},
+		})
+		nsProfileInformer.Informer().AddEventHandler(specChangeHandler("a namespaced profile is changed"))
+	}
+	bgServed, err := resourceServed(kubeClient.Discovery(), breakGlassGVR)
+	if err != nil {
+		log.Warningf("failed to check if BreakGlass is served; %s", err.Error())
+	}
+	if bgServed {
+		bgInformer := dynamicFactory.ForResource(breakGlassGVR)
+		rc.breakGlassLister = bgInformer.Lister()
+		rc.synced = append(rc.synced, bgInformer.Informer().HasSynced)
+	}
+
+	sharedCacheMu.Lock()
+	sharedCache = rc
+	sharedCacheMu.Unlock()
+
+	// conditions of a new or changed profile are written with the next status update
+	profileInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			touchProfileStatus(obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			touchProfileStatus(newObj)
+		},
+	})
+
+	// cached decisions are dropped when anything which decides them is changed
+	profileInformer.Informer().AddEventHandler(specChangeHandler("a profile is changed"))
+	configMapInformer.Informer().AddEventHandler(configChangeHandler("a config is changed"))
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if namespaceLabelsChanged(oldObj, newObj) {
+				invalidateDecisionCache("labels of a namespace are changed")
+			}
+		},
+		DeleteFunc: func(interface{}) {
+			invalidateDecisionCache("a namespace is deleted")
+		},
+	})
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			invalidateIfKeySecret(rc, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if resourceVersionChanged(oldObj, newObj) {
+				invalidateIfKeySecret(rc, newObj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			invalidateIfKeySecret(rc, obj)
+		},
+	})
+
+	mipFactory.Start(ctx.Done())
+	kubeFactory.Start(ctx.Done())
+	configFactory.Start(ctx.Done())
+	metadataFactory.Start(ctx.Done())
+	dynamicFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), rc.synced...) {
+		log.Warning("informer caches for profiles and namespaces are not synced before stop")
+	} else {
+		log.Info("informer caches for profiles and namespaces are synced")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// ResourceCacheRunnable runs the resource cache in a manager. It runs on every replica
+// regardless of leader election because every replica serves admission requests.
+type ResourceCacheRunnable struct {
+	Config *rest.Config
+	Resync time.Duration
+}
+
+// Start implements manager.Runnable.
+func (r *ResourceCacheRunnable) Start(ctx context.Context) error {
+	return RunResourceCache(ctx, r.Config, r.Resync)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *ResourceCacheRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// CacheSyncCheck is a readiness check which passes once the informer caches are synced.
+func CacheSyncCheck(_ *http.Request) error {
+	rc := getSyncedCache()
+	if rc == nil {
+		return errors.New("informer caches for profiles and namespaces are not synced")
+	}
+	return nil
+}
+
+// getSyncedCache returns the shared cache only if all informers have been synced.
+func getSyncedCache() *resourceCache {
+	sharedCacheMu.RLock()
+	rc := sharedCache
+	sharedCacheMu.RUnlock()
+	if rc == nil {
+		return nil
+	}
+	for _, synced := range rc.synced {
+		if !synced() {
+			return nil
+		}
+	}
+	return rc
+}
+
+// listProfiles returns copies of cached profiles sorted by name, as the API server does for LIST.
+func (rc *resourceCache) listProfiles() ([]miprofile.ManifestIntegrityProfile, error) {
+	mips, err := rc.profileLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]miprofile.ManifestIntegrityProfile, 0, len(mips))
+	for _, mip := range mips {
+		profiles = append(profiles, *mip.DeepCopy())
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// newEvaluationContext decodes the request and lets it read namespaces from the cache if available.
+func newEvaluationContext(req admission.Request) *shield.EvaluationContext {
+	ec := shield.NewEvaluationContext(req)
+	if rc := getSyncedCache(); rc != nil {
+		ec.NamespaceLister = rc.namespaceLister
+	}
+	return ec
+}
+
+func touchProfileStatus(obj interface{}) {
+	mip, ok := obj.(*miprofile.ManifestIntegrityProfile)
+	if !ok || !needsConditionUpdate(mip) {
+		return
+	}
+	profileStatusRecorder.record(mip.Name, &profileStatusDelta{})
+}
+
+func touchNamespacedProfileStatus(obj interface{}) {
+	nmip, err := namespacedProfileFromObject(obj)
+	if err != nil {
+		return
+	}
+	mip := nmip.AsProfile()
+	if !needsConditionUpdate(&mip) {
+		return
+	}
+	profileStatusRecorder.record(mip.QualifiedName(), &profileStatusDelta{})
+}
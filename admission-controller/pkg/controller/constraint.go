@@ -18,215 +18,127 @@ package controller
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"strings"
 
 	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
 	mipclient "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/typed/manifestintegrityprofile/v1alpha1"
 	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/match"
 	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
-	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/pkg/errors"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/labels"
-	kubeclient "k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-func GetParametersFromConstraint(constraint miprofile.ManifestIntegrityProfileSpec) *k8smnfconfig.ParameterObject {
+// GetParametersFromConstraint returns the parameters of a profile.
+// A profile without its own failure policy follows the failure policy of the admission controller.
+func GetParametersFromConstraint(constraint miprofile.ManifestIntegrityProfileSpec, defaultFailurePolicy string) *k8smnfconfig.ParameterObject {
+	constraint.Parameters.Action = constraint.Action
+	if constraint.Parameters.FailurePolicy == "" {
+		constraint.Parameters.FailurePolicy = defaultFailurePolicy
+	}
 	return &constraint.Parameters
 }
 
-func LoadConstraints() ([]miprofile.ManifestIntegrityProfile, error) {
+// LoadConstraints returns all profiles. They are read from the informer cache once it is synced,
+// and from the API server before that.
+func LoadConstraints(ctx context.Context) ([]miprofile.ManifestIntegrityProfile, error) {
+	if rc := getSyncedCache(); rc != nil {
+		return rc.listProfiles()
+	}
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	clientset, err := mipclient.NewForConfig(config)
 	if err != nil {
 		log.Error(err)
-		return nil, nil
+		return nil, err
 	}
-	miplist, err := clientset.ManifestIntegrityProfiles().List(context.Background(), metav1.ListOptions{})
+	miplist, err := clientset.ManifestIntegrityProfiles().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		log.Error("failed to get ManifestIntegrityProfiles:", err.Error())
-		return nil, nil
+		return nil, err
 	}
 	return miplist.Items, nil
 }
 
-// Match
-func matchCheck(req admission.Request, match miprofile.MatchCondition) bool {
-	// check if excludedNamespace
-	if len(match.ExcludedNamespaces) != 0 {
-		for _, ens := range match.ExcludedNamespaces {
-			if k8smnfutil.MatchPattern(ens, req.Namespace) {
-				return false
-			}
-		}
-	}
-	// check if matched kinds/namespace/label
-	var nsMatched bool
-	var kindsMatched bool
-	var labelMatched bool
-	var nslabelMatched bool
-	nsMatched = checkNamespaceMatch(req, match.Namespaces)
-	kindsMatched = checkKindMatch(req, match.Kinds)
-	labelMatched = checkLabelMatch(req, match.LabelSelector)
-	nslabelMatched = checkNamespaceLabelMatch(req.Namespace, match.NamespaceSelector)
-
-	if nsMatched && kindsMatched && nslabelMatched && labelMatched {
-		return true
+// matchProfile checks the match condition of a profile. A namespaced profile only matches requests in its namespace.
+func matchProfile(ctx context.Context, ec *shield.EvaluationContext, constraint *miprofile.ManifestIntegrityProfile) (bool, string, error) {
+	if constraint.Namespace != "" && ec.Request.Namespace != constraint.Namespace {
+		return false, fmt.Sprintf("namespace `%s` is not the namespace of the profile", ec.Request.Namespace), nil
 	}
-	return false
+	return matchCheck(ctx, ec, constraint.Spec.Match)
 }
 
-func checkNamespaceLabelMatch(namespace string, labelSelector *metav1.LabelSelector) bool {
-	if labelSelector == nil {
-		return true
-	}
-	config, err := kubeutil.GetKubeConfig()
-	if err != nil {
-		return false
-	}
-	clientset, err := kubeclient.NewForConfig(config)
-	if err != nil {
-		log.Error(err)
-		return false
-	}
-	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
-	if err != nil {
-		log.Errorf("failed to get a namespace `%s`:`%s`", namespace, err.Error())
-		return false
-	}
-	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
-	if err != nil {
-		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
-		return false
-	}
-	labelsMap := ns.GetLabels()
-	labelsSet := labels.Set(labelsMap)
-	matched := selector.Matches(labelsSet)
-	return matched
+// Match
+// When the request does not match, the reason explains which condition did not match, so that
+// it reads as "not matched because <reason>".
+// An error is returned only when a dependency which is required to decide the match fails.
+func matchCheck(ctx context.Context, ec *shield.EvaluationContext, m miprofile.MatchCondition) (bool, string, error) {
+	req := ec.Request
+	target := match.Target{
+		Operation: string(req.Operation),
+		Username:  req.UserInfo.Username,
+		Groups:    req.UserInfo.Groups,
+		Group:     req.Kind.Group,
+		Kind:      req.Kind.Kind,
+		Namespace: req.Namespace,
+		Name:      requestedName(req, ec.Object),
+		Object:    ec.Object,
+		NamespaceLabels: func() (map[string]string, error) {
+			return ec.NamespaceLabels(ctx)
+		},
+	}
+	return match.Match(toMatchCondition(m), target)
 }
 
-func checkLabelMatch(req admission.Request, labelSelector *metav1.LabelSelector) bool {
-	if labelSelector == nil {
-		return true
+// toMatchCondition converts the match condition of a profile into the condition of the matcher shared with the observer.
+func toMatchCondition(m miprofile.MatchCondition) match.Condition {
+	return match.Condition{
+		Kinds:              toMatchKinds(m.Kinds),
+		Namespaces:         m.Namespaces,
+		ExcludedNamespaces: m.ExcludedNamespaces,
+		LabelSelector:      m.LabelSelector,
+		NamespaceSelector:  m.NamespaceSelector,
+		Operations:         m.Operations,
+		Users:              m.Users,
+		Groups:             m.Groups,
+		Names:              m.Names,
+		AnnotationSelector: m.AnnotationSelector,
 	}
-	var resource unstructured.Unstructured
-	objectBytes := req.AdmissionRequest.Object.Raw
-	err := json.Unmarshal(objectBytes, &resource)
-	if err != nil {
-		log.Errorf("failed to Unmarshal a requested object into %T; %s", resource, err.Error())
-		return false
-	}
-	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
-	if err != nil {
-		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
-		return false
-	}
-	labelsMap := resource.GetLabels()
-	labelsSet := labels.Set(labelsMap)
-	matched := selector.Matches(labelsSet)
-	return matched
 }
 
-func checkNamespaceMatch(req admission.Request, match []string) bool {
-	matched := false
-	if len(match) == 0 {
-		matched = true
-	} else {
-		// check if cluster scope
-		if req.Namespace == "" {
-			matched = true
-		}
-		for _, ns := range match {
-			if k8smnfutil.MatchPattern(ns, req.Namespace) {
-				matched = true
-			}
-		}
+func toMatchKinds(kinds []miprofile.Kinds) []match.Kinds {
+	matchKinds := []match.Kinds{}
+	for _, k := range kinds {
+		matchKinds = append(matchKinds, match.Kinds{Kinds: k.Kinds, ApiGroups: k.ApiGroups})
 	}
-	return matched
+	return matchKinds
 }
 
-func checkKindMatch(req admission.Request, match []miprofile.Kinds) bool {
-	matched := false
-	if len(match) == 0 {
-		matched = true
-	} else {
-		for _, kinds := range match {
-			kind := false
-			group := false
-			if len(kinds.Kinds) == 0 {
-				kind = true
-			} else {
-				for _, k := range kinds.Kinds {
-					if k8smnfutil.MatchPattern(k, req.Kind.Kind) {
-						kind = true
-					}
-				}
-			}
-			if len(kinds.ApiGroups) == 0 {
-				group = true
-			} else {
-				for _, g := range kinds.ApiGroups {
-					if k8smnfutil.MatchPattern(g, req.Kind.Group) {
-						group = true
-					}
-				}
-			}
-			if kind && group {
-				matched = true
-			}
-		}
+// requestedName returns the name of the requested resource. The name is empty in a request
+// which creates a resource with generateName.
+func requestedName(req admission.Request, resource *unstructured.Unstructured) string {
+	if req.Name != "" || resource == nil {
+		return req.Name
 	}
-	return matched
+	return resource.GetName()
 }
 
-// Status
-func updateConstraintStatus(constraint string, req admission.Request, errMsg string) error {
-	config, err := kubeutil.GetKubeConfig()
-	if err != nil {
-		log.Error(err)
-		return err
-	}
-	clientset, err := mipclient.NewForConfig(config)
-	if err != nil {
-		log.Error(err)
-		return err
-	}
-	mip, err := clientset.ManifestIntegrityProfiles().Get(context.Background(), constraint, metav1.GetOptions{})
-	if err != nil {
-		log.Error("failed to get ManifestIntegrityProfiles:", err.Error())
-		return err
-	}
-	newMIP := mip.UpdateStatus(req, errMsg)
-	_, err = clientset.ManifestIntegrityProfiles().Update(context.Background(), newMIP, metav1.UpdateOptions{})
-	if err != nil {
-		log.Error("failed to update ManifestIntegrityProfileStatus:", err.Error())
-		return err
-	}
-	return nil
+func checkKindMatch(req admission.Request, kinds []miprofile.Kinds) bool {
+	return match.MatchKinds(toMatchKinds(kinds), req.Kind.Group, req.Kind.Kind)
 }
 
-func updateConstraints(isDetectMode bool, req admission.Request, results []shield.ResultFromRequestHandler) {
-	for _, res := range results {
-		if !res.Allow {
-			errMsg := res.Message
-			if isDetectMode {
-				errMsg = "[Detection] " + res.Message
-			}
-			// update status
-			_ = updateConstraintStatus(res.Profile, req, errMsg)
-
-			log.WithFields(log.Fields{
-				"namespace": req.Namespace,
-				"name":      req.Name,
-				"kind":      req.Kind.Kind,
-				"operation": req.Operation,
-			}).Debug("updated constraint status:", res.Profile)
-		}
+// validateProfile returns errors found by the offline lint of a profile.
+func validateProfile(mip *miprofile.ManifestIntegrityProfile) error {
+	result := LintProfile(context.Background(), mip, nil)
+	if len(result.Errors) == 0 {
+		return nil
 	}
+	return errors.New(strings.Join(result.Errors, "; "))
 }
@@ -0,0 +1,343 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// decisionCache keeps results of profiles for identical requests for a short TTL, e.g. for an UPDATE
+// which a controller resubmits after a conflict. Entries are dropped whenever profiles, configs, keys
+// or namespaces change; an evaluation which started before the change is not stored.
+type decisionCache struct {
+	mu         sync.Mutex
+	entries    map[string]decisionCacheEntry
+	generation uint64
+}
+
+type decisionCacheEntry struct {
+	results   []shield.ResultFromRequestHandler
+	expiresAt time.Time
+}
+
+var sharedDecisionCache = &decisionCache{entries: map[string]decisionCacheEntry{}}
+
+// metadata fields which change when the same object is resubmitted and are not verified
+var decisionCacheIgnoredFields = [][]string{
+	{"metadata", "resourceVersion"},
+	{"metadata", "managedFields"},
+}
+
+// getDecisionCache returns the decision cache if it is enabled. Invalidation depends on informers,
+// so the cache is not used until they are synced.
+func getDecisionCache(config *acconfig.AdmissionControllerConfig) *decisionCache {
+	if !config.DecisionCache.Enabled() || getSyncedCache() == nil {
+		return nil
+	}
+	return sharedDecisionCache
+}
+
+// key returns the cache key of a request and the generation of the cache at the time.
+func (dc *decisionCache) key(req admission.Request, config *acconfig.AdmissionControllerConfig, constraints []miprofile.ManifestIntegrityProfile) (string, uint64, error) {
+	dc.mu.Lock()
+	generation := dc.generation
+	dc.mu.Unlock()
+
+	h := sha256.New()
+	write := func(values ...string) {
+		for _, v := range values {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+	}
+	// user
+	write(req.UserInfo.Username, req.UserInfo.UID)
+	write(req.UserInfo.Groups...)
+	// request
+	write(string(req.Operation), req.Kind.String(), req.Resource.String(), req.SubResource, req.Namespace, req.Name)
+	if req.DryRun != nil && *req.DryRun {
+		write("dryRun")
+	}
+	for _, raw := range [][]byte{req.Object.Raw, req.OldObject.Raw} {
+		normalized, err := normalizeObject(raw)
+		if err != nil {
+			return "", generation, err
+		}
+		write(string(normalized))
+	}
+	// profiles; the generation of a profile changes with its spec but not with its status
+	for _, c := range constraints {
+		version := c.ResourceVersion
+		if c.Generation > 0 {
+			version = strconv.FormatInt(c.Generation, 10)
+		}
+		write(c.QualifiedName(), string(c.UID), version)
+	}
+	// config
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return "", generation, err
+	}
+	write(string(configBytes))
+	return hex.EncodeToString(h.Sum(nil)), generation, nil
+}
+
+// normalizeObject removes fields which do not affect the decision. Keys of JSON objects are sorted by Marshal.
+func normalizeObject(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(raw, &obj.Object); err != nil {
+		return nil, err
+	}
+	for _, fields := range decisionCacheIgnoredFields {
+		unstructured.RemoveNestedField(obj.Object, fields...)
+	}
+	// verification annotations are added by the mutating webhook after it evaluated the request,
+	// and they are excluded from verification
+	if annotations := obj.GetAnnotations(); len(annotations) > 0 {
+		for _, key := range k8smnfconfig.VerificationAnnotationKeys {
+			delete(annotations, key)
+		}
+		if len(annotations) == 0 {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+		} else {
+			obj.SetAnnotations(annotations)
+		}
+	}
+	return json.Marshal(obj.Object)
+}
+
+func (dc *decisionCache) get(key string) ([]shield.ResultFromRequestHandler, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	entry, ok := dc.entries[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(dc.entries, key)
+		ok = false
+	}
+	if !ok {
+		decisionCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	decisionCacheLookups.WithLabelValues("hit").Inc()
+	results := make([]shield.ResultFromRequestHandler, len(entry.results))
+	copy(results, entry.results)
+	return results, true
+}
+
+// put stores results unless the cache has been invalidated since the key was computed.
+// Results decided by a failure policy are not stored because the failure may be transient.
+func (dc *decisionCache) put(key string, generation uint64, results []shield.ResultFromRequestHandler, ttl time.Duration, maxEntries int) {
+	for _, r := range results {
+		if r.Reason == shield.ReasonFailOpen || r.Reason == shield.ReasonFailClosed {
+			return
+		}
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if generation != dc.generation {
+		return
+	}
+	if len(dc.entries) >= maxEntries {
+		dc.evict(maxEntries)
+	}
+	stored := make([]shield.ResultFromRequestHandler, len(results))
+	copy(stored, results)
+	dc.entries[key] = decisionCacheEntry{results: stored, expiresAt: time.Now().Add(ttl)}
+}
+
+// evict drops expired entries, and then arbitrary entries until there is room for a new one.
+func (dc *decisionCache) evict(maxEntries int) {
+	now := time.Now()
+	for key, entry := range dc.entries {
+		if now.After(entry.expiresAt) {
+			delete(dc.entries, key)
+		}
+	}
+	for key := range dc.entries {
+		if len(dc.entries) < maxEntries {
+			break
+		}
+		delete(dc.entries, key)
+	}
+}
+
+// invalidateDecisionCache drops all cached decisions.
+func invalidateDecisionCache(reason string) {
+	dc := sharedDecisionCache
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.generation++
+	if len(dc.entries) > 0 {
+		log.Debugf("decision cache is invalidated because %s", reason)
+	}
+	dc.entries = map[string]decisionCacheEntry{}
+}
+
+var secretGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+func podNamespace() string {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	return namespace
+}
+
+// specChangeHandler invalidates the decision cache when a profile is added, deleted or its spec is changed.
+func specChangeHandler(reason string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			invalidateDecisionCache(reason)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err1 := meta.Accessor(oldObj)
+			newMeta, err2 := meta.Accessor(newObj)
+			if err1 != nil || err2 != nil || oldMeta.GetGeneration() != newMeta.GetGeneration() || oldMeta.GetUID() != newMeta.GetUID() {
+				invalidateDecisionCache(reason)
+			}
+		},
+		DeleteFunc: func(interface{}) {
+			invalidateDecisionCache(reason)
+		},
+	}
+}
+
+// configChangeHandler invalidates the decision cache when a configmap is added, deleted or changed.
+// Periodic resyncs and renewals of leader election locks are ignored.
+func configChangeHandler(reason string) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			cm, err := meta.Accessor(obj)
+			if err != nil {
+				return true
+			}
+			_, isLock := cm.GetAnnotations()[resourcelock.LeaderElectionRecordAnnotationKey]
+			return !isLock
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) {
+				invalidateDecisionCache(reason)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if resourceVersionChanged(oldObj, newObj) {
+					invalidateDecisionCache(reason)
+				}
+			},
+			DeleteFunc: func(interface{}) {
+				invalidateDecisionCache(reason)
+			},
+		},
+	}
+}
+
+func resourceVersionChanged(oldObj, newObj interface{}) bool {
+	oldMeta, err1 := meta.Accessor(oldObj)
+	newMeta, err2 := meta.Accessor(newObj)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return oldMeta.GetResourceVersion() != newMeta.GetResourceVersion()
+}
+
+func namespaceLabelsChanged(oldObj, newObj interface{}) bool {
+	oldMeta, err1 := meta.Accessor(oldObj)
+	newMeta, err2 := meta.Accessor(newObj)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return !reflect.DeepEqual(oldMeta.GetLabels(), newMeta.GetLabels())
+}
+
+// invalidateIfKeySecret invalidates the decision cache if a secret is referenced as a key by any profile.
+func invalidateIfKeySecret(rc *resourceCache, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, err := meta.Accessor(obj)
+	if err != nil {
+		invalidateDecisionCache("a secret is changed")
+		return
+	}
+	if keySecretReferenced(rc, secret.GetNamespace(), secret.GetName()) {
+		invalidateDecisionCache(fmt.Sprintf("a key secret `%s/%s` is changed", secret.GetNamespace(), secret.GetName()))
+	}
+}
+
+func keySecretReferenced(rc *resourceCache, namespace, name string) bool {
+	referenced := func(mip *miprofile.ManifestIntegrityProfile) bool {
+		for _, kc := range mip.Spec.Parameters.KeyConfigs {
+			if kc.KeySecretName == name && (kc.KeySecretNamespace == "" || kc.KeySecretNamespace == namespace) {
+				return true
+			}
+		}
+		return false
+	}
+	mips, err := rc.profileLister.List(labels.Everything())
+	if err != nil {
+		return true
+	}
+	for _, mip := range mips {
+		if referenced(mip) {
+			return true
+		}
+	}
+	if rc.namespacedProfileLister == nil {
+		return false
+	}
+	objs, err := rc.namespacedProfileLister.List(labels.Everything())
+	if err != nil {
+		return true
+	}
+	for _, obj := range objs {
+		nmip, err := namespacedProfileFromObject(obj)
+		if err != nil {
+			continue
+		}
+		mip := nmip.AsProfile()
+		if referenced(&mip) {
+			return true
+		}
+	}
+	return false
+}
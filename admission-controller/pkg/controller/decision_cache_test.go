@@ -0,0 +1,333 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	miplisters "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/listers/manifestintegrityprofile/v1alpha1"
+	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const testDecisionCacheObject = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "resourceVersion": "100", "managedFields": [{"manager": "kubectl"}]}, "data": {"key": "val"}}`
+
+func testDecisionCacheRequest(object string) admission.Request {
+	req := admission.Request{}
+	req.UID = "request-uid"
+	req.Operation = admissionv1.Update
+	req.Kind = metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	req.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	req.Namespace = "sample-ns"
+	req.Name = "sample-cm"
+	req.UserInfo = authenticationv1.UserInfo{Username: "sample-user", Groups: []string{"system:authenticated"}}
+	req.Object = runtime.RawExtension{Raw: []byte(object)}
+	req.OldObject = runtime.RawExtension{Raw: []byte(testDecisionCacheObject)}
+	return req
+}
+
+func testDecisionCacheProfile(generation int64, resourceVersion string) miprofile.ManifestIntegrityProfile {
+	return miprofile.ManifestIntegrityProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample-profile", UID: "profile-uid", Generation: generation, ResourceVersion: resourceVersion},
+	}
+}
+
+func newTestDecisionCache() *decisionCache {
+	return &decisionCache{entries: map[string]decisionCacheEntry{}}
+}
+
+func TestDecisionCacheKey(t *testing.T) {
+	dc := newTestDecisionCache()
+	config := &acconfig.AdmissionControllerConfig{}
+	profiles := []miprofile.ManifestIntegrityProfile{testDecisionCacheProfile(1, "10")}
+	baseKey, _, err := dc.key(testDecisionCacheRequest(testDecisionCacheObject), config, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		req      admission.Request
+		config   *acconfig.AdmissionControllerConfig
+		profiles []miprofile.ManifestIntegrityProfile
+		sameKey  bool
+	}{
+		{
+			name: "another request uid",
+			req: func() admission.Request {
+				r := testDecisionCacheRequest(testDecisionCacheObject)
+				r.UID = "retry-uid"
+				return r
+			}(),
+			sameKey: true,
+		},
+		{
+			name:    "resource version and managed fields",
+			req:     testDecisionCacheRequest(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "resourceVersion": "101"}, "data": {"key": "val"}}`),
+			sameKey: true,
+		},
+		{
+			name:    "order of keys",
+			req:     testDecisionCacheRequest(`{"data": {"key": "val"}, "metadata": {"namespace": "sample-ns", "name": "sample-cm", "resourceVersion": "100", "managedFields": [{"manager": "kubectl"}]}, "kind": "ConfigMap", "apiVersion": "v1"}`),
+			sameKey: true,
+		},
+		{
+			name:    "verification annotations",
+			req:     testDecisionCacheRequest(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "annotations": {"integrityshield.io/verified-by": "integrity-shield", "integrityshield.io/verified-at": "2021-10-01T00:00:00Z"}}, "data": {"key": "val"}}`),
+			sameKey: true,
+		},
+		{
+			name:     "status update of a profile",
+			profiles: []miprofile.ManifestIntegrityProfile{testDecisionCacheProfile(1, "11")},
+			sameKey:  true,
+		},
+		{
+			name: "object",
+			req:  testDecisionCacheRequest(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "resourceVersion": "100"}, "data": {"key": "changed"}}`),
+		},
+		{
+			name: "annotation",
+			req:  testDecisionCacheRequest(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "annotations": {"cosign.sigstore.dev/message": "abc"}}, "data": {"key": "val"}}`),
+		},
+		{
+			name: "user",
+			req: func() admission.Request {
+				r := testDecisionCacheRequest(testDecisionCacheObject)
+				r.UserInfo.Username = "another-user"
+				return r
+			}(),
+		},
+		{
+			name: "groups",
+			req: func() admission.Request {
+				r := testDecisionCacheRequest(testDecisionCacheObject)
+				r.UserInfo.Groups = append(r.UserInfo.Groups, "system:masters")
+				return r
+			}(),
+		},
+		{
+			name: "operation",
+			req: func() admission.Request {
+				r := testDecisionCacheRequest(testDecisionCacheObject)
+				r.Operation = admissionv1.Create
+				return r
+			}(),
+		},
+		{
+			name: "dry run",
+			req: func() admission.Request {
+				r := testDecisionCacheRequest(testDecisionCacheObject)
+				dryRun := true
+				r.DryRun = &dryRun
+				return r
+			}(),
+		},
+		{
+			name:     "spec update of a profile",
+			profiles: []miprofile.ManifestIntegrityProfile{testDecisionCacheProfile(2, "11")},
+		},
+		{
+			name:     "another profile",
+			profiles: []miprofile.ManifestIntegrityProfile{testDecisionCacheProfile(1, "10"), testDecisionCacheProfile(1, "10")},
+		},
+		{
+			name:   "config",
+			config: &acconfig.AdmissionControllerConfig{Mode: "detect"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tc.req
+			if req.Object.Raw == nil {
+				req = testDecisionCacheRequest(testDecisionCacheObject)
+			}
+			c := tc.config
+			if c == nil {
+				c = config
+			}
+			p := tc.profiles
+			if p == nil {
+				p = profiles
+			}
+			key, _, err := dc.key(req, c, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key == baseKey) != tc.sameKey {
+				t.Errorf("expected the same key=%v, but got %v", tc.sameKey, key == baseKey)
+			}
+		})
+	}
+
+	if _, _, err := dc.key(testDecisionCacheRequest(`{"metadata": `), config, profiles); err == nil {
+		t.Error("expected an error for an invalid object")
+	}
+}
+
+func TestDecisionCacheTTL(t *testing.T) {
+	dc := newTestDecisionCache()
+	results := []shield.ResultFromRequestHandler{{Allow: true, Reason: "Verified", Profile: "sample-profile"}}
+	ttl := 50 * time.Millisecond
+	dc.put("key", 0, results, ttl, 10)
+
+	cached, ok := dc.get("key")
+	if !ok || len(cached) != 1 || cached[0].Reason != "Verified" {
+		t.Fatalf("expected a cached result, but got %v, %v", cached, ok)
+	}
+	// cached results are copied
+	cached[0].Allow = false
+	if again, _ := dc.get("key"); !again[0].Allow {
+		t.Error("a cached result is modified by the caller")
+	}
+
+	time.Sleep(ttl)
+	if _, ok := dc.get("key"); ok {
+		t.Error("an expired result is returned")
+	}
+	if len(dc.entries) != 0 {
+		t.Errorf("an expired entry is not dropped: %d entries", len(dc.entries))
+	}
+
+	// entries are evicted to keep the max number of entries
+	for _, key := range []string{"a", "b", "c"} {
+		dc.put(key, 0, results, time.Minute, 2)
+	}
+	if len(dc.entries) != 2 {
+		t.Errorf("expected 2 entries, but got %d", len(dc.entries))
+	}
+	if _, ok := dc.get("c"); !ok {
+		t.Error("the latest result is evicted")
+	}
+}
+
+func TestDecisionCacheFailureResults(t *testing.T) {
+	dc := newTestDecisionCache()
+	for _, reason := range []string{shield.ReasonFailOpen, shield.ReasonFailClosed} {
+		results := []shield.ResultFromRequestHandler{
+			{Allow: true, Reason: "Verified", Profile: "profile-a"},
+			{Allow: reason == shield.ReasonFailOpen, Reason: reason, Profile: "profile-b"},
+		}
+		dc.put(reason, 0, results, time.Minute, 10)
+		if _, ok := dc.get(reason); ok {
+			t.Errorf("results with %s are cached", reason)
+		}
+	}
+}
+
+func TestDecisionCacheGeneration(t *testing.T) {
+	dc := sharedDecisionCache
+	invalidateDecisionCache("a test starts")
+	defer invalidateDecisionCache("a test ends")
+
+	config := &acconfig.AdmissionControllerConfig{}
+	profiles := []miprofile.ManifestIntegrityProfile{testDecisionCacheProfile(1, "10")}
+	results := []shield.ResultFromRequestHandler{{Allow: true, Reason: "Verified", Profile: "sample-profile"}}
+	req := testDecisionCacheRequest(testDecisionCacheObject)
+
+	key, generation, err := dc.key(req, config, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.put(key, generation, results, time.Minute, 10)
+	if _, ok := dc.get(key); !ok {
+		t.Fatal("expected a cached result")
+	}
+
+	// invalidation drops cached results
+	invalidateDecisionCache("a profile is changed")
+	if _, ok := dc.get(key); ok {
+		t.Error("a cached result is returned after invalidation")
+	}
+
+	// results of an evaluation which started before invalidation are not stored
+	key, generation, err = dc.key(req, config, profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidateDecisionCache("a key secret is changed")
+	dc.put(key, generation, results, time.Minute, 10)
+	if _, ok := dc.get(key); ok {
+		t.Error("a result evaluated before invalidation is cached")
+	}
+}
+
+func TestInvalidateIfKeySecret(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	profiles := []*miprofile.ManifestIntegrityProfile{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "profile-a"},
+			Spec: miprofile.ManifestIntegrityProfileSpec{Parameters: k8smnfconfig.ParameterObject{
+				KeyConfigs: []k8smnfconfig.KeyConfig{{KeySecretName: "keyring-a", KeySecretNamespace: "integrity-shield-operator-system"}},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "profile-b"},
+			Spec: miprofile.ManifestIntegrityProfileSpec{Parameters: k8smnfconfig.ParameterObject{
+				KeyConfigs: []k8smnfconfig.KeyConfig{{KeySecretName: "keyring-b"}},
+			}},
+		},
+	}
+	for _, p := range profiles {
+		if err := indexer.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc := &resourceCache{profileLister: miplisters.NewManifestIntegrityProfileLister(indexer)}
+
+	secret := func(namespace, name string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	tests := []struct {
+		name        string
+		obj         interface{}
+		invalidated bool
+	}{
+		{name: "key secret", obj: secret("integrity-shield-operator-system", "keyring-a"), invalidated: true},
+		{name: "key secret in another namespace", obj: secret("sample-ns", "keyring-a"), invalidated: false},
+		{name: "key secret without namespace in profile", obj: secret("sample-ns", "keyring-b"), invalidated: true},
+		{name: "other secret", obj: secret("integrity-shield-operator-system", "tls"), invalidated: false},
+		{
+			name:        "deleted key secret",
+			obj:         cache.DeletedFinalStateUnknown{Key: "integrity-shield-operator-system/keyring-a", Obj: secret("integrity-shield-operator-system", "keyring-a")},
+			invalidated: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dc := sharedDecisionCache
+			dc.mu.Lock()
+			before := dc.generation
+			dc.mu.Unlock()
+			invalidateIfKeySecret(rc, tc.obj)
+			dc.mu.Lock()
+			invalidated := dc.generation != before
+			dc.mu.Unlock()
+			if invalidated != tc.invalidated {
+				t.Errorf("expected invalidated=%v, but got %v", tc.invalidated, invalidated)
+			}
+		})
+	}
+}
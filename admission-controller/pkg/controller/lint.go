@@ -0,0 +1,235 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	admv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// characters which can appear in a value matched by a pattern, in addition to the wildcard `*`
+var (
+	namespacePatternRegexp = regexp.MustCompile(`^[a-z0-9\-*]+$`)
+	kindPatternRegexp      = regexp.MustCompile(`^[A-Za-z0-9*]+$`)
+	apiGroupPatternRegexp  = regexp.MustCompile(`^[a-z0-9.\-*]*$`)
+)
+
+// LintResult is the result of LintProfile. A profile with errors cannot be evaluated as intended,
+// and warnings point to settings which are valid but likely to be mistakes.
+type LintResult struct {
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (r *LintResult) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *LintResult) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ProfileReferenceChecker looks up objects in a cluster which are referred to by a profile.
+type ProfileReferenceChecker interface {
+	// ResourceExists reports whether a ConfigMap or a Secret exists.
+	ResourceExists(ctx context.Context, kind, namespace, name string) (bool, error)
+	// KindServed reports whether any kind served by the cluster matches the patterns.
+	KindServed(ctx context.Context, kindPattern, groupPattern string) (bool, error)
+}
+
+// LintProfile validates a profile. Without a checker it runs offline and only checks the profile itself;
+// with a checker it also warns about references to objects which do not exist in the cluster.
+// A profile with a namespace is a namespaced profile and is also checked against tenant restrictions.
+func LintProfile(ctx context.Context, mip *miprofile.ManifestIntegrityProfile, checker ProfileReferenceChecker) LintResult {
+	result := LintResult{}
+	switch mip.Spec.Action {
+	case "", k8smnfconfig.ActionEnforce, k8smnfconfig.ActionDetect, k8smnfconfig.ActionAuditOnly:
+	default:
+		result.errorf("action `%s` is invalid; must be `%s`, `%s` or `%s`", mip.Spec.Action, k8smnfconfig.ActionEnforce, k8smnfconfig.ActionDetect, k8smnfconfig.ActionAuditOnly)
+	}
+	lintMatchCondition(ctx, mip.Spec.Match, checker, &result)
+	lintParameters(ctx, mip.Spec.Parameters, checker, &result)
+	if mip.Namespace != "" {
+		lintTenantRestrictions(mip, &result)
+	}
+	return result
+}
+
+func lintMatchCondition(ctx context.Context, match miprofile.MatchCondition, checker ProfileReferenceChecker, result *LintResult) {
+	if match.LabelSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(match.LabelSelector); err != nil {
+			result.errorf("match.labelSelector is invalid: %s", err.Error())
+		}
+	}
+	if match.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(match.NamespaceSelector); err != nil {
+			result.errorf("match.namespaceSelector is invalid: %s", err.Error())
+		}
+	}
+	if match.AnnotationSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(match.AnnotationSelector); err != nil {
+			result.errorf("match.annotationSelector is invalid: %s", err.Error())
+		}
+	}
+	for _, op := range match.Operations {
+		switch strings.ToUpper(op) {
+		case "*", string(admv1.Create), string(admv1.Update), string(admv1.Delete):
+		default:
+			result.errorf("match.operations: `%s` is invalid; must be `%s`, `%s`, `%s` or `*`", op, admv1.Create, admv1.Update, admv1.Delete)
+		}
+	}
+	for _, ns := range match.Namespaces {
+		if !namespacePatternRegexp.MatchString(ns) {
+			result.errorf("match.namespaces: pattern `%s` never matches a namespace name", ns)
+		}
+	}
+	for _, ns := range match.ExcludedNamespaces {
+		if !namespacePatternRegexp.MatchString(ns) {
+			result.errorf("match.excludedNamespaces: pattern `%s` never matches a namespace name", ns)
+		}
+		if ns == "*" {
+			result.warnf("match.excludedNamespaces: `*` excludes all namespaced resources")
+		}
+	}
+	for i, kinds := range match.Kinds {
+		for _, g := range kinds.ApiGroups {
+			if !apiGroupPatternRegexp.MatchString(g) {
+				result.errorf("match.kinds[%d].apiGroups: pattern `%s` never matches an API group", i, g)
+			}
+		}
+		for _, k := range kinds.Kinds {
+			if !kindPatternRegexp.MatchString(k) {
+				result.errorf("match.kinds[%d].kinds: pattern `%s` never matches a kind", i, k)
+				continue
+			}
+			if checker == nil {
+				continue
+			}
+			groups := kinds.ApiGroups
+			if len(groups) == 0 {
+				groups = []string{"*"}
+			}
+			served := false
+			for _, g := range groups {
+				ok, err := checker.KindServed(ctx, k, g)
+				if err != nil {
+					result.warnf("match.kinds[%d].kinds: failed to check if `%s` is served: %s", i, k, err.Error())
+					served = true
+					break
+				}
+				if ok {
+					served = true
+					break
+				}
+			}
+			if !served {
+				result.warnf("match.kinds[%d].kinds: pattern `%s` matches no kind served by the cluster in groups %v", i, k, groups)
+			}
+		}
+	}
+}
+
+func lintParameters(ctx context.Context, params k8smnfconfig.ParameterObject, checker ProfileReferenceChecker, result *LintResult) {
+	switch params.FailurePolicy {
+	case "", k8smnfconfig.FailurePolicyOpen, k8smnfconfig.FailurePolicyClosed:
+	default:
+		result.errorf("parameters.failurePolicy `%s` is invalid; must be `%s` or `%s`", params.FailurePolicy, k8smnfconfig.FailurePolicyOpen, k8smnfconfig.FailurePolicyClosed)
+	}
+
+	// templates are expanded with a placeholder object to find syntax errors
+	placeholder := unstructured.Unstructured{}
+	placeholder.SetAPIVersion("v1")
+	placeholder.SetKind("ConfigMap")
+	placeholder.SetNamespace("lint")
+	placeholder.SetName("lint")
+	if _, err := params.SignatureRef.Resolve(placeholder); err != nil {
+		result.errorf("parameters.signatureRef is invalid: %s", err.Error())
+	}
+
+	for i, kc := range params.KeyConfigs {
+		if kc.KeySecretName == "" || kc.KeySecretNamespace == "" {
+			result.errorf("parameters.keyConfigs[%d]: both keySecretName and keySecretNamespace are required", i)
+			continue
+		}
+		checkReference(ctx, checker, fmt.Sprintf("parameters.keyConfigs[%d]", i), k8smnfconfig.ResourceRefKindSecret, kc.KeySecretNamespace, kc.KeySecretName, result)
+	}
+
+	type fieldRef struct {
+		field string
+		ref   k8smnfconfig.ResourceRef
+	}
+	refs := []fieldRef{
+		{"parameters.signatureRef.signatureResourceRef", params.SignatureRef.SignatureResourceRef},
+		{"parameters.signatureRef.provenanceResourceRef", params.SignatureRef.ProvenanceResourceRef},
+	}
+	for i, src := range params.SignatureRef.Refs {
+		refs = append(refs,
+			fieldRef{fmt.Sprintf("parameters.signatureRef.refs[%d].signatureResourceRef", i), src.SignatureResourceRef},
+			fieldRef{fmt.Sprintf("parameters.signatureRef.refs[%d].provenanceResourceRef", i), src.ProvenanceResourceRef},
+		)
+	}
+	for _, fr := range refs {
+		ref := fr.ref
+		// references with placeholders depend on the requested resource
+		if ref.Name == "" || ref.Namespace == "" || strings.Contains(ref.Name, "{{") || strings.Contains(ref.Namespace, "{{") {
+			continue
+		}
+		kind := ref.Kind
+		if kind == "" {
+			kind = k8smnfconfig.ResourceRefKindConfigMap
+		}
+		checkReference(ctx, checker, fr.field, kind, ref.Namespace, ref.Name, result)
+	}
+
+	for i, u := range params.SkipUsers {
+		if len(u.Users) == 0 {
+			result.warnf("parameters.skipUsers[%d] has no users, so it never matches", i)
+		}
+		for _, user := range u.Users {
+			if user == "*" {
+				result.warnf("parameters.skipUsers[%d]: `*` skips verification for all users", i)
+			}
+		}
+	}
+	for _, domain := range params.AnnotationDomains {
+		if strings.Contains(domain, "*") {
+			result.warnf("parameters.annotationDomains: wildcard in `%s` is not expanded; list domains explicitly", domain)
+		}
+	}
+}
+
+func checkReference(ctx context.Context, checker ProfileReferenceChecker, field, kind, namespace, name string, result *LintResult) {
+	if checker == nil {
+		return
+	}
+	found, err := checker.ResourceExists(ctx, kind, namespace, name)
+	if err != nil {
+		result.warnf("%s: failed to check %s `%s` in `%s` namespace: %s", field, kind, name, namespace, err.Error())
+		return
+	}
+	if !found {
+		result.warnf("%s: %s `%s` is not found in `%s` namespace", field, kind, name, namespace)
+	}
+}
@@ -0,0 +1,251 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeReferenceChecker finds objects and kinds listed in it
+type fakeReferenceChecker struct {
+	resources []string
+	kinds     []string
+	err       error
+}
+
+func (c fakeReferenceChecker) ResourceExists(ctx context.Context, kind, namespace, name string) (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	}
+	return contains(c.resources, kind+"/"+namespace+"/"+name), nil
+}
+
+func (c fakeReferenceChecker) KindServed(ctx context.Context, kindPattern, groupPattern string) (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	}
+	return contains(c.kinds, kindPattern), nil
+}
+
+func testLintProfile(namespace string, spec miprofile.ManifestIntegrityProfileSpec) *miprofile.ManifestIntegrityProfile {
+	return &miprofile.ManifestIntegrityProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample-profile", Namespace: namespace},
+		Spec:       spec,
+	}
+}
+
+func TestLintProfile(t *testing.T) {
+	invalidSelector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown", Values: []string{"sample"}}},
+	}
+	sampleKeyConfigs := []k8smnfconfig.KeyConfig{{KeySecretName: "keyring-secret", KeySecretNamespace: "sample-ns"}}
+	sampleKinds := []miprofile.Kinds{{Kinds: []string{"ConfigMap"}}}
+
+	tests := []struct {
+		name      string
+		namespace string
+		spec      miprofile.ManifestIntegrityProfileSpec
+		checker   ProfileReferenceChecker
+		errors    []string
+		warnings  []string
+	}{
+		{
+			name: "valid profile",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Action: k8smnfconfig.ActionEnforce,
+				Match: miprofile.MatchCondition{
+					Kinds:      []miprofile.Kinds{{Kinds: []string{"ConfigMap", "Deploy*"}, ApiGroups: []string{"", "apps"}}},
+					Namespaces: []string{"sample-*"},
+					Operations: []string{"CREATE", "update", "*"},
+				},
+				Parameters: k8smnfconfig.ParameterObject{
+					FailurePolicy: k8smnfconfig.FailurePolicyClosed,
+					KeyConfigs:    sampleKeyConfigs,
+					SignatureRef:  k8smnfconfig.SignatureRef{SignatureResourceRef: k8smnfconfig.ResourceRef{Name: "{{.Name}}-sig", Namespace: "{{.Namespace}}"}},
+				},
+			},
+		},
+		{
+			name: "invalid action and failure policy",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Action:     "block",
+				Parameters: k8smnfconfig.ParameterObject{FailurePolicy: "open"},
+			},
+			errors: []string{"action `block` is invalid", "parameters.failurePolicy `open` is invalid"},
+		},
+		{
+			name: "invalid selectors",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{
+					LabelSelector:      invalidSelector,
+					NamespaceSelector:  invalidSelector,
+					AnnotationSelector: invalidSelector,
+				},
+			},
+			errors: []string{"match.labelSelector is invalid", "match.namespaceSelector is invalid", "match.annotationSelector is invalid"},
+		},
+		{
+			name: "invalid operations",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{Operations: []string{"CREATE", "delete", "CONNECT"}},
+			},
+			errors: []string{"match.operations: `CONNECT` is invalid"},
+		},
+		{
+			name: "invalid patterns",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{
+					Namespaces:         []string{"Sample_NS"},
+					ExcludedNamespaces: []string{"kube-system", "ns?"},
+					Kinds:              []miprofile.Kinds{{Kinds: []string{"Config Map"}, ApiGroups: []string{"apps/v1"}}},
+				},
+			},
+			errors: []string{
+				"match.namespaces: pattern `Sample_NS`",
+				"match.excludedNamespaces: pattern `ns?`",
+				"match.kinds[0].apiGroups: pattern `apps/v1`",
+				"match.kinds[0].kinds: pattern `Config Map`",
+			},
+		},
+		{
+			name: "invalid signature ref template",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Parameters: k8smnfconfig.ParameterObject{SignatureRef: k8smnfconfig.SignatureRef{ImageRef: "sample.registry/{{.Namespace"}},
+			},
+			errors: []string{"parameters.signatureRef is invalid"},
+		},
+		{
+			name: "missing keySecretNamespace",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Parameters: k8smnfconfig.ParameterObject{KeyConfigs: []k8smnfconfig.KeyConfig{{KeySecretName: "keyring-secret"}, {KeySecretNamespace: "sample-ns"}}},
+			},
+			errors: []string{"parameters.keyConfigs[0]: both keySecretName and keySecretNamespace are required", "parameters.keyConfigs[1]: both"},
+		},
+		{
+			name: "settings likely to be mistakes",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{ExcludedNamespaces: []string{"*"}},
+				Parameters: k8smnfconfig.ParameterObject{
+					SkipUsers:         k8smnfconfig.ObjectUserBindingList{{}, {Users: []string{"system:admin", "*"}}},
+					AnnotationDomains: []string{"*.sigstore.dev"},
+				},
+			},
+			warnings: []string{
+				"match.excludedNamespaces: `*` excludes all namespaced resources",
+				"parameters.skipUsers[0] has no users",
+				"parameters.skipUsers[1]: `*` skips verification",
+				"parameters.annotationDomains: wildcard in `*.sigstore.dev`",
+			},
+		},
+		{
+			name: "references found by checker",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match:      miprofile.MatchCondition{Kinds: sampleKinds},
+				Parameters: k8smnfconfig.ParameterObject{KeyConfigs: sampleKeyConfigs},
+			},
+			checker: fakeReferenceChecker{resources: []string{"Secret/sample-ns/keyring-secret"}, kinds: []string{"ConfigMap"}},
+		},
+		{
+			name: "references not found by checker",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{Kinds: []miprofile.Kinds{{Kinds: []string{"Sample"}, ApiGroups: []string{"apps", "batch"}}}},
+				Parameters: k8smnfconfig.ParameterObject{
+					KeyConfigs: sampleKeyConfigs,
+					SignatureRef: k8smnfconfig.SignatureRef{
+						SignatureResourceRef: k8smnfconfig.ResourceRef{Name: "sample-sig", Namespace: "sample-ns"},
+						// references with placeholders are not checked
+						ProvenanceResourceRef: k8smnfconfig.ResourceRef{Name: "{{.Name}}-prov", Namespace: "sample-ns"},
+						Refs:                  []k8smnfconfig.SignatureSource{{SignatureResourceRef: k8smnfconfig.ResourceRef{Kind: "Secret", Name: "sample-sig", Namespace: "sample-ns"}}},
+					},
+				},
+			},
+			checker: fakeReferenceChecker{},
+			warnings: []string{
+				"match.kinds[0].kinds: pattern `Sample` matches no kind served by the cluster in groups [apps batch]",
+				"parameters.keyConfigs[0]: Secret `keyring-secret` is not found in `sample-ns` namespace",
+				"parameters.signatureRef.signatureResourceRef: ConfigMap `sample-sig` is not found",
+				"parameters.signatureRef.refs[0].signatureResourceRef: Secret `sample-sig` is not found",
+			},
+		},
+		{
+			name: "checker errors",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match:      miprofile.MatchCondition{Kinds: sampleKinds},
+				Parameters: k8smnfconfig.ParameterObject{KeyConfigs: sampleKeyConfigs},
+			},
+			checker:  fakeReferenceChecker{err: errors.New("forbidden")},
+			warnings: []string{"failed to check if `ConfigMap` is served: forbidden", "failed to check Secret `keyring-secret` in `sample-ns` namespace: forbidden"},
+		},
+		{
+			name:      "namespaced profile",
+			namespace: "sample-ns",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{Namespaces: []string{"sample-ns"}},
+				Parameters: k8smnfconfig.ParameterObject{
+					KeyConfigs:   sampleKeyConfigs,
+					SignatureRef: k8smnfconfig.SignatureRef{SignatureResourceRef: k8smnfconfig.ResourceRef{Name: "{{.Name}}-sig", Namespace: namespacePlaceholder}},
+				},
+			},
+		},
+		{
+			name:      "namespaced profile referring to another namespace",
+			namespace: "sample-ns",
+			spec: miprofile.ManifestIntegrityProfileSpec{
+				Match: miprofile.MatchCondition{Namespaces: []string{"sample-ns", "*"}},
+				Parameters: k8smnfconfig.ParameterObject{
+					KeyConfigs: []k8smnfconfig.KeyConfig{{KeySecretName: "keyring-secret", KeySecretNamespace: "integrity-shield-operator-system"}},
+					SignatureRef: k8smnfconfig.SignatureRef{
+						SignatureResourceRef: k8smnfconfig.ResourceRef{Name: "sample-sig", Namespace: "sample-ns"},
+						Refs:                 []k8smnfconfig.SignatureSource{{ProvenanceResourceRef: k8smnfconfig.ResourceRef{Name: "sample-prov", Namespace: "another-ns"}}},
+					},
+				},
+			},
+			errors: []string{
+				"parameters.keyConfigs[0]: keySecretNamespace must be `sample-ns`",
+				"parameters.signatureRef.refs[0].provenanceResourceRef: namespace must be `sample-ns` or `{{.Namespace}}`",
+			},
+			warnings: []string{"match.namespaces: only `sample-ns` is matched by a namespaced profile; `*` is ignored"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result := LintProfile(context.Background(), testLintProfile(tc.namespace, tc.spec), tc.checker)
+			checkLintMessages(t, "errors", result.Errors, tc.errors)
+			checkLintMessages(t, "warnings", result.Warnings, tc.warnings)
+		})
+	}
+}
+
+// checkLintMessages checks that each message contains the expected substring in order
+func checkLintMessages(t *testing.T, name string, messages, expected []string) {
+	t.Helper()
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %d %s, but got %d: %v", len(expected), name, len(messages), messages)
+	}
+	for i := range expected {
+		if !strings.Contains(messages[i], expected[i]) {
+			t.Errorf("expected %s[%d] to contain %q, but got %q", name, i, expected[i], messages[i])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"strings"
+	"time"
+
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const metricsNamespace = "integrity_shield"
+
+// Decisions of a profile in metrics
+const (
+	metricDecisionAllow  = "allow"
+	metricDecisionDeny   = "deny"
+	metricDecisionDetect = "detect"
+)
+
+// Phases of a profile evaluation in metrics
+const (
+	metricPhaseMatch  = "match"
+	metricPhaseVerify = "verify"
+)
+
+// Namespace buckets keep the cardinality of metrics low regardless of the number of namespaces
+const (
+	namespaceBucketCluster = "cluster-scoped"
+	namespaceBucketSystem  = "system"
+	namespaceBucketUser    = "user"
+)
+
+// prefixes of namespaces managed by the platform rather than by users, in addition to `default`
+var systemNamespacePrefixes = []string{"kube-", "openshift"}
+
+// profile label of namespaced profiles, which are created by tenants without limit
+const metricProfileNamespaced = "namespaced"
+
+var (
+	requestDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_decisions_total",
+		Help:      "Number of admission requests decided by the admission controller, by decision and reason.",
+	}, []string{"decision", "reason"})
+
+	profileDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "profile_decisions_total",
+		Help:      "Number of decisions of profiles which matched admission requests, by profile, kind, namespace bucket, reason and decision.",
+	}, []string{"profile", "kind", "namespace_bucket", "reason", "decision"})
+
+	profileEvaluationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "profile_evaluation_duration_seconds",
+		Help:      "Time spent to evaluate a profile for an admission request, by phase: match or verify.",
+		Buckets:   []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"phase"})
+
+	profilesPerRequest = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "profiles_per_request",
+		Help:      "Number of profiles per admission request, by state: evaluated or matched.",
+		Buckets:   []float64{0, 1, 2, 4, 8, 16, 32, 64, 128},
+	}, []string{"state"})
+
+	statusUpdateFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "profile_status_update_failures_total",
+		Help:      "Number of failures to write recorded results to the status of profiles, by kind of profile.",
+	}, []string{"kind"})
+
+	decisionCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decision_cache_lookups_total",
+		Help:      "Number of lookups of the decision cache, by result: hit or miss.",
+	}, []string{"result"})
+)
+
+func init() {
+	// metrics are served by the metrics endpoint of the manager
+	metrics.Registry.MustRegister(
+		requestDecisions,
+		profileDecisions,
+		profileEvaluationDuration,
+		profilesPerRequest,
+		statusUpdateFailures,
+		decisionCacheLookups,
+	)
+}
+
+func namespaceBucket(namespace string) string {
+	if namespace == "" {
+		return namespaceBucketCluster
+	}
+	if namespace == "default" {
+		return namespaceBucketSystem
+	}
+	for _, prefix := range systemNamespacePrefixes {
+		if strings.HasPrefix(namespace, prefix) {
+			return namespaceBucketSystem
+		}
+	}
+	return namespaceBucketUser
+}
+
+// profileMetricLabel returns the profile label of a result. Namespaced profiles, whose results are named
+// `<namespace>/<name>`, are counted together under one label with the namespace bucket of the request.
+func profileMetricLabel(profile string) string {
+	if strings.Contains(profile, "/") {
+		return metricProfileNamespaced
+	}
+	return profile
+}
+
+func observeEvaluationPhase(phase string, start time.Time) {
+	profileEvaluationDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// recordRequestMetrics records the decision for a request.
+func recordRequestMetrics(ar *AccumulatedResult) {
+	decision := metricDecisionAllow
+	if !ar.Allow {
+		decision = metricDecisionDeny
+	}
+	requestDecisions.WithLabelValues(decision, ar.Reason).Inc()
+}
+
+// recordProfileMetrics records results of matched profiles for a request.
+// A result which is allowed only by detect mode or by the action of the profile is counted as detect.
+func recordProfileMetrics(req admission.Request, results []shield.ResultFromRequestHandler, isDetectMode bool) {
+	bucket := namespaceBucket(req.Namespace)
+	matched := 0
+	for _, res := range results {
+		if res.Reason == ReasonNotMatched {
+			continue
+		}
+		matched++
+		decision := metricDecisionAllow
+		switch {
+		case !res.Allow && isDetectMode, res.Detected:
+			decision = metricDecisionDetect
+		case !res.Allow:
+			decision = metricDecisionDeny
+		}
+		profileDecisions.WithLabelValues(profileMetricLabel(res.Profile), req.Kind.Kind, bucket, res.Reason, decision).Inc()
+	}
+	profilesPerRequest.WithLabelValues("evaluated").Observe(float64(len(results)))
+	profilesPerRequest.WithLabelValues("matched").Observe(float64(matched))
+}
@@ -0,0 +1,46 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"testing"
+)
+
+func TestMetricLabels(t *testing.T) {
+	profiles := map[string]string{
+		"constraint-configmap":           "constraint-configmap",
+		"tenant-ns/constraint-configmap": metricProfileNamespaced,
+		"another-ns/sample-profile":      metricProfileNamespaced,
+	}
+	for profile, want := range profiles {
+		if got := profileMetricLabel(profile); got != want {
+			t.Errorf("expected profile label %q for %q, but got %q", want, profile, got)
+		}
+	}
+	namespaces := map[string]string{
+		"":            namespaceBucketCluster,
+		"default":     namespaceBucketSystem,
+		"kube-system": namespaceBucketSystem,
+		"openshift":   namespaceBucketSystem,
+		"tenant-ns":   namespaceBucketUser,
+	}
+	for namespace, want := range namespaces {
+		if got := namespaceBucket(namespace); got != want {
+			t.Errorf("expected namespace bucket %q for %q, but got %q", want, namespace, got)
+		}
+	}
+}
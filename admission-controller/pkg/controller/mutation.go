@@ -0,0 +1,260 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	admv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// value of the verified-by annotation
+const verifiedByIntegrityShield = "integrity-shield"
+
+// MutateRequest stamps verification results as annotations on an object which is verified by profiles.
+// Mutating webhooks are called before validating ones, so profiles are evaluated without side effects and the
+// results are kept in the decision cache, where the validating webhook finds them for the stamped object;
+// the validating webhook still decides whether it is admitted.
+// Stamps on an object which is not verified are removed, or restored from the old object if it is not
+// changed, so that they cannot be forged or left stale. Objects out of scope or bypassed by break-glass are
+// not evaluated and left as they are.
+// The mutating webhook never denies a request.
+func MutateRequest(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admv1.Create && req.Operation != admv1.Update {
+		return admission.Allowed("")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, shield.DefaultRequestTimeout)
+		defer cancel()
+	}
+	config, err := loadAdmissionControllerConfig(ctx)
+	if err != nil || config == nil {
+		if err == nil {
+			err = errors.New("admission controller config is empty")
+		}
+		log.Errorf("failed to load admission controller config for verification annotations; %s", err.Error())
+		return admission.Allowed("verification annotations are not updated")
+	}
+	if !config.VerificationAnnotation.Enabled || !config.InScopeNamespaceSelector.Match(req.Namespace) || config.Allow.Match(req.Kind) {
+		return admission.Allowed("")
+	}
+	grants, err := LoadBreakGlasses(ctx)
+	if err != nil {
+		log.Errorf("failed to load break-glass grants; %s", err.Error())
+	}
+	if bg := matchBreakGlass(req, grants); bg != nil {
+		return admission.Allowed("")
+	}
+	results, err := evaluateProfilesForStamp(ctx, config, req)
+	if err != nil {
+		log.Errorf("failed to evaluate the request for verification annotations; %s", err.Error())
+		return admission.Allowed("verification annotations are not updated")
+	}
+
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, errors.Wrap(err, "failed to Unmarshal a requested object"))
+	}
+	annotations := obj.GetAnnotations()
+	verified, unchanged := verificationStamp(results)
+	switch {
+	case len(verified) > 0:
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		profiles := []string{}
+		signers := []string{}
+		for _, r := range verified {
+			profiles = append(profiles, r.Profile)
+			if r.Signer != "" && !contains(signers, r.Signer) {
+				signers = append(signers, r.Signer)
+			}
+		}
+		sort.Strings(signers)
+		annotations[k8smnfconfig.VerifiedByAnnotationKey] = verifiedByIntegrityShield
+		annotations[k8smnfconfig.ProfileAnnotationKey] = strings.Join(profiles, ",")
+		annotations[k8smnfconfig.SignerAnnotationKey] = strings.Join(signers, ",")
+		annotations[k8smnfconfig.VerifiedAtAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+	default:
+		// stamps of the last verification are kept only if the object is not changed
+		lastStamps := map[string]string{}
+		if unchanged {
+			var oldObj unstructured.Unstructured
+			if err := json.Unmarshal(req.OldObject.Raw, &oldObj); err == nil {
+				lastStamps = oldObj.GetAnnotations()
+			}
+		}
+		changed := false
+		for _, key := range k8smnfconfig.VerificationAnnotationKeys {
+			value, found := annotations[key]
+			lastValue, lastFound := lastStamps[key]
+			if found == lastFound && value == lastValue {
+				continue
+			}
+			changed = true
+			if lastFound {
+				if annotations == nil {
+					annotations = map[string]string{}
+				}
+				annotations[key] = lastValue
+			} else {
+				delete(annotations, key)
+			}
+		}
+		if !changed {
+			return admission.Allowed("")
+		}
+	}
+	obj.SetAnnotations(annotations)
+	mutated, err := json.Marshal(obj.Object)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, errors.Wrap(err, "failed to marshal the stamped object"))
+	}
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+		"verified":  len(verified) > 0,
+	}).Debug("updated verification annotations")
+	return admission.PatchResponseFromRaw(req.Object.Raw, mutated)
+}
+
+// evaluateProfilesForStamp evaluates profiles without side effects. Verification annotations are excluded
+// from the key of the decision cache, so the results are reused by the validating webhook for the stamped object.
+func evaluateProfilesForStamp(ctx context.Context, config *acconfig.AdmissionControllerConfig, req admission.Request) ([]shield.ResultFromRequestHandler, error) {
+	constraints, err := LoadConstraints(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load ManifestIntegrityProfiles")
+	}
+	tenantConstraints, err := LoadNamespacedConstraints(ctx, req.Namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load NamespacedManifestIntegrityProfiles")
+	}
+	constraints = append(constraints, tenantConstraints...)
+
+	dc := getDecisionCache(config)
+	var cacheKey string
+	var cacheGeneration uint64
+	if dc != nil {
+		cacheKey, cacheGeneration, err = dc.key(req, config, constraints)
+		if err != nil {
+			log.Debugf("decision cache is not used for the request; %s", err.Error())
+			dc = nil
+		} else if results, cached := dc.get(cacheKey); cached {
+			return results, nil
+		}
+	}
+	ec := newEvaluationContext(req)
+	if err := ec.Err(); err != nil {
+		return nil, err
+	}
+	ec.DryRun = true
+	results := evaluateConstraints(ctx, ec, constraints, newRequestHandler(config), config.GetMaxConcurrentProfiles(), config.GetFailurePolicy())
+	if dc != nil {
+		dc.put(cacheKey, cacheGeneration, results, config.DecisionCache.GetTTL(), config.DecisionCache.GetMaxEntries())
+	}
+	return results, nil
+}
+
+// verificationStamp returns results of profiles which verified the request. unchanged is true if
+// the request is not verified only because every matched profile found no change to the object.
+func verificationStamp(results []shield.ResultFromRequestHandler) ([]shield.ResultFromRequestHandler, bool) {
+	verified := []shield.ResultFromRequestHandler{}
+	matched := 0
+	noMutation := 0
+	for _, r := range results {
+		if r.Reason == ReasonNotMatched {
+			continue
+		}
+		matched++
+		switch r.Reason {
+		case shield.ReasonVerified:
+			verified = append(verified, r)
+		case shield.ReasonNoMutation:
+			noMutation++
+		}
+	}
+	return verified, len(verified) == 0 && matched > 0 && noMutation == matched
+}
+
+// checkVerificationStamps returns why verification annotations of the requested object are not
+// accepted, or an empty string. The mutating webhook ignores failures, so the annotations may be
+// submitted by a client as they are. They are accepted only if
+// - a profile verified the request, so the mutating webhook stamped them, or
+// - they are the same as those of the old object, which were accepted when they were set.
+func checkVerificationStamps(req admission.Request, results []shield.ResultFromRequestHandler) string {
+	stamps := verificationStamps(req.Object.Raw)
+	if len(stamps) == 0 {
+		return ""
+	}
+	for _, r := range results {
+		if r.Reason == shield.ReasonVerified {
+			return ""
+		}
+	}
+	if req.Operation == admv1.Update && reflect.DeepEqual(stamps, verificationStamps(req.OldObject.Raw)) {
+		return ""
+	}
+	return fmt.Sprintf("verification annotations %v must not be set or changed unless a profile verifies the object", k8smnfconfig.VerificationAnnotationKeys)
+}
+
+// verificationStamps returns verification annotations of an object.
+func verificationStamps(raw []byte) map[string]string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var obj struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil
+	}
+	stamps := map[string]string{}
+	for _, key := range k8smnfconfig.VerificationAnnotationKeys {
+		if value, found := obj.Metadata.Annotations[key]; found {
+			stamps[key] = value
+		}
+	}
+	return stamps
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	testUnstampedObject = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns"}, "data": {"key": "val"}}`
+	testStampedObject   = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "annotations": {"integrityshield.io/verified-by": "integrity-shield", "integrityshield.io/profile": "sample-profile", "integrityshield.io/verified-at": "2021-10-01T00:00:00Z"}}, "data": {"key": "val"}}`
+	testRestampedObject = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "sample-cm", "namespace": "sample-ns", "annotations": {"integrityshield.io/verified-by": "integrity-shield", "integrityshield.io/profile": "sample-profile", "integrityshield.io/verified-at": "2021-10-02T00:00:00Z"}}, "data": {"key": "val"}}`
+)
+
+func testStampRequest(operation admissionv1.Operation, object, oldObject string) admission.Request {
+	req := admission.Request{}
+	req.Operation = operation
+	if object != "" {
+		req.Object = runtime.RawExtension{Raw: []byte(object)}
+	}
+	if oldObject != "" {
+		req.OldObject = runtime.RawExtension{Raw: []byte(oldObject)}
+	}
+	return req
+}
+
+func testResults(reasons ...string) []shield.ResultFromRequestHandler {
+	results := []shield.ResultFromRequestHandler{}
+	for _, reason := range reasons {
+		results = append(results, shield.ResultFromRequestHandler{Allow: true, Reason: reason, Profile: "profile-" + reason})
+	}
+	return results
+}
+
+func TestCheckVerificationStamps(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      admission.Request
+		results  []shield.ResultFromRequestHandler
+		accepted bool
+	}{
+		{
+			name:     "no stamps",
+			req:      testStampRequest(admissionv1.Create, testUnstampedObject, ""),
+			results:  testResults(ReasonNotMatched),
+			accepted: true,
+		},
+		{
+			name:     "stamps on a verified object",
+			req:      testStampRequest(admissionv1.Create, testStampedObject, ""),
+			results:  testResults(ReasonNotMatched, shield.ReasonVerified),
+			accepted: true,
+		},
+		{
+			name:    "forged stamps on create",
+			req:     testStampRequest(admissionv1.Create, testStampedObject, ""),
+			results: testResults(ReasonNotMatched, shield.ReasonNotProtected),
+		},
+		{
+			name:    "forged stamps on create without matched profiles",
+			req:     testStampRequest(admissionv1.Create, testStampedObject, ""),
+			results: testResults(ReasonNotMatched),
+		},
+		{
+			name:     "unchanged stamps on no mutation update",
+			req:      testStampRequest(admissionv1.Update, testStampedObject, testStampedObject),
+			results:  testResults(ReasonNotMatched, shield.ReasonNoMutation),
+			accepted: true,
+		},
+		{
+			name:    "changed stamps on no mutation update",
+			req:     testStampRequest(admissionv1.Update, testRestampedObject, testStampedObject),
+			results: testResults(shield.ReasonNoMutation),
+		},
+		{
+			name:    "stamps added on update",
+			req:     testStampRequest(admissionv1.Update, testStampedObject, testUnstampedObject),
+			results: testResults(shield.ReasonNoMutation),
+		},
+		{
+			name:     "unchanged stamps on update by skip user",
+			req:      testStampRequest(admissionv1.Update, testStampedObject, testStampedObject),
+			results:  testResults(shield.ReasonSkipUser),
+			accepted: true,
+		},
+		{
+			name:    "stamps added on update by skip user",
+			req:     testStampRequest(admissionv1.Update, testStampedObject, testUnstampedObject),
+			results: testResults(shield.ReasonSkipUser),
+		},
+		{
+			name:     "unchanged stamps on update not matched",
+			req:      testStampRequest(admissionv1.Update, testStampedObject, testStampedObject),
+			results:  testResults(ReasonNotMatched),
+			accepted: true,
+		},
+		{
+			name:     "delete",
+			req:      testStampRequest(admissionv1.Delete, "", testStampedObject),
+			results:  testResults(shield.ReasonVerified),
+			accepted: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := checkVerificationStamps(tc.req, tc.results)
+			if (msg == "") != tc.accepted {
+				t.Errorf("expected accepted=%v, but got %q", tc.accepted, msg)
+			}
+		})
+	}
+}
+
+func TestVerificationStamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []shield.ResultFromRequestHandler
+		verified  []string
+		unchanged bool
+	}{
+		{
+			name:     "verified",
+			results:  testResults(ReasonNotMatched, shield.ReasonVerified, shield.ReasonSkipUser),
+			verified: []string{"profile-" + shield.ReasonVerified},
+		},
+		{
+			name:      "no mutation",
+			results:   testResults(ReasonNotMatched, shield.ReasonNoMutation),
+			unchanged: true,
+		},
+		{
+			name:    "no mutation and skip user",
+			results: testResults(shield.ReasonNoMutation, shield.ReasonSkipUser),
+		},
+		{
+			name:    "not matched",
+			results: testResults(ReasonNotMatched),
+		},
+		{
+			name:    "denied",
+			results: testResults(shield.ReasonSignatureNotFound),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verified, unchanged := verificationStamp(tc.results)
+			profiles := []string{}
+			for _, r := range verified {
+				profiles = append(profiles, r.Profile)
+			}
+			if len(profiles) != len(tc.verified) || (len(profiles) > 0 && profiles[0] != tc.verified[0]) {
+				t.Errorf("expected verified profiles %v, but got %v", tc.verified, profiles)
+			}
+			if unchanged != tc.unchanged {
+				t.Errorf("expected unchanged=%v, but got %v", tc.unchanged, unchanged)
+			}
+		})
+	}
+}
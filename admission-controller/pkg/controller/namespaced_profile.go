@@ -0,0 +1,215 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	miprofilegroup "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile"
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+var namespacedProfileGVR = schema.GroupVersionResource{
+	Group:    miprofilegroup.GroupName,
+	Version:  "v1alpha1",
+	Resource: "namespacedmanifestintegrityprofiles",
+}
+
+// a namespace in a signature reference may be the namespace of the requested resource
+const namespacePlaceholder = "{{.Namespace}}"
+
+// LoadNamespacedConstraints returns profiles of tenants in the namespace, converted to ManifestIntegrityProfiles
+// with the namespace set. Profiles are read from the informer cache if the CRD was served when it started,
+// and from the API server otherwise. If the CRD is not installed, no profile is returned.
+func LoadNamespacedConstraints(ctx context.Context, namespace string) ([]miprofile.ManifestIntegrityProfile, error) {
+	// cluster scoped resources are never verified by namespaced profiles
+	if namespace == "" {
+		return nil, nil
+	}
+	var objs []runtime.Object
+	if rc := getSyncedCache(); rc != nil && rc.namespacedProfileLister != nil {
+		cached, err := rc.namespacedProfileLister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		objs = cached
+	} else {
+		config, err := kubeutil.GetKubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		list, err := client.Resource(namespacedProfileGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for i := range list.Items {
+			objs = append(objs, &list.Items[i])
+		}
+	}
+	profiles := []miprofile.ManifestIntegrityProfile{}
+	for _, obj := range objs {
+		nmip, err := namespacedProfileFromObject(obj)
+		if err != nil {
+			log.Warning(err.Error())
+			continue
+		}
+		profiles = append(profiles, tenantProfile(nmip))
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// namespacedProfileFromObject converts an object from the dynamic client or informer.
+func namespacedProfileFromObject(obj interface{}) (*miprofile.NamespacedManifestIntegrityProfile, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unexpected object %T for NamespacedManifestIntegrityProfile", obj))
+	}
+	var nmip miprofile.NamespacedManifestIntegrityProfile
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &nmip); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to convert NamespacedManifestIntegrityProfile `%s/%s`", u.GetNamespace(), u.GetName()))
+	}
+	return &nmip, nil
+}
+
+// tenantProfile returns a namespaced profile as a cluster profile for evaluation. Its constraint name is
+// always the qualified name, so that it cannot be taken for a cluster profile in constraint-config, events and audit.
+// Namespace patterns are dropped because a namespaced profile only matches requests in its namespace.
+func tenantProfile(nmip *miprofile.NamespacedManifestIntegrityProfile) miprofile.ManifestIntegrityProfile {
+	mip := nmip.AsProfile()
+	mip.Spec.Parameters.ConstraintName = mip.QualifiedName()
+	mip.Spec.Match.Namespaces = nil
+	return mip
+}
+
+// lintTenantRestrictions checks that a namespaced profile only refers to objects in its namespace.
+// A namespaced profile which violates them is rejected by the profile webhook and denies requests it matches.
+func lintTenantRestrictions(mip *miprofile.ManifestIntegrityProfile, result *LintResult) {
+	ns := mip.Namespace
+	params := mip.Spec.Parameters
+	for i, kc := range params.KeyConfigs {
+		if kc.KeySecretNamespace != "" && kc.KeySecretNamespace != ns {
+			result.errorf("parameters.keyConfigs[%d]: keySecretNamespace must be `%s`, the namespace of the profile", i, ns)
+		}
+	}
+	type fieldRef struct {
+		field string
+		ref   k8smnfconfig.ResourceRef
+	}
+	refs := []fieldRef{
+		{"parameters.signatureRef.signatureResourceRef", params.SignatureRef.SignatureResourceRef},
+		{"parameters.signatureRef.provenanceResourceRef", params.SignatureRef.ProvenanceResourceRef},
+	}
+	for i, src := range params.SignatureRef.Refs {
+		refs = append(refs,
+			fieldRef{fmt.Sprintf("parameters.signatureRef.refs[%d].signatureResourceRef", i), src.SignatureResourceRef},
+			fieldRef{fmt.Sprintf("parameters.signatureRef.refs[%d].provenanceResourceRef", i), src.ProvenanceResourceRef},
+		)
+	}
+	for _, fr := range refs {
+		switch strings.TrimSpace(fr.ref.Namespace) {
+		case "", ns, namespacePlaceholder:
+		default:
+			result.errorf("%s: namespace must be `%s` or `%s`", fr.field, ns, namespacePlaceholder)
+		}
+	}
+	for _, pattern := range mip.Spec.Match.Namespaces {
+		if pattern != ns {
+			result.warnf("match.namespaces: only `%s` is matched by a namespaced profile; `%s` is ignored", ns, pattern)
+		}
+	}
+}
+
+// tenantRestrictionErrors returns violations of tenant restrictions; it is empty for cluster profiles.
+func tenantRestrictionErrors(mip *miprofile.ManifestIntegrityProfile) []string {
+	if mip.Namespace == "" {
+		return nil
+	}
+	result := LintResult{}
+	lintTenantRestrictions(mip, &result)
+	return result.Errors
+}
+
+// namespacedProfilesServed reports whether the API server serves namespaced profiles.
+func namespacedProfilesServed(client discovery.DiscoveryInterface) (bool, error) {
+	return resourceServed(client, namespacedProfileGVR)
+}
+
+// resourceServed reports whether the API server serves the resource, e.g. of an optional CRD.
+func resourceServed(client discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// writeNamespacedProfileStatus applies a delta to the latest status of a namespaced profile.
+func writeNamespacedProfileStatus(ctx context.Context, client dynamic.Interface, namespace, name string, delta *profileStatusDelta) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := client.Resource(namespacedProfileGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		nmip, err := namespacedProfileFromObject(u)
+		if err != nil {
+			return err
+		}
+		mip := nmip.AsProfile()
+		applyStatusDelta(&mip, delta)
+		nmip.Status = mip.Status
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(nmip)
+		if err != nil {
+			return err
+		}
+		_, err = client.Resource(namespacedProfileGVR).Namespace(namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,138 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
+	admv1 "k8s.io/api/admission/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const namespacedProfileKind = "NamespacedManifestIntegrityProfile"
+
+// ProfileValidator is a validating webhook which rejects ManifestIntegrityProfiles and NamespacedManifestIntegrityProfiles
+// with lint errors and returns lint warnings, e.g. references to Secrets or ConfigMaps which do not exist.
+type ProfileValidator struct {
+	Checker ProfileReferenceChecker
+}
+
+// NewProfileValidator returns a ProfileValidator which checks references in the cluster.
+func NewProfileValidator(config *rest.Config) (*ProfileValidator, error) {
+	client, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes client")
+	}
+	return &ProfileValidator{Checker: &clusterReferenceChecker{client: client}}, nil
+}
+
+// Handle implements admission.Handler.
+func (v *ProfileValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admv1.Create && req.Operation != admv1.Update {
+		return admission.Allowed("")
+	}
+	var mip miprofile.ManifestIntegrityProfile
+	if req.Kind.Kind == namespacedProfileKind {
+		// a namespaced profile is checked as a profile with a namespace, including tenant restrictions
+		var nmip miprofile.NamespacedManifestIntegrityProfile
+		if err := json.Unmarshal(req.Object.Raw, &nmip); err != nil {
+			return admission.Errored(http.StatusBadRequest, errors.Wrap(err, fmt.Sprintf("failed to Unmarshal a requested object into %T", nmip)))
+		}
+		if nmip.Namespace == "" {
+			nmip.Namespace = req.Namespace
+		}
+		mip = nmip.AsProfile()
+	} else if err := json.Unmarshal(req.Object.Raw, &mip); err != nil {
+		return admission.Errored(http.StatusBadRequest, errors.Wrap(err, fmt.Sprintf("failed to Unmarshal a requested object into %T", mip)))
+	}
+	result := LintProfile(ctx, &mip, v.Checker)
+	log.WithFields(log.Fields{
+		"kind":     req.Kind.Kind,
+		"name":     mip.QualifiedName(),
+		"errors":   len(result.Errors),
+		"warnings": len(result.Warnings),
+	}).Debug("validated profile")
+	var resp admission.Response
+	if len(result.Errors) > 0 {
+		resp = admission.Denied(fmt.Sprintf("invalid %s: %s", req.Kind.Kind, strings.Join(result.Errors, "; ")))
+	} else {
+		resp = admission.Allowed("")
+	}
+	resp.Warnings = result.Warnings
+	return resp
+}
+
+// clusterReferenceChecker looks up referred objects with the API server.
+type clusterReferenceChecker struct {
+	client kubeclient.Interface
+}
+
+func (c *clusterReferenceChecker) ResourceExists(ctx context.Context, kind, namespace, name string) (bool, error) {
+	var err error
+	switch kind {
+	case k8smnfconfig.ResourceRefKindSecret:
+		_, err = c.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case k8smnfconfig.ResourceRefKindConfigMap:
+		_, err = c.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	default:
+		return false, errors.New(fmt.Sprintf("unsupported kind `%s`", kind))
+	}
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *clusterReferenceChecker) KindServed(ctx context.Context, kindPattern, groupPattern string) (bool, error) {
+	// discovery may return partial results with an error when some API services are unavailable
+	resourceLists, err := c.client.Discovery().ServerPreferredResources()
+	if err != nil && len(resourceLists) == 0 {
+		return false, err
+	}
+	for _, list := range resourceLists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		if !k8smnfutil.MatchPattern(groupPattern, gv.Group) {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if k8smnfutil.MatchPattern(kindPattern, r.Kind) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,87 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	shieldclient "github.com/IBM/integrity-shield/integrity-shield-server/pkg/client"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	log "github.com/sirupsen/logrus"
+)
+
+// requestHandlerFunc verifies a request matched by a profile with its parameters.
+type requestHandlerFunc func(ctx context.Context, ec *shield.EvaluationContext, paramObj *k8smnfconfig.ParameterObject) *shield.ResultFromRequestHandler
+
+var (
+	remoteClient     *shieldclient.Client
+	remoteClientLock sync.Mutex
+)
+
+// newRequestHandler returns the request handler of the config. The request handler runs in the admission
+// controller by default; in remote mode, it is called on integrity shield server.
+func newRequestHandler(config *acconfig.AdmissionControllerConfig) requestHandlerFunc {
+	if !config.RequestHandler.IsRemote() {
+		return shield.EvaluateRequest
+	}
+	client, err := getRemoteClient(config.RequestHandler.Remote)
+	if err != nil {
+		log.Errorf("failed to create a client of integrity shield server; %s", err.Error())
+		return func(ctx context.Context, ec *shield.EvaluationContext, paramObj *k8smnfconfig.ParameterObject) *shield.ResultFromRequestHandler {
+			return remoteFailureResult(ctx, paramObj, err)
+		}
+	}
+	return func(ctx context.Context, ec *shield.EvaluationContext, paramObj *k8smnfconfig.ParameterObject) *shield.ResultFromRequestHandler {
+		r, err := client.Evaluate(ctx, ec.Request, paramObj, ec.DryRun)
+		if err != nil {
+			log.Errorf("failed to evaluate a request on integrity shield server; %s", err.Error())
+			return remoteFailureResult(ctx, paramObj, err)
+		}
+		return r
+	}
+}
+
+// getRemoteClient returns the client shared by requests so that connections are reused.
+// The client is recreated only when the config is changed.
+func getRemoteClient(config shieldclient.Config) (*shieldclient.Client, error) {
+	remoteClientLock.Lock()
+	defer remoteClientLock.Unlock()
+	if remoteClient != nil && remoteClient.Config() == config {
+		return remoteClient, nil
+	}
+	client, err := shieldclient.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	remoteClient = client
+	return remoteClient, nil
+}
+
+// remoteFailureResult decides the result of a profile by its failure policy when integrity shield server cannot decide it.
+func remoteFailureResult(ctx context.Context, paramObj *k8smnfconfig.ParameterObject, err error) *shield.ResultFromRequestHandler {
+	allow, reason, msg := shield.ApplyFailurePolicy(ctx, paramObj.GetFailurePolicy(), "Failed to call integrity shield server", err)
+	r := &shield.ResultFromRequestHandler{
+		Allow:   allow,
+		Reason:  reason,
+		Message: msg,
+	}
+	shield.ApplyAction(r, paramObj.GetAction(true))
+	return r
+}
@@ -0,0 +1,349 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	miprofilegroup "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile"
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	admv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// objects in a simulation request are limited in the same way as the API server limits a request body
+const maxSimulationRequestBytes = 3 * 1024 * 1024
+
+// SimulationRequest is an object to be evaluated by the simulation API.
+// If the operation is omitted, it is UPDATE when the old object is given and CREATE otherwise.
+// For DELETE, the object is the object to be deleted.
+type SimulationRequest struct {
+	Object    runtime.RawExtension      `json:"object"`
+	OldObject runtime.RawExtension      `json:"oldObject,omitempty"`
+	Operation admv1.Operation           `json:"operation,omitempty"`
+	UserInfo  authenticationv1.UserInfo `json:"userInfo,omitempty"`
+}
+
+// SimulationResult is the decision which would be made for a simulation request, with the breakdown per profile.
+type SimulationResult struct {
+	Allow            bool                      `json:"allow"`
+	Reason           string                    `json:"reason"`
+	Message          string                    `json:"message"`
+	InScopeNamespace bool                      `json:"inScopeNamespace"`
+	AllowedKind      bool                      `json:"allowedKind"`
+	BreakGlass       string                    `json:"breakGlass,omitempty"`
+	Warnings         []string                  `json:"warnings,omitempty"`
+	Profiles         []ProfileSimulationResult `json:"profiles"`
+}
+
+// ProfileSimulationResult tells whether a profile matches the object and what it would decide.
+// Result is empty if the profile does not match.
+type ProfileSimulationResult struct {
+	Profile     string                           `json:"profile"`
+	Matched     bool                             `json:"matched"`
+	MatchReason string                           `json:"matchReason,omitempty"`
+	Result      *shield.ResultFromRequestHandler `json:"result,omitempty"`
+}
+
+// simulationVerb is the verb on ManifestIntegrityProfiles which a caller of the simulation API must be allowed by RBAC
+const simulationVerb = "simulate"
+
+// SimulationHandler serves the simulation API: it decodes a SimulationRequest from the body and returns a SimulationResult.
+// The caller must present a bearer token, which is authenticated with TokenReview, and must be allowed to `simulate`
+// ManifestIntegrityProfiles, which is checked with SubjectAccessReview.
+type SimulationHandler struct {
+	client kubeclient.Interface
+}
+
+// NewSimulationHandler returns a SimulationHandler which reviews callers with the API server.
+func NewSimulationHandler(config *rest.Config) (*SimulationHandler, error) {
+	client, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes client")
+	}
+	return &SimulationHandler{client: client}, nil
+}
+
+func (h *SimulationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), shield.DefaultRequestTimeout)
+	defer cancel()
+	caller, err := h.authenticate(ctx, r)
+	if err != nil {
+		log.Warningf("unauthenticated simulation request; %s", err.Error())
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	allowed, err := h.authorize(ctx, caller, &authorizationv1.ResourceAttributes{
+		Group:    miprofilegroup.GroupName,
+		Resource: "manifestintegrityprofiles",
+		Verb:     simulationVerb,
+	})
+	if err != nil {
+		log.Errorf("failed to authorize a simulation request; %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("user `%s` is not allowed to `%s` manifestintegrityprofiles", caller.Username, simulationVerb), http.StatusForbidden)
+		return
+	}
+	var sr SimulationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSimulationRequestBytes)).Decode(&sr); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode simulation request; %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	// the request is simulated as the caller, unless the caller may impersonate the given user as kubectl --as does
+	if sr.UserInfo.Username == "" {
+		sr.UserInfo = caller
+	} else {
+		allowed, err := h.canImpersonate(ctx, caller, sr.UserInfo)
+		if err != nil {
+			log.Errorf("failed to authorize a simulation request; %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, fmt.Sprintf("user `%s` is not allowed to impersonate `%s` or its groups", caller.Username, sr.UserInfo.Username), http.StatusForbidden)
+			return
+		}
+	}
+	req, err := newSimulationAdmissionRequest(sr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := Simulate(ctx, req)
+	if err != nil {
+		log.Errorf("failed to simulate the request; %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("failed to write simulation result; %s", err.Error())
+	}
+}
+
+// authenticate returns the user of the bearer token in the request.
+func (h *SimulationHandler) authenticate(ctx context.Context, r *http.Request) (authenticationv1.UserInfo, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" || token == r.Header.Get("Authorization") {
+		return authenticationv1.UserInfo{}, errors.New("no bearer token is given")
+	}
+	tr, err := h.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, errors.Wrap(err, "failed to review the token")
+	}
+	if !tr.Status.Authenticated {
+		return authenticationv1.UserInfo{}, errors.New(fmt.Sprintf("the token is not authenticated; %s", tr.Status.Error))
+	}
+	return tr.Status.User, nil
+}
+
+// authorize checks if the user is allowed the action with SubjectAccessReview.
+func (h *SimulationHandler) authorize(ctx context.Context, user authenticationv1.UserInfo, attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar, err := h.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to review the access")
+	}
+	return sar.Status.Allowed, nil
+}
+
+// canImpersonate checks if the caller may impersonate the user and all its groups.
+func (h *SimulationHandler) canImpersonate(ctx context.Context, caller, user authenticationv1.UserInfo) (bool, error) {
+	if user.Username == caller.Username && len(user.Groups) == 0 {
+		return true, nil
+	}
+	targets := []*authorizationv1.ResourceAttributes{}
+	if user.Username != caller.Username {
+		targets = append(targets, &authorizationv1.ResourceAttributes{Resource: "users", Verb: "impersonate", Name: user.Username})
+	}
+	for _, g := range user.Groups {
+		targets = append(targets, &authorizationv1.ResourceAttributes{Resource: "groups", Verb: "impersonate", Name: g})
+	}
+	for _, attrs := range targets {
+		allowed, err := h.authorize(ctx, caller, attrs)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// newSimulationAdmissionRequest builds an admission request from a simulation request.
+func newSimulationAdmissionRequest(sr SimulationRequest) (admission.Request, error) {
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(sr.Object.Raw, &obj); err != nil {
+		return admission.Request{}, errors.Wrap(err, "failed to decode `object` in simulation request")
+	}
+	if obj.GetKind() == "" {
+		return admission.Request{}, errors.New("`object` in simulation request must have apiVersion and kind")
+	}
+	operation := sr.Operation
+	if operation == "" {
+		operation = admv1.Create
+		if len(sr.OldObject.Raw) > 0 {
+			operation = admv1.Update
+		}
+	}
+	gvk := obj.GroupVersionKind()
+	object, oldObject := sr.Object, sr.OldObject
+	// the API server sends the object being deleted as the old object of a delete request
+	if operation == admv1.Delete {
+		object, oldObject = runtime.RawExtension{}, sr.Object
+	}
+	return admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Operation: operation,
+		UserInfo:  sr.UserInfo,
+		Object:    object,
+		OldObject: oldObject,
+	}}, nil
+}
+
+// Simulate evaluates a request with all profiles as ProcessRequest does, but without any side effects:
+// no event, audit record or profile status is written. Unlike ProcessRequest, profiles are evaluated
+// even if the request is out of scope or bypassed, so that the breakdown shows what each profile would decide.
+func Simulate(ctx context.Context, req admission.Request) (*SimulationResult, error) {
+	config, err := loadAdmissionControllerConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load admission controller config")
+	}
+	if config == nil {
+		config = &acconfig.AdmissionControllerConfig{}
+	}
+	sr := &SimulationResult{
+		InScopeNamespace: config.InScopeNamespaceSelector.Match(req.Namespace),
+		AllowedKind:      config.Allow.Match(req.Kind),
+	}
+	grants, err := LoadBreakGlasses(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load break-glass grants")
+	}
+	if bg := matchBreakGlass(req, grants); bg != nil {
+		sr.BreakGlass = bg.Name
+	}
+	constraints, err := LoadConstraints(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load ManifestIntegrityProfiles")
+	}
+	tenantConstraints, err := LoadNamespacedConstraints(ctx, req.Namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load NamespacedManifestIntegrityProfiles")
+	}
+	constraints = append(constraints, tenantConstraints...)
+	ec := newEvaluationContext(req)
+	if err := ec.Err(); err != nil {
+		return nil, err
+	}
+	ec.DryRun = true
+
+	sr.Profiles = make([]ProfileSimulationResult, len(constraints))
+	handler := newRequestHandler(config)
+	runWorkers(len(constraints), config.GetMaxConcurrentProfiles(), func(i int) {
+		sr.Profiles[i] = simulateConstraint(ctx, ec, constraints[i], handler, config.GetFailurePolicy())
+	})
+
+	// decisions are accumulated in the same way as ProcessRequest
+	results := []shield.ResultFromRequestHandler{}
+	for _, p := range sr.Profiles {
+		if p.Result != nil {
+			results = append(results, *p.Result)
+		}
+	}
+	isDetectMode := acconfig.CheckIfDetectOnly(config.Mode)
+	switch {
+	case !sr.InScopeNamespace:
+		sr.Allow, sr.Reason, sr.Message = true, ReasonOutOfScopeNamespace, "this namespace is out of scope"
+	case sr.AllowedKind:
+		sr.Allow, sr.Reason, sr.Message = true, ReasonAllowedKind, "this kind is out of scope"
+	case sr.BreakGlass != "":
+		sr.Allow, sr.Reason, sr.Message = true, ReasonBreakGlass, "verification is bypassed by break-glass `"+sr.BreakGlass+"`"
+	default:
+		ar := getAccumulatedResult(results)
+		sr.Allow, sr.Reason, sr.Message = ar.Allow, ar.Reason, ar.Message
+		sr.Warnings = getWarnings(results, isDetectMode)
+		if !sr.Allow && isDetectMode {
+			sr.Allow = true
+			sr.Reason = ReasonDetectMode
+			sr.Message = "allowed by detection mode: " + sr.Message
+		}
+	}
+	return sr, nil
+}
+
+func simulateConstraint(ctx context.Context, ec *shield.EvaluationContext, constraint miprofile.ManifestIntegrityProfile, handler requestHandlerFunc, defaultFailurePolicy string) ProfileSimulationResult {
+	name := constraint.QualifiedName()
+	psr := ProfileSimulationResult{Profile: name}
+	paramObj := GetParametersFromConstraint(constraint.Spec, defaultFailurePolicy)
+	isMatched, matchReason, err := matchProfile(ctx, ec, &constraint)
+	if err != nil {
+		allow, reason, msg := shield.ApplyFailurePolicy(ctx, paramObj.GetFailurePolicy(), "Failed to check match condition", err)
+		psr.MatchReason = fmt.Sprintf("failed to check match condition; %s", err.Error())
+		psr.Result = &shield.ResultFromRequestHandler{Allow: allow, Reason: reason, Message: msg, Profile: name}
+		shield.ApplyAction(psr.Result, paramObj.GetAction(true))
+		return psr
+	}
+	if !isMatched {
+		psr.MatchReason = "not matched because " + matchReason
+		return psr
+	}
+	psr.Matched = true
+	if r := invalidTenantProfileResult(&constraint, paramObj); r != nil {
+		psr.Result = r
+		return psr
+	}
+	r := handler(ctx, ec, paramObj)
+	r.Profile = name
+	psr.Result = r
+	return psr
+}
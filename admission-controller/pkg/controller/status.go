@@ -0,0 +1,325 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
+	mipclient "github.com/IBM/integrity-shield/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/typed/manifestintegrityprofile/v1alpha1"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DefaultStatusFlushInterval is the interval in which recorded results are written to the status of profiles.
+const DefaultStatusFlushInterval = 5 * time.Second
+
+// timeout of the last flush on shutdown
+const finalStatusFlushTimeout = 5 * time.Second
+
+// Reasons of profile conditions
+const (
+	conditionReasonLoaded         = "Loaded"
+	conditionReasonValid          = "Valid"
+	conditionReasonInvalidProfile = "InvalidProfile"
+)
+
+// profileStatusDelta is a change to the status of a profile which is not written yet.
+type profileStatusDelta struct {
+	allow         int
+	deny          int
+	errors        int
+	detect        int
+	violations    []*miprofile.ViolationDetail // newest first
+	lastEvaluated time.Time
+	historySize   int
+	action        string // action applied to the last request
+}
+
+// mergeStatusDelta combines two deltas of a profile; newer is recorded after older.
+func mergeStatusDelta(older, newer *profileStatusDelta) *profileStatusDelta {
+	if older == nil {
+		return newer
+	}
+	if newer == nil {
+		return older
+	}
+	merged := &profileStatusDelta{
+		allow:         older.allow + newer.allow,
+		deny:          older.deny + newer.deny,
+		errors:        older.errors + newer.errors,
+		detect:        older.detect + newer.detect,
+		lastEvaluated: older.lastEvaluated,
+		historySize:   older.historySize,
+		action:        older.action,
+	}
+	if newer.lastEvaluated.After(merged.lastEvaluated) {
+		merged.lastEvaluated = newer.lastEvaluated
+	}
+	if newer.action != "" {
+		merged.action = newer.action
+	}
+	if newer.historySize > 0 {
+		merged.historySize = newer.historySize
+	}
+	merged.violations = append(merged.violations, newer.violations...)
+	merged.violations = append(merged.violations, older.violations...)
+	// violations beyond the history size would be dropped when written
+	if merged.historySize > 0 && len(merged.violations) > merged.historySize {
+		merged.violations = merged.violations[:merged.historySize]
+	}
+	return merged
+}
+
+// statusRecorder accumulates results of profiles in memory so that concurrent requests never
+// overwrite counts of each other, and writes them to the status subresource in batches.
+type statusRecorder struct {
+	mu      sync.Mutex
+	pending map[string]*profileStatusDelta
+}
+
+var profileStatusRecorder = &statusRecorder{pending: map[string]*profileStatusDelta{}}
+
+func (r *statusRecorder) record(profile string, delta *profileStatusDelta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[profile] = mergeStatusDelta(r.pending[profile], delta)
+}
+
+// requeue puts back a delta which failed to be written, before deltas recorded since then.
+func (r *statusRecorder) requeue(profile string, delta *profileStatusDelta) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[profile] = mergeStatusDelta(delta, r.pending[profile])
+}
+
+func (r *statusRecorder) take() map[string]*profileStatusDelta {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending
+	r.pending = map[string]*profileStatusDelta{}
+	return pending
+}
+
+// flush writes all pending deltas. A delta which fails to be written is kept for the next flush
+// unless the profile has been deleted. Profiles are keyed by their qualified names, so a key
+// `<namespace>/<name>` is a namespaced profile.
+func (r *statusRecorder) flush(ctx context.Context, client mipclient.ManifestIntegrityProfileInterface, dynamicClient dynamic.Interface) {
+	for key, delta := range r.take() {
+		var err error
+		if namespace, name, ok := splitQualifiedName(key); ok {
+			err = writeNamespacedProfileStatus(ctx, dynamicClient, namespace, name, delta)
+		} else {
+			err = writeProfileStatus(ctx, client, key, delta)
+		}
+		if err == nil {
+			continue
+		}
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		log.Errorf("failed to update status of profile `%s`; %s", key, err.Error())
+		statusUpdateFailures.WithLabelValues(profileKindOfKey(key)).Inc()
+		r.requeue(key, delta)
+	}
+}
+
+func profileKindOfKey(key string) string {
+	if _, _, ok := splitQualifiedName(key); ok {
+		return namespacedProfileKind
+	}
+	return "ManifestIntegrityProfile"
+}
+
+func splitQualifiedName(key string) (string, string, bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// writeProfileStatus applies a delta to the latest status of a profile, retrying on conflicts
+// with other replicas of the admission controller.
+func writeProfileStatus(ctx context.Context, client mipclient.ManifestIntegrityProfileInterface, name string, delta *profileStatusDelta) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mip, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		applyStatusDelta(mip, delta)
+		_, err = client.UpdateStatus(ctx, mip, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// applyStatusDelta adds a delta to the status of a profile and refreshes its conditions.
+func applyStatusDelta(mip *miprofile.ManifestIntegrityProfile, delta *profileStatusDelta) {
+	mip.Status.AllowCount += delta.allow
+	mip.Status.DenyCount += delta.deny
+	mip.Status.ErrorCount += delta.errors
+	mip.Status.DetectCount += delta.detect
+	if len(delta.violations) > 0 {
+		mip.AddViolations(delta.violations, delta.historySize)
+	}
+	if !delta.lastEvaluated.IsZero() {
+		t := metav1.NewTime(delta.lastEvaluated)
+		mip.Status.LastEvaluatedTime = &t
+	}
+	if delta.action != "" {
+		mip.Status.Action = delta.action
+	}
+	setProfileConditions(mip)
+}
+
+// setProfileConditions sets Ready and Invalid conditions for the current generation of the profile.
+func setProfileConditions(mip *miprofile.ManifestIntegrityProfile) {
+	err := validateProfile(mip)
+	if err != nil {
+		apimeta.SetStatusCondition(&mip.Status.Conditions, metav1.Condition{
+			Type:               miprofile.ConditionInvalid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: mip.Generation,
+			Reason:             conditionReasonInvalidProfile,
+			Message:            err.Error(),
+		})
+		apimeta.SetStatusCondition(&mip.Status.Conditions, metav1.Condition{
+			Type:               miprofile.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: mip.Generation,
+			Reason:             conditionReasonInvalidProfile,
+			Message:            err.Error(),
+		})
+		return
+	}
+	apimeta.SetStatusCondition(&mip.Status.Conditions, metav1.Condition{
+		Type:               miprofile.ConditionInvalid,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: mip.Generation,
+		Reason:             conditionReasonValid,
+	})
+	apimeta.SetStatusCondition(&mip.Status.Conditions, metav1.Condition{
+		Type:               miprofile.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: mip.Generation,
+		Reason:             conditionReasonLoaded,
+		Message:            "the profile is used for admission requests",
+	})
+}
+
+// needsConditionUpdate reports whether conditions have not been set for the current generation.
+func needsConditionUpdate(mip *miprofile.ManifestIntegrityProfile) bool {
+	c := apimeta.FindStatusCondition(mip.Status.Conditions, miprofile.ConditionReady)
+	return c == nil || c.ObservedGeneration != mip.Generation
+}
+
+// recordProfileStatus records results of matched profiles for a request.
+// Each result increments one of allow, deny and detect counts, and errors are counted in addition.
+// The action applied to the request is recorded too; detect mode of the controller overrides enforce.
+func recordProfileStatus(historySize int, isDetectMode bool, req admission.Request, results []shield.ResultFromRequestHandler) {
+	now := time.Now()
+	for _, res := range results {
+		if res.Reason == ReasonNotMatched {
+			continue
+		}
+		delta := &profileStatusDelta{lastEvaluated: now, historySize: historySize, action: res.Action}
+		if res.Reason == shield.ReasonFailOpen || res.Reason == shield.ReasonFailClosed {
+			delta.errors = 1
+		}
+		switch {
+		case !res.Allow && isDetectMode:
+			delta.detect = 1
+			delta.action = k8smnfconfig.ActionDetect
+			delta.violations = []*miprofile.ViolationDetail{miprofile.NewViolationDetail(req, "[Detection] "+res.Message)}
+		case !res.Allow:
+			delta.deny = 1
+			delta.violations = []*miprofile.ViolationDetail{miprofile.NewViolationDetail(req, res.Message)}
+		case res.Detected && res.Action == k8smnfconfig.ActionAuditOnly:
+			// allowed because the profile is audit-only
+			delta.detect = 1
+			delta.violations = []*miprofile.ViolationDetail{miprofile.NewViolationDetail(req, "[Audit] "+res.Message)}
+		case res.Detected:
+			// allowed only because the profile is not enforced
+			delta.detect = 1
+			delta.violations = []*miprofile.ViolationDetail{miprofile.NewViolationDetail(req, "[Detection] "+res.Message)}
+		default:
+			delta.allow = 1
+		}
+		profileStatusRecorder.record(res.Profile, delta)
+
+		log.WithFields(log.Fields{
+			"namespace": req.Namespace,
+			"name":      req.Name,
+			"kind":      req.Kind.Kind,
+			"operation": req.Operation,
+		}).Debug("recorded constraint status:", res.Profile)
+	}
+}
+
+// StatusRecorderRunnable writes recorded results to the status of profiles periodically.
+// It runs on every replica because each replica records results of requests it serves.
+type StatusRecorderRunnable struct {
+	Config   *rest.Config
+	Interval time.Duration
+}
+
+// Start implements manager.Runnable.
+func (r *StatusRecorderRunnable) Start(ctx context.Context) error {
+	clientset, err := mipclient.NewForConfig(r.Config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a client for ManifestIntegrityProfile")
+	}
+	client := clientset.ManifestIntegrityProfiles()
+	dynamicClient, err := dynamic.NewForConfig(r.Config)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a dynamic client")
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultStatusFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			profileStatusRecorder.flush(ctx, client, dynamicClient)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalStatusFlushTimeout)
+			profileStatusRecorder.flush(flushCtx, client, dynamicClient)
+			cancel()
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *StatusRecorderRunnable) NeedLeaderElection() bool {
+	return false
+}
@@ -21,9 +21,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	bgv1alpha1 "github.com/IBM/integrity-shield/admission-controller/pkg/apis/breakglass/v1alpha1"
+	miprofile "github.com/IBM/integrity-shield/admission-controller/pkg/apis/manifestintegrityprofile/v1alpha1"
 	acconfig "github.com/IBM/integrity-shield/admission-controller/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/audit"
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
 	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
@@ -49,9 +54,29 @@ var logLevelMap = map[string]log.Level{
 	"trace": log.TraceLevel,
 }
 
+// Reasons of the admission controller decision
+const (
+	ReasonAllowed             = "Allowed"
+	ReasonOutOfScopeNamespace = "OutOfScopeNamespace"
+	ReasonAllowedKind         = "AllowedKind"
+	ReasonDetectMode          = "DetectMode"
+	ReasonBreakGlass          = "BreakGlass"
+	// the profile does not match the request
+	ReasonNotMatched = "NotMatched"
+	// a namespaced profile refers to objects outside its namespace
+	ReasonInvalidProfile = "InvalidProfile"
+	// verification annotations are not stamped by the admission controller
+	ReasonInvalidVerificationStamp = "InvalidVerificationStamp"
+)
+
 type AccumulatedResult struct {
-	Allow   bool
-	Message string
+	Allow      bool
+	Reason     string
+	Message    string
+	Warnings   []string
+	BreakGlass string
+	// whether results of profiles were reused from the decision cache
+	Cached bool
 }
 
 func init() {
@@ -70,72 +95,127 @@ func init() {
 	log.SetLevel(logLevel)
 }
 
-func ProcessRequest(req admission.Request) admission.Response {
+func ProcessRequest(ctx context.Context, req admission.Request) admission.Response {
+	start := time.Now()
+	// the API server does not tell the webhook its deadline, so apply the default one if the caller gives none
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, shield.DefaultRequestTimeout)
+		defer cancel()
+	}
 	// load ac2 config
-	config, err := loadAdmissionControllerConfig()
-	if err != nil {
+	config, err := loadAdmissionControllerConfig(ctx)
+	if err != nil || config == nil {
+		if err == nil {
+			err = errors.New("admission controller config is empty")
+		}
 		log.Errorf("failed to load admission controller config; %s", err.Error())
-		return admission.Allowed("error but allow for development")
+		// failure policy of the default config is used because the config is not available
+		config = &acconfig.AdmissionControllerConfig{}
+		return failureResponse(ctx, config, req, "Failed to load admission controller config", err, start)
 	}
 
 	// isScope check
 	inScopeNamespace := config.InScopeNamespaceSelector.Match(req.Namespace)
 	if !inScopeNamespace {
-		return admission.Allowed("this namespace is out of scope")
+		ar := &AccumulatedResult{Allow: true, Reason: ReasonOutOfScopeNamespace, Message: "this namespace is out of scope"}
+		recordRequestMetrics(ar)
+		auditDecision(config, req, ar, nil, start)
+		return admission.Allowed(ar.Message)
 	}
 	// allow check
 	allowedRequest := config.Allow.Match(req.Kind)
 	if allowedRequest {
-		return admission.Allowed("this kind is out of scope")
+		ar := &AccumulatedResult{Allow: true, Reason: ReasonAllowedKind, Message: "this kind is out of scope"}
+		recordRequestMetrics(ar)
+		auditDecision(config, req, ar, nil, start)
+		return admission.Allowed(ar.Message)
+	}
+
+	// break-glass check
+	grants, err := LoadBreakGlasses(ctx)
+	if err != nil {
+		log.Errorf("failed to load break-glass grants; %s", err.Error())
+	}
+	if bg := matchBreakGlass(req, grants); bg != nil {
+		return breakGlassResponse(config, req, bg, start)
 	}
 
 	// load constraints
-	constraints, err := LoadConstraints()
+	constraints, err := LoadConstraints(ctx)
 	if err != nil {
 		log.Errorf("failed to load constratints; %s", err.Error())
-		return admission.Allowed("error but allow for development")
+		return failureResponse(ctx, config, req, "Failed to load ManifestIntegrityProfiles", err, start)
 	}
+	// profiles of tenants in the namespace are evaluated together with cluster profiles
+	tenantConstraints, err := LoadNamespacedConstraints(ctx, req.Namespace)
+	if err != nil {
+		log.Errorf("failed to load namespaced profiles; %s", err.Error())
+		return failureResponse(ctx, config, req, "Failed to load NamespacedManifestIntegrityProfiles", err, start)
+	}
+	constraints = append(constraints, tenantConstraints...)
 
-	results := []shield.ResultFromRequestHandler{}
-
-	for _, constraint := range constraints {
-
-		//match check: kind, namespace, label
-		isMatched := matchCheck(req, constraint.Spec.Match)
-		if !isMatched {
-			r := shield.ResultFromRequestHandler{
-				Allow:   true,
-				Message: "not protected",
-				Profile: constraint.Name,
-			}
-			results = append(results, r)
-			continue
+	// an identical request, e.g. an update resubmitted after a conflict, reuses the results of profiles
+	var results []shield.ResultFromRequestHandler
+	cached := false
+	dc := getDecisionCache(config)
+	var cacheKey string
+	var cacheGeneration uint64
+	if dc != nil {
+		cacheKey, cacheGeneration, err = dc.key(req, config, constraints)
+		if err != nil {
+			log.Debugf("decision cache is not used for the request; %s", err.Error())
+			dc = nil
+		} else {
+			results, cached = dc.get(cacheKey)
 		}
+	}
 
-		// pick parameters from constaint
-		paramObj := GetParametersFromConstraint(constraint.Spec)
-
-		// call request handler & receive result from request handler (allow, message)
-		r := shield.RequestHandler(req, paramObj)
+	if !cached {
+		// decode the request once; the evaluation context is shared by all profiles and must not be modified
+		ec := newEvaluationContext(req)
+		if err := ec.Err(); err != nil {
+			log.Errorf("failed to decode the request; %s", err.Error())
+			return failureResponse(ctx, config, req, "Failed to Unmarshal a requested object", err, start)
+		}
 
-		r.Profile = constraint.Name
-		results = append(results, *r)
+		results = evaluateConstraints(ctx, ec, constraints, newRequestHandler(config), config.GetMaxConcurrentProfiles(), config.GetFailurePolicy())
+		if dc != nil {
+			dc.put(cacheKey, cacheGeneration, results, config.DecisionCache.GetTTL(), config.DecisionCache.GetMaxEntries())
+		}
+	} else {
+		recordCachedResults(ctx, req, constraints, results, config.GetFailurePolicy())
 	}
 
 	// accumulate results from constraints
 	ar := getAccumulatedResult(results)
+	ar.Cached = cached
+	// verification annotations are checked only where the mutating webhook manages them
+	if ar.Allow && config.VerificationAnnotation.Enabled {
+		if msg := checkVerificationStamps(req, results); msg != "" {
+			ar.Allow = false
+			ar.Reason = ReasonInvalidVerificationStamp
+			ar.Message = msg
+		}
+	}
 
 	// mode check
 	isDetectMode := acconfig.CheckIfDetectOnly(config.Mode)
+	ar.Warnings = getWarnings(results, isDetectMode)
 	if !ar.Allow && isDetectMode {
 		ar.Allow = true
+		ar.Reason = ReasonDetectMode
 		msg := "allowed by detection mode: " + ar.Message
 		ar.Message = msg
 	}
 
+	// metrics
+	recordRequestMetrics(ar)
+	recordProfileMetrics(req, results, isDetectMode)
+
 	// update status
 	if config.SideEffect.UpdateMIPStatusForDeniedRequest {
-		updateConstraints(isDetectMode, req, results)
+		recordProfileStatus(config.GetMIPStatusHistorySize(), isDetectMode, req, results)
 	}
 
 	// log
@@ -145,21 +225,212 @@ func ProcessRequest(req admission.Request) admission.Response {
 		"kind":      req.Kind.Kind,
 		"operation": req.Operation,
 		"allow":     ar.Allow,
+		"cached":    ar.Cached,
 	}).Info(ar.Message)
 
+	// audit
+	auditDecision(config, req, ar, results, start)
+
 	// return admission response
+	var resp admission.Response
 	if ar.Allow {
-		return admission.Allowed(ar.Message)
+		resp = admission.Allowed(ar.Message)
 	} else {
-		return admission.Denied(ar.Message)
+		resp = admission.Denied(ar.Message)
+	}
+	resp.Warnings = ar.Warnings
+	return resp
+}
+
+// getWarnings returns a warning for each profile which would deny the request if it were enforced.
+func getWarnings(results []shield.ResultFromRequestHandler, isDetectMode bool) []string {
+	warnings := []string{}
+	for _, result := range results {
+		for _, w := range result.Warnings {
+			warnings = append(warnings, "["+result.Profile+"] "+w)
+		}
+		if !result.Allow && isDetectMode {
+			warnings = append(warnings, "["+result.Profile+"] "+shield.DenyWarning(result.Reason)+" (detect mode)")
+		}
+	}
+	if len(warnings) == 0 {
+		return nil
 	}
+	return warnings
 }
 
-func loadAdmissionControllerConfig() (*acconfig.AdmissionControllerConfig, error) {
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
+// breakGlassResponse allows a request bypassed by a break-glass grant.
+// The bypass is always logged and recorded as an event, and tagged in audit records.
+func breakGlassResponse(config *acconfig.AdmissionControllerConfig, req admission.Request, bg *bgv1alpha1.BreakGlass, start time.Time) admission.Response {
+	msg := breakGlassMessage(bg)
+	ar := &AccumulatedResult{
+		Allow:      true,
+		Reason:     ReasonBreakGlass,
+		Message:    msg,
+		Warnings:   []string{"[" + bg.Name + "] verification is bypassed by break-glass"},
+		BreakGlass: bg.Name,
 	}
+	log.WithFields(log.Fields{
+		"namespace":  req.Namespace,
+		"name":       req.Name,
+		"kind":       req.Kind.Kind,
+		"operation":  req.Operation,
+		"userName":   req.UserInfo.Username,
+		"breakGlass": bg.Name,
+		"allow":      ar.Allow,
+	}).Warning(ar.Message)
+	_ = shield.RecordBreakGlassEvent(req, bg.Name, msg)
+	recordRequestMetrics(ar)
+	auditDecision(config, req, ar, nil, start)
+	resp := admission.Allowed(ar.Message)
+	resp.Warnings = ar.Warnings
+	return resp
+}
+
+// evaluateConstraints evaluates profiles with a bounded number of workers.
+// Each result is stored at the index of its profile, so the order of results does not depend on scheduling.
+func evaluateConstraints(ctx context.Context, ec *shield.EvaluationContext, constraints []miprofile.ManifestIntegrityProfile, handler requestHandlerFunc, workers int, defaultFailurePolicy string) []shield.ResultFromRequestHandler {
+	results := make([]shield.ResultFromRequestHandler, len(constraints))
+	runWorkers(len(constraints), workers, func(i int) {
+		results[i] = evaluateConstraint(ctx, ec, constraints[i], handler, defaultFailurePolicy)
+	})
+	return results
+}
+
+// runWorkers calls fn for each index in [0, n) with at most the given number of goroutines.
+func runWorkers(n, workers int, fn func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	indexCh := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexCh {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexCh <- i
+	}
+	close(indexCh)
+	wg.Wait()
+}
+
+func evaluateConstraint(ctx context.Context, ec *shield.EvaluationContext, constraint miprofile.ManifestIntegrityProfile, handler requestHandlerFunc, defaultFailurePolicy string) shield.ResultFromRequestHandler {
+	name := constraint.QualifiedName()
+	// pick parameters from constaint
+	paramObj := GetParametersFromConstraint(constraint.Spec, defaultFailurePolicy)
+
+	//match check: kind, namespace, label
+	matchStart := time.Now()
+	isMatched, matchReason, err := matchProfile(ctx, ec, &constraint)
+	observeEvaluationPhase(metricPhaseMatch, matchStart)
+	if err != nil {
+		log.Errorf("failed to check if the request matches with `%s`; %s", name, err.Error())
+		allow, reason, msg := shield.ApplyFailurePolicy(ctx, paramObj.GetFailurePolicy(), "Failed to check match condition", err)
+		r := shield.ResultFromRequestHandler{
+			Allow:   allow,
+			Reason:  reason,
+			Message: msg,
+			Profile: name,
+		}
+		shield.ApplyAction(&r, paramObj.GetAction(true))
+		return r
+	}
+	if !isMatched {
+		log.WithFields(log.Fields{
+			"namespace": ec.Request.Namespace,
+			"name":      ec.Request.Name,
+			"kind":      ec.Request.Kind.Kind,
+			"operation": ec.Request.Operation,
+		}).Debugf("not matched with `%s` because %s", name, matchReason)
+		return shield.ResultFromRequestHandler{
+			Allow:   true,
+			Reason:  ReasonNotMatched,
+			Message: "not protected",
+			Profile: name,
+		}
+	}
+	if r := invalidTenantProfileResult(&constraint, paramObj); r != nil {
+		return *r
+	}
+
+	// call request handler & receive result from request handler (allow, message)
+	verifyStart := time.Now()
+	r := handler(ctx, ec, paramObj)
+	observeEvaluationPhase(metricPhaseVerify, verifyStart)
+
+	r.Profile = name
+	return *r
+}
+
+// recordCachedResults runs side effects of the request handler, a deny event and an audit record, for results
+// reused from the decision cache. Results of profiles which were not evaluated by the request handler, such as
+// not matched ones, have no side effects. Results are in the order of profiles because the cache key includes them.
+func recordCachedResults(ctx context.Context, req admission.Request, constraints []miprofile.ManifestIntegrityProfile, results []shield.ResultFromRequestHandler, defaultFailurePolicy string) {
+	for i := range results {
+		if i >= len(constraints) {
+			break
+		}
+		r := results[i]
+		if r.Reason == ReasonNotMatched || r.Reason == ReasonInvalidProfile {
+			continue
+		}
+		paramObj := GetParametersFromConstraint(constraints[i].Spec, defaultFailurePolicy)
+		shield.RecordCachedResult(ctx, req, &r, paramObj)
+	}
+}
+
+// invalidTenantProfileResult denies a request matched by a namespaced profile which refers to objects
+// outside its namespace, because evaluating it could use keys or signatures of other tenants.
+// The request is only denied if the profile is enforced.
+func invalidTenantProfileResult(constraint *miprofile.ManifestIntegrityProfile, paramObj *k8smnfconfig.ParameterObject) *shield.ResultFromRequestHandler {
+	errs := tenantRestrictionErrors(constraint)
+	if len(errs) == 0 {
+		return nil
+	}
+	r := &shield.ResultFromRequestHandler{
+		Allow:   false,
+		Reason:  ReasonInvalidProfile,
+		Message: "the namespaced profile is invalid: " + strings.Join(errs, "; "),
+		Profile: constraint.QualifiedName(),
+	}
+	shield.ApplyAction(r, paramObj.GetAction(true))
+	return r
+}
+
+// failureResponse decides the response by the failure policy when profiles cannot be evaluated.
+func failureResponse(ctx context.Context, config *acconfig.AdmissionControllerConfig, req admission.Request, msg string, err error, start time.Time) admission.Response {
+	allow, reason, errMsg := shield.ApplyFailurePolicy(ctx, config.GetFailurePolicy(), msg, err)
+	ar := &AccumulatedResult{Allow: allow, Reason: reason, Message: errMsg}
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+		"allow":     ar.Allow,
+		"cached":    ar.Cached,
+	}).Info(ar.Message)
+	recordRequestMetrics(ar)
+	auditDecision(config, req, ar, nil, start)
+	if ar.Allow {
+		return admission.Allowed(ar.Message)
+	}
+	return admission.Denied(ar.Message)
+}
+
+func loadAdmissionControllerConfig(ctx context.Context) (*acconfig.AdmissionControllerConfig, error) {
+	namespace := podNamespace()
 	configName := os.Getenv("CONTROLLER_CONFIG_NAME")
 	if configName == "" {
 		configName = defaultControllerConfigName
@@ -171,14 +442,14 @@ func loadAdmissionControllerConfig() (*acconfig.AdmissionControllerConfig, error
 	// load
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	clientset, err := kubeclient.NewForConfig(config)
 	if err != nil {
 		log.Error(err)
-		return nil, nil
+		return nil, err
 	}
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, configName, metav1.GetOptions{})
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
 	}
@@ -195,6 +466,8 @@ func loadAdmissionControllerConfig() (*acconfig.AdmissionControllerConfig, error
 	return sc, nil
 }
 
+// getAccumulatedResult denies the request if any profile denies it. Profiles in detect or audit-only
+// action have already allowed requests which they would deny, so only enforced profiles can deny.
 func getAccumulatedResult(results []shield.ResultFromRequestHandler) *AccumulatedResult {
 	denyMessages := []string{}
 	allowMessages := []string{}
@@ -203,6 +476,9 @@ func getAccumulatedResult(results []shield.ResultFromRequestHandler) *Accumulate
 		if !result.Allow {
 			msg := "[" + result.Profile + "]" + result.Message
 			denyMessages = append(denyMessages, msg)
+			if accumulatedRes.Reason == "" {
+				accumulatedRes.Reason = result.Reason
+			}
 		} else {
 			msg := "[" + result.Profile + "]" + result.Message
 			allowMessages = append(allowMessages, msg)
@@ -214,6 +490,35 @@ func getAccumulatedResult(results []shield.ResultFromRequestHandler) *Accumulate
 		return accumulatedRes
 	}
 	accumulatedRes.Allow = true
+	accumulatedRes.Reason = ReasonAllowed
 	accumulatedRes.Message = strings.Join(allowMessages, ";")
 	return accumulatedRes
 }
+
+func auditDecision(config *acconfig.AdmissionControllerConfig, req admission.Request, ar *AccumulatedResult, results []shield.ResultFromRequestHandler, start time.Time) {
+	if !config.Audit.Enabled {
+		return
+	}
+	record := audit.NewRecord(audit.SourceAdmissionController, req, start)
+	record.Allow = ar.Allow
+	record.Reason = ar.Reason
+	record.Message = ar.Message
+	record.BreakGlass = ar.BreakGlass
+	record.Cached = ar.Cached
+	for _, r := range results {
+		record.Profiles = append(record.Profiles, audit.ProfileDecision{
+			Name:             r.Profile,
+			Allow:            r.Allow,
+			Reason:           r.Reason,
+			Message:          r.Message,
+			Signer:           r.Signer,
+			AnnotationDomain: r.AnnotationDomain,
+			Action:           r.Action,
+			Detected:         r.Detected,
+		})
+		if record.Signer == "" {
+			record.Signer = r.Signer
+		}
+	}
+	audit.Emit(audit.SourceAdmissionController, config.Audit, record)
+}
@@ -126,7 +126,17 @@ func (r *IntegrityShieldReconciler) deleteCRD(instance *apiv1alpha1.IntegrityShi
 
 func (r *IntegrityShieldReconciler) createOrUpdateManifestIntegrityProfileCRD(
 	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	ctx := context.Background()
 	expected := res.BuildManifestIntegrityProfileCRD(instance)
+	// the conversion webhook is served with the same certificate as the validating webhook
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: instance.Spec.WebhookServerTlsSecretName, Namespace: instance.Namespace}, secret)
+	if err != nil {
+		r.Log.Error(err, "Fail to load CABundle from Secret")
+	}
+	if cabundle, ok := secret.Data["ca.crt"]; ok && expected.Spec.Conversion != nil && expected.Spec.Conversion.Webhook != nil {
+		expected.Spec.Conversion.Webhook.ClientConfig.CABundle = cabundle
+	}
 	return r.createOrUpdateCRD(instance, expected)
 }
 
@@ -136,6 +146,30 @@ func (r *IntegrityShieldReconciler) deleteManifestIntegrityProfileCRD(
 	return r.deleteCRD(instance, expected)
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateNamespacedManifestIntegrityProfileCRD(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildNamespacedManifestIntegrityProfileCRD(instance)
+	return r.createOrUpdateCRD(instance, expected)
+}
+
+func (r *IntegrityShieldReconciler) deleteNamespacedManifestIntegrityProfileCRD(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildNamespacedManifestIntegrityProfileCRD(instance)
+	return r.deleteCRD(instance, expected)
+}
+
+func (r *IntegrityShieldReconciler) createOrUpdateBreakGlassCRD(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildBreakGlassCRD(instance)
+	return r.createOrUpdateCRD(instance, expected)
+}
+
+func (r *IntegrityShieldReconciler) deleteBreakGlassCRD(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildBreakGlassCRD(instance)
+	return r.deleteCRD(instance, expected)
+}
+
 func (r *IntegrityShieldReconciler) createOrUpdateVerifyResourceResultCRD(
 	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
 	expected := res.BuildVerifyResourceResultCRD(instance)
@@ -562,6 +596,18 @@ func (r *IntegrityShieldReconciler) deleteObserverClusterRoleForIShield(
 	return r.deleteClusterRole(instance, expected)
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateNamespacedProfileEditorClusterRole(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildClusterRoleForNamespacedProfileEditor(instance)
+	return r.createOrUpdateClusterRole(instance, expected)
+}
+
+func (r *IntegrityShieldReconciler) deleteNamespacedProfileEditorClusterRole(
+	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildClusterRoleForNamespacedProfileEditor(instance)
+	return r.deleteClusterRole(instance, expected)
+}
+
 // role binding
 func (r *IntegrityShieldReconciler) createOrUpdateRoleBindingForIShield(
 	instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
@@ -873,7 +919,12 @@ func (r *IntegrityShieldReconciler) createOrUpdateAPIService(instance *apiv1alph
 
 func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1alpha1.IntegrityShield) (ctrl.Result, error) {
 	ctx := context.Background()
-	expected := res.BuildValidatingWebhookConfigurationForIShield(instance)
+	// rules are generated from match conditions of profiles
+	expected, err := r.buildWebhookConfiguration(instance)
+	if err != nil {
+		r.Log.Error(err, "Failed to list profiles for webhook rules", "Instance.Name", instance.Name)
+		return ctrl.Result{}, err
+	}
 	found := &admregv1.ValidatingWebhookConfiguration{}
 
 	reqLogger := r.Log.WithValues(
@@ -881,7 +932,7 @@ func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1alpha1.
 		"ValidatingWebhookConfiguration.Name", expected.Name)
 
 	// Set CR instance as the owner and controller
-	err := controllerutil.SetControllerReference(instance, expected, r.Scheme)
+	err = controllerutil.SetControllerReference(instance, expected, r.Scheme)
 	if err != nil {
 		reqLogger.Error(err, "Failed to define expected resource")
 		return ctrl.Result{}, err
@@ -900,7 +951,9 @@ func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1alpha1.
 		}
 		cabundle, ok := secret.Data["ca.crt"]
 		if ok {
-			expected.Webhooks[0].ClientConfig.CABundle = cabundle
+			for i := range expected.Webhooks {
+				expected.Webhooks[i].ClientConfig.CABundle = cabundle
+			}
 		}
 
 		err = r.Create(ctx, expected)
@@ -923,7 +976,19 @@ func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1alpha1.
 		return ctrl.Result{}, err
 	}
 
-	// No extra validation
+	// Update rules and selectors if profiles are changed
+	if res.WebhookRulesChanged(found, expected) {
+		found.Webhooks[0].Rules = expected.Webhooks[0].Rules
+		found.Webhooks[0].NamespaceSelector = expected.Webhooks[0].NamespaceSelector
+		found.Webhooks[0].ObjectSelector = expected.Webhooks[0].ObjectSelector
+		err = r.Update(ctx, found)
+		if err != nil {
+			reqLogger.Error(err, "Failed to update the webhook rules")
+			return ctrl.Result{}, err
+		}
+		reqLogger.Info("Webhook rules have been updated.", "Rules", len(expected.Webhooks[0].Rules))
+		return ctrl.Result{}, nil
+	}
 
 	// No reconcile was necessary
 	return ctrl.Result{}, nil
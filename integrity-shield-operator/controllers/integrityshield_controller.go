@@ -18,13 +18,20 @@ package controllers
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	apisv1alpha1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1alpha1"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -35,6 +42,12 @@ type IntegrityShieldReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// DiscoveryClient finds resources for webhook rules generated from profiles
+	DiscoveryClient discovery.DiscoveryInterface
+
+	controller          controller.Controller
+	profileWatchLock    sync.Mutex
+	watchedProfileKinds map[string]bool
 }
 
 var log = logf.Log.WithName("controller_integrityshield")
@@ -43,8 +56,9 @@ var log = logf.Log.WithName("controller_integrityshield")
 //+kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=services;serviceaccounts;events;configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields;integrityshields/finalizers;manifestintegrityprofiles,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields;integrityshields/finalizers;manifestintegrityprofiles;namespacedmanifestintegrityprofiles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=*
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings;roles;rolebindings,verbs=*
 // +kubebuilder:rbac:groups=policy,resources=podsecuritypolicies,verbs=get;list;watch;create;update;patch;delete
@@ -207,6 +221,23 @@ func (r *IntegrityShieldReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		if recErr != nil || recResult.Requeue {
 			return recResult, recErr
 		}
+		recResult, recErr = r.createOrUpdateNamespacedManifestIntegrityProfileCRD(instance)
+		if recErr != nil || recResult.Requeue {
+			return recResult, recErr
+		}
+		// webhook rules are updated as profiles change
+		if err := r.watchProfiles(); err != nil {
+			reqLogger.Error(err, "Failed to watch profiles")
+			return ctrl.Result{}, err
+		}
+		recResult, recErr = r.createOrUpdateNamespacedProfileEditorClusterRole(instance)
+		if recErr != nil || recResult.Requeue {
+			return recResult, recErr
+		}
+		recResult, recErr = r.createOrUpdateBreakGlassCRD(instance)
+		if recErr != nil || recResult.Requeue {
+			return recResult, recErr
+		}
 		// ac config
 		recResult, recErr = r.createOrUpdateACConfig(instance)
 		if recErr != nil || recResult.Requeue {
@@ -254,9 +285,15 @@ func (r *IntegrityShieldReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IntegrityShieldReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&apisv1alpha1.IntegrityShield{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &extv1.CustomResourceDefinition{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForWebhookRules), builder.WithPredicates(crdServingChanged())).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	r.controller = c
+	return nil
 }
 
 func (r *IntegrityShieldReconciler) deleteClusterScopedChildrenResources(instance *apisv1alpha1.IntegrityShield) error {
@@ -290,6 +327,18 @@ func (r *IntegrityShieldReconciler) deleteClusterScopedChildrenResources(instanc
 		if err != nil {
 			return err
 		}
+		_, err = r.deleteNamespacedManifestIntegrityProfileCRD(instance)
+		if err != nil {
+			return err
+		}
+		_, err = r.deleteNamespacedProfileEditorClusterRole(instance)
+		if err != nil {
+			return err
+		}
+		_, err = r.deleteBreakGlassCRD(instance)
+		if err != nil {
+			return err
+		}
 	}
 	_, err = r.deletePodSecurityPolicy(instance)
 	if err != nil {
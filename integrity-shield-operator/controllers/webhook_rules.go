@@ -0,0 +1,188 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	apiv1alpha1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1alpha1"
+	res "github.com/IBM/integrity-shield/integrity-shield-operator/resources"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const profileGroupVersion = "apis.integrityshield.io/v1alpha1"
+
+// kinds of profiles from which webhook rules are generated
+var profileKinds = []string{"ManifestIntegrityProfile", "NamespacedManifestIntegrityProfile"}
+
+// buildWebhookConfiguration builds the webhook configuration whose rules are generated from the current profiles.
+func (r *IntegrityShieldReconciler) buildWebhookConfiguration(instance *apiv1alpha1.IntegrityShield) (*admregv1.ValidatingWebhookConfiguration, error) {
+	profiles, err := r.listProfileMatches()
+	if err != nil {
+		return nil, err
+	}
+	served := r.servedResources()
+	return res.BuildValidatingWebhookConfigurationForProfiles(instance, profiles, served), nil
+}
+
+func (r *IntegrityShieldReconciler) listProfileMatches() ([]res.ProfileMatch, error) {
+	ctx := context.Background()
+	matches := []res.ProfileMatch{}
+	for _, kind := range profileKinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetAPIVersion(profileGroupVersion)
+		list.SetKind(kind + "List")
+		if err := r.List(ctx, list); err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			match, err := res.ProfileMatchFromObject(item)
+			if err != nil {
+				// a profile which cannot be read matches all kinds, so the wide rules are used
+				log.Error(err, "Failed to read the match condition of a profile", "Kind", kind, "Name", item.GetName())
+				match = res.ProfileMatch{}
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+// servedResources returns resources which can be created or updated in the cluster.
+// It returns nil if discovery is not available, so that the wide rules are used.
+func (r *IntegrityShieldReconciler) servedResources() []res.ServedResource {
+	if r.DiscoveryClient == nil {
+		return nil
+	}
+	lists, err := r.DiscoveryClient.ServerPreferredResources()
+	if err != nil {
+		// resources of groups which failed discovery are unknown, e.g. while an aggregated API server is down
+		log.Error(err, "Failed to discover API resources")
+		return nil
+	}
+	served := []res.ServedResource{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			// subresources are not verified
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			if !containsString(resource.Verbs, "create") && !containsString(resource.Verbs, "update") {
+				continue
+			}
+			served = append(served, res.ServedResource{
+				Group:    gv.Group,
+				Resource: resource.Name,
+				Kind:     resource.Kind,
+			})
+		}
+	}
+	return served
+}
+
+// watchProfiles starts watching profiles so that webhook rules are updated as profiles change.
+// The CRDs of profiles are created by the operator, so it can be started only after they exist.
+func (r *IntegrityShieldReconciler) watchProfiles() error {
+	r.profileWatchLock.Lock()
+	defer r.profileWatchLock.Unlock()
+	if r.controller == nil {
+		return nil
+	}
+	if r.watchedProfileKinds == nil {
+		r.watchedProfileKinds = map[string]bool{}
+	}
+	for _, kind := range profileKinds {
+		if r.watchedProfileKinds[kind] {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(profileGroupVersion)
+		obj.SetKind(kind)
+		err := r.controller.Watch(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(r.requestsForWebhookRules))
+		if err != nil {
+			return err
+		}
+		r.watchedProfileKinds[kind] = true
+	}
+	return nil
+}
+
+// requestsForWebhookRules reconciles every IntegrityShield when a profile or a served resource changes.
+func (r *IntegrityShieldReconciler) requestsForWebhookRules(obj client.Object) []reconcile.Request {
+	list := &apiv1alpha1.IntegrityShieldList{}
+	if err := r.List(context.Background(), list); err != nil {
+		log.Error(err, "Failed to list IntegrityShield")
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, instance := range list.Items {
+		if instance.Spec.UseGatekeeper {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace},
+		})
+	}
+	return requests
+}
+
+// crdServingChanged passes events of CRDs which change the resources served by the cluster, so that
+// webhook rules narrowed to the served resources cover a resource whose CRD is added later.
+func crdServingChanged() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return crdEstablished(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return crdEstablished(e.ObjectOld) != crdEstablished(e.ObjectNew) || e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration()
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+func crdEstablished(obj client.Object) bool {
+	crd, ok := obj.(*extv1.CustomResourceDefinition)
+	if !ok {
+		return false
+	}
+	for _, c := range crd.Status.Conditions {
+		if c.Type == extv1.Established {
+			return c.Status == extv1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -68,7 +68,269 @@ func BuildManifestIntegrityProfileCRD(cr *apiv1alpha1.IntegrityShield) *extv1.Cu
 		Singular:   "manifestintegrityprofile",
 		ShortNames: []string{"mip", "mips"},
 	}
-	return buildCRD("manifestintegrityprofiles.apis.integrityshield.io", cr.Namespace, crdNames, true)
+	crd := buildCRD("manifestintegrityprofiles.apis.integrityshield.io", cr.Namespace, crdNames, true)
+	// v1alpha1 is kept as the storage version; a structural schema is required for conversion
+	crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Type = "object"
+	crd.Spec.Versions = append(crd.Spec.Versions, extv1.CustomResourceDefinitionVersion{
+		Name:    "v1beta1",
+		Served:  true,
+		Storage: false,
+		Schema: &extv1.CustomResourceValidation{
+			OpenAPIV3Schema: manifestIntegrityProfileV1beta1Schema(),
+		},
+	})
+	// status is updated by the admission controller via the status subresource
+	for i := range crd.Spec.Versions {
+		crd.Spec.Versions[i].Subresources = &extv1.CustomResourceSubresources{
+			Status: &extv1.CustomResourceSubresourceStatus{},
+		}
+	}
+	// versions are converted by the admission controller; CABundle is set from the webhook server secret
+	convertPath := "/convert"
+	convertPort := int32(443)
+	crd.Spec.Conversion = &extv1.CustomResourceConversion{
+		Strategy: extv1.WebhookConverter,
+		Webhook: &extv1.WebhookConversion{
+			ClientConfig: &extv1.WebhookClientConfig{
+				Service: &extv1.ServiceReference{
+					Name:      cr.Spec.WebhookServiceName,
+					Namespace: cr.Namespace,
+					Path:      &convertPath,
+					Port:      &convertPort,
+				},
+			},
+			ConversionReviewVersions: []string{"v1", "v1beta1"},
+		},
+	}
+	return crd
+}
+
+// manifestIntegrityProfileV1beta1Schema is the schema of the curated v1beta1 API of ManifestIntegrityProfile.
+func manifestIntegrityProfileV1beta1Schema() *extv1.JSONSchemaProps {
+	trueVar := true
+	selector := extv1.JSONSchemaProps{Type: "object", XPreserveUnknownFields: &trueVar}
+	match := extv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "Match selects requests which are verified by the profile. Names of namespaces and kinds accept a wildcard `*`.",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"kinds": arraySchema("", extv1.JSONSchemaProps{
+				Type: "object",
+				Properties: map[string]extv1.JSONSchemaProps{
+					"kinds":     stringArraySchema(""),
+					"apiGroups": stringArraySchema(""),
+				},
+			}),
+			"namespaces":         stringArraySchema(""),
+			"excludedNamespaces": stringArraySchema(""),
+			"labelSelector":      selector,
+			"namespaceSelector":  selector,
+			"operations": arraySchema("Operations of requests; CREATE and UPDATE if not specified. DELETE is matched only if listed", extv1.JSONSchemaProps{
+				Type: "string",
+				Enum: []extv1.JSON{{Raw: []byte(`"CREATE"`)}, {Raw: []byte(`"UPDATE"`)}, {Raw: []byte(`"DELETE"`)}, {Raw: []byte(`"*"`)}},
+			}),
+			"users":              stringArraySchema("Patterns of requesting user names"),
+			"groups":             stringArraySchema("Patterns of groups of requesting users"),
+			"names":              stringArraySchema("Patterns of names of requested resources"),
+			"annotationSelector": selector,
+		},
+	}
+	signatureSource := map[string]extv1.JSONSchemaProps{
+		"imageRef":              {Type: "string", Description: "OCI image which contains the signed manifest"},
+		"signatureResourceRef":  resourceRefSchema(),
+		"provenanceResourceRef": resourceRefSchema(),
+	}
+	signatureRef := extv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "Where signatures are stored when they are not in annotations of the resource. All fields accept placeholders such as `{{.Namespace}}`.",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"refs": arraySchema("References tried in order until one of them verifies the resource", extv1.JSONSchemaProps{
+				Type:       "object",
+				Properties: signatureSource,
+			}),
+		},
+	}
+	for name, prop := range signatureSource {
+		signatureRef.Properties[name] = prop
+	}
+	parameters := extv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "Parameters tell how resources matched by the profile are verified.",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"signatureRef":      signatureRef,
+			"annotationDomains": stringArraySchema("Domains of signature annotations, tried in order"),
+			"signers":           stringArraySchema("Patterns of signers which are allowed to sign the resource"),
+			"keyConfigs": arraySchema("Secrets which contain public keys to verify signatures", extv1.JSONSchemaProps{
+				Type:     "object",
+				Required: []string{"keySecretName"},
+				Properties: map[string]extv1.JSONSchemaProps{
+					"keySecretName":      {Type: "string"},
+					"keySecretNamespace": {Type: "string"},
+				},
+			}),
+			"provenanceRequirements": {
+				Type:        "object",
+				Description: "Rules on SLSA provenance of the resource. A resource is allowed if at least one attestation satisfies all rules.",
+				Properties: map[string]extv1.JSONSchemaProps{
+					"builderIDs":         stringArraySchema(""),
+					"sourceRepositories": stringArraySchema(""),
+					"buildTypes":         stringArraySchema(""),
+					"materials": arraySchema("", extv1.JSONSchemaProps{
+						Type:     "object",
+						Required: []string{"uri"},
+						Properties: map[string]extv1.JSONSchemaProps{
+							"uri": {Type: "string"},
+							"digest": {
+								Type: "object",
+								AdditionalProperties: &extv1.JSONSchemaPropsOrBool{
+									Allows: true,
+									Schema: &extv1.JSONSchemaProps{Type: "string"},
+								},
+							},
+						},
+					}),
+				},
+			},
+			"failurePolicy": {
+				Type:        "string",
+				Description: "Result when the profile cannot be evaluated; the failure policy of the admission controller by default",
+				Enum:        []extv1.JSON{{Raw: []byte(`"fail-open"`)}, {Raw: []byte(`"fail-closed"`)}},
+			},
+			"ignoreFields": arraySchema("Fields which may differ from the signed manifest", extv1.JSONSchemaProps{
+				Type:     "object",
+				Required: []string{"fields"},
+				Properties: map[string]extv1.JSONSchemaProps{
+					"fields":  stringArraySchema(""),
+					"objects": arraySchema("", objectReferenceSchema()),
+				},
+			}),
+			"inScopeObjects": arraySchema("Objects which are verified; all objects if not specified", objectReferenceSchema()),
+			"skipObjects":    arraySchema("Objects which are not verified", objectReferenceSchema()),
+			"skipUsers": arraySchema("Users whose requests on the objects are not verified", extv1.JSONSchemaProps{
+				Type:     "object",
+				Required: []string{"users"},
+				Properties: map[string]extv1.JSONSchemaProps{
+					"objects": arraySchema("", objectReferenceSchema()),
+					"users":   stringArraySchema(""),
+				},
+			}),
+			"targetServiceAccount": stringArraySchema(""),
+		},
+	}
+	status := extv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"conditions":        arraySchema("", extv1.JSONSchemaProps{Type: "object", XPreserveUnknownFields: &trueVar}),
+			"allowCount":        {Type: "integer"},
+			"denyCount":         {Type: "integer"},
+			"errorCount":        {Type: "integer"},
+			"detectCount":       {Type: "integer"},
+			"lastEvaluatedTime": {Type: "string", Format: "date-time"},
+			"action":            {Type: "string", Description: "Action applied to the last evaluated request"},
+			"violations": arraySchema("", extv1.JSONSchemaProps{
+				Type: "object",
+				Properties: map[string]extv1.JSONSchemaProps{
+					"namespace": {Type: "string"},
+					"kind":      {Type: "string"},
+					"name":      {Type: "string"},
+					"message":   {Type: "string"},
+					"timestamp": {Type: "string"},
+				},
+			}),
+		},
+	}
+	return &extv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "ManifestIntegrityProfile selects requests and tells how the requested resources are verified.",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"apiVersion": {Type: "string"},
+			"kind":       {Type: "string"},
+			"metadata":   {Type: "object"},
+			"spec": {
+				Type: "object",
+				Properties: map[string]extv1.JSONSchemaProps{
+					"action": {
+						Type:        "string",
+						Description: "Action for requests which the profile would deny. `enforce` denies them, `detect` allows them with a warning and `audit-only` allows them silently. If empty, constraint-config decides whether the profile is enforced.",
+						Enum:        []extv1.JSON{{Raw: []byte(`"enforce"`)}, {Raw: []byte(`"detect"`)}, {Raw: []byte(`"audit-only"`)}},
+					},
+					"match":      match,
+					"parameters": parameters,
+				},
+			},
+			"status": status,
+		},
+	}
+}
+
+func arraySchema(description string, item extv1.JSONSchemaProps) extv1.JSONSchemaProps {
+	return extv1.JSONSchemaProps{
+		Type:        "array",
+		Description: description,
+		Items:       &extv1.JSONSchemaPropsOrArray{Schema: &item},
+	}
+}
+
+func stringArraySchema(description string) extv1.JSONSchemaProps {
+	return arraySchema(description, extv1.JSONSchemaProps{Type: "string"})
+}
+
+func resourceRefSchema() extv1.JSONSchemaProps {
+	return extv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "ConfigMap (default) or Secret",
+		Required:    []string{"name"},
+		Properties: map[string]extv1.JSONSchemaProps{
+			"kind": {
+				Type: "string",
+				Enum: []extv1.JSON{{Raw: []byte(`"ConfigMap"`)}, {Raw: []byte(`"Secret"`)}},
+			},
+			"name":      {Type: "string"},
+			"namespace": {Type: "string"},
+		},
+	}
+}
+
+func objectReferenceSchema() extv1.JSONSchemaProps {
+	return extv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]extv1.JSONSchemaProps{
+			"group":     {Type: "string"},
+			"version":   {Type: "string"},
+			"kind":      {Type: "string"},
+			"name":      {Type: "string"},
+			"namespace": {Type: "string"},
+		},
+	}
+}
+
+// namespaced profile crd; tenants manage profiles for their own namespaces
+func BuildNamespacedManifestIntegrityProfileCRD(cr *apiv1alpha1.IntegrityShield) *extv1.CustomResourceDefinition {
+	crdNames := extv1.CustomResourceDefinitionNames{
+		Kind:       "NamespacedManifestIntegrityProfile",
+		Plural:     "namespacedmanifestintegrityprofiles",
+		ListKind:   "NamespacedManifestIntegrityProfileList",
+		Singular:   "namespacedmanifestintegrityprofile",
+		ShortNames: []string{"nmip"},
+	}
+	crd := buildCRD("namespacedmanifestintegrityprofiles.apis.integrityshield.io", cr.Namespace, crdNames, true)
+	crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Type = "object"
+	// status is updated by the admission controller via the status subresource
+	crd.Spec.Versions[0].Subresources = &extv1.CustomResourceSubresources{
+		Status: &extv1.CustomResourceSubresourceStatus{},
+	}
+	return crd
+}
+
+//break glass crd
+func BuildBreakGlassCRD(cr *apiv1alpha1.IntegrityShield) *extv1.CustomResourceDefinition {
+	crdNames := extv1.CustomResourceDefinitionNames{
+		Kind:       "BreakGlass",
+		Plural:     "breakglasses",
+		ListKind:   "BreakGlassList",
+		Singular:   "breakglass",
+		ShortNames: []string{"bg"},
+	}
+	return buildCRD("breakglasses.apis.integrityshield.io", cr.Namespace, crdNames, false)
 }
 
 //shield config crd
@@ -92,6 +92,16 @@ func BuildDeploymentForIShieldServer(cr *apiv1alpha1.IntegrityShield) *appsv1.De
 				Name:  "POD_NAMESPACE",
 				Value: cr.Namespace,
 			},
+			{
+				// events on cluster-scoped objects are recorded regarding this pod
+				Name: "POD_NAME",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{
+						APIVersion: "v1",
+						FieldPath:  "metadata.name",
+					},
+				},
+			},
 			{
 				Name:  "REQUEST_HANDLER_CONFIG_KEY",
 				Value: cr.Spec.RequestHandlerConfigKey,
@@ -181,21 +191,20 @@ func BuildDeploymentForAdmissionController(cr *apiv1alpha1.IntegrityShield) *app
 		SecurityContext: cr.Spec.ControllerContainer.SecurityContext,
 		Image:           cr.Spec.ControllerContainer.Image,
 		ImagePullPolicy: cr.Spec.ControllerContainer.ImagePullPolicy,
+		// ready once informer caches for profiles and namespaces are synced
 		ReadinessProbe: &v1.Probe{
 			Handler: v1.Handler{
-				Exec: &v1.ExecAction{
-					Command: []string{
-						"ls",
-					},
+				HTTPGet: &v1.HTTPGetAction{
+					Path: "/readyz",
+					Port: intstr.IntOrString{IntVal: 8081},
 				},
 			},
 		},
 		LivenessProbe: &v1.Probe{
 			Handler: v1.Handler{
-				Exec: &v1.ExecAction{
-					Command: []string{
-						"ls",
-					},
+				HTTPGet: &v1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.IntOrString{IntVal: 8081},
 				},
 			},
 		},
@@ -212,6 +221,16 @@ func BuildDeploymentForAdmissionController(cr *apiv1alpha1.IntegrityShield) *app
 				Name:  "POD_NAMESPACE",
 				Value: cr.Namespace,
 			},
+			{
+				// events on cluster-scoped objects are recorded regarding this pod
+				Name: "POD_NAME",
+				ValueFrom: &v1.EnvVarSource{
+					FieldRef: &v1.ObjectFieldSelector{
+						APIVersion: "v1",
+						FieldPath:  "metadata.name",
+					},
+				},
+			},
 			{
 				Name:  "LOG_LEVEL",
 				Value: cr.Spec.ControllerContainer.Log.LogLevel,
@@ -17,6 +17,8 @@
 package resources
 
 import (
+	"fmt"
+
 	apiv1alpha1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
@@ -79,12 +81,47 @@ func BuildClusterRoleForIShield(cr *apiv1alpha1.IntegrityShield) *rbacv1.Cluster
 					"", "apis.integrityshield.io",
 				},
 				Resources: []string{
-					"secrets", "manifestintegrityprofiles",
+					"secrets", "manifestintegrityprofiles", "manifestintegrityprofiles/status",
+					"namespacedmanifestintegrityprofiles", "namespacedmanifestintegrityprofiles/status",
 				},
 				Verbs: []string{
 					"get", "list", "watch", "patch", "update",
 				},
 			},
+			{
+				APIGroups: []string{
+					"",
+				},
+				Resources: []string{
+					"namespaces",
+				},
+				Verbs: []string{
+					"get", "list", "watch",
+				},
+			},
+			{
+				APIGroups: []string{
+					"apis.integrityshield.io",
+				},
+				Resources: []string{
+					"breakglasses",
+				},
+				Verbs: []string{
+					"get", "list", "watch",
+				},
+			},
+			{
+				// callers of the simulation API are reviewed with the API server
+				APIGroups: []string{
+					"authentication.k8s.io", "authorization.k8s.io",
+				},
+				Resources: []string{
+					"tokenreviews", "subjectaccessreviews",
+				},
+				Verbs: []string{
+					"create",
+				},
+			},
 			{
 				APIGroups: []string{
 					"",
@@ -96,6 +133,17 @@ func BuildClusterRoleForIShield(cr *apiv1alpha1.IntegrityShield) *rbacv1.Cluster
 					"create", "update", "get",
 				},
 			},
+			{
+				APIGroups: []string{
+					"events.k8s.io",
+				},
+				Resources: []string{
+					"events",
+				},
+				Verbs: []string{
+					"create", "update", "patch",
+				},
+			},
 			// {
 			// 	APIGroups: []string{
 			// 		"apiextensions.k8s.io",
@@ -104,7 +152,7 @@ func BuildClusterRoleForIShield(cr *apiv1alpha1.IntegrityShield) *rbacv1.Cluster
 			// 		"customresourcedefinitions",
 			// 	},
 			// 	Verbs: []string{
-			// 		"get", "list", "create", "update",
+			// 		"get", "list", "watch", "create", "update",
 			// 	},
 			// },
 			{
@@ -189,8 +237,9 @@ func BuildRoleForIShield(cr *apiv1alpha1.IntegrityShield) *rbacv1.Role {
 				Resources: []string{
 					"*",
 				},
+				// configmaps are watched to invalidate the decision cache
 				Verbs: []string{
-					"get", "list", "create", "update",
+					"get", "list", "watch", "create", "update",
 				},
 			},
 		},
@@ -221,7 +270,52 @@ func BuildClusterRoleForObserver(cr *apiv1alpha1.IntegrityShield) *rbacv1.Cluste
 					"*",
 				},
 				Verbs: []string{
-					"get", "list", "create", "update",
+					"get", "list", "watch", "create", "update",
+				},
+			},
+		},
+	}
+	return role
+}
+
+// tenants who can edit resources in a namespace can also manage its profiles, and view their status
+func BuildClusterRoleForNamespacedProfileEditor(cr *apiv1alpha1.IntegrityShield) *rbacv1.ClusterRole {
+	labels := map[string]string{
+		"app":                          cr.Name,
+		"app.kubernetes.io/name":       cr.Name,
+		"app.kubernetes.io/managed-by": "operator",
+		"role":                         "security",
+		// aggregated to the default roles granted to tenants
+		"rbac.authorization.k8s.io/aggregate-to-admin": "true",
+		"rbac.authorization.k8s.io/aggregate-to-edit":  "true",
+	}
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-namespaced-profile-editor", cr.Name),
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{
+					"apis.integrityshield.io",
+				},
+				Resources: []string{
+					"namespacedmanifestintegrityprofiles",
+				},
+				Verbs: []string{
+					"get", "list", "watch", "create", "update", "patch", "delete",
+				},
+			},
+			{
+				APIGroups: []string{
+					"apis.integrityshield.io",
+				},
+				Resources: []string{
+					"namespacedmanifestintegrityprofiles/status",
+				},
+				Verbs: []string{
+					"get",
 				},
 			},
 		},
@@ -88,9 +88,13 @@ func BuildValidatingWebhookConfigurationForIShield(cr *apiv1alpha1.IntegrityShie
 	validate := "/validate-resource"
 	path = &validate
 
+	validateProfile := "/validate-profile"
+
 	var empty []byte
 
 	sideEffect := admregv1.SideEffectClassNoneOnDryRun
+	profileSideEffect := admregv1.SideEffectClassNone
+	profileFailurePolicy := admregv1.Ignore
 	timeoutSeconds := int32(apiv1alpha1.DefaultIShieldWebhookTimeout)
 
 	rules := []admregv1.RuleWithOperations{
@@ -129,6 +133,34 @@ func BuildValidatingWebhookConfigurationForIShield(cr *apiv1alpha1.IntegrityShie
 				TimeoutSeconds:          &timeoutSeconds,
 				AdmissionReviewVersions: []string{"v1beta1"},
 			},
+			// profiles with errors are rejected before they are used for admission requests
+			{
+				Name: fmt.Sprintf("ac-profile.%s.svc", cr.Namespace),
+				ClientConfig: admregv1.WebhookClientConfig{
+					Service: &admregv1.ServiceReference{
+						Name:      cr.Spec.WebhookServiceName,
+						Namespace: cr.Namespace,
+						Path:      &validateProfile,
+					},
+					CABundle: empty,
+				},
+				Rules: []admregv1.RuleWithOperations{
+					{
+						Operations: []admregv1.OperationType{
+							admregv1.Create, admregv1.Update,
+						},
+						Rule: admregv1.Rule{
+							APIGroups:   []string{"apis.integrityshield.io"},
+							APIVersions: []string{"v1alpha1"},
+							Resources:   []string{"manifestintegrityprofiles", "namespacedmanifestintegrityprofiles"},
+						},
+					},
+				},
+				FailurePolicy:           &profileFailurePolicy,
+				SideEffects:             &profileSideEffect,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			},
 		},
 	}
 	return wc
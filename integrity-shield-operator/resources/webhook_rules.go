@@ -0,0 +1,406 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	apiv1alpha1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1alpha1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// label set on every namespace by the API server since Kubernetes 1.21
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// ProfileMatch is the part of the match condition of a profile which decides the webhook rules.
+type ProfileMatch struct {
+	Kinds              []ProfileMatchKinds   `json:"kinds,omitempty"`
+	Namespaces         []string              `json:"namespaces,omitempty"`
+	ExcludedNamespaces []string              `json:"excludedNamespaces,omitempty"`
+	LabelSelector      *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	NamespaceSelector  *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	Operations         []string              `json:"operations,omitempty"`
+}
+
+type ProfileMatchKinds struct {
+	Kinds     []string `json:"kinds,omitempty"`
+	ApiGroups []string `json:"apiGroups,omitempty"`
+}
+
+// ServedResource is a resource served by the cluster, found by discovery.
+type ServedResource struct {
+	Group    string
+	Resource string
+	Kind     string
+}
+
+// ProfileMatchFromObject reads the match condition of a ManifestIntegrityProfile or a NamespacedManifestIntegrityProfile.
+// A namespaced profile only matches requests in its namespace.
+func ProfileMatchFromObject(obj unstructured.Unstructured) (ProfileMatch, error) {
+	var match ProfileMatch
+	m, found, err := unstructured.NestedMap(obj.Object, "spec", "match")
+	if err != nil {
+		return match, err
+	}
+	if found {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &match); err != nil {
+			return match, err
+		}
+	}
+	if obj.GetNamespace() != "" {
+		match.Namespaces = []string{obj.GetNamespace()}
+	}
+	return match, nil
+}
+
+// BuildValidatingWebhookConfigurationForProfiles builds the webhook configuration with the minimal rules and
+// selectors which cover the match conditions of all profiles. The wide rules of the CR are used instead if
+// any profile matches all kinds or the served resources are not known.
+func BuildValidatingWebhookConfigurationForProfiles(cr *apiv1alpha1.IntegrityShield, profiles []ProfileMatch, served []ServedResource) *admregv1.ValidatingWebhookConfiguration {
+	wc := BuildValidatingWebhookConfigurationForIShield(cr)
+	rules, ok := BuildWebhookRulesFromProfiles(profiles, served)
+	if !ok {
+		// the wide rules cover DELETE only if a profile verifies it
+		if profilesVerifyDelete(profiles) {
+			for i := range wc.Webhooks[0].Rules {
+				wc.Webhooks[0].Rules[i].Operations = append(wc.Webhooks[0].Rules[i].Operations, admregv1.Delete)
+			}
+		}
+		return wc
+	}
+	wc.Webhooks[0].Rules = rules
+	// a namespace selector is matched with labels of a Namespace object itself, so it is not narrowed if namespaces are verified
+	if !includesNamespaceResource(rules) {
+		wc.Webhooks[0].NamespaceSelector = BuildWebhookNamespaceSelectorFromProfiles(profiles)
+	}
+	wc.Webhooks[0].ObjectSelector = BuildWebhookObjectSelectorFromProfiles(profiles)
+	return wc
+}
+
+// BuildWebhookRulesFromProfiles returns a rule for each API group and set of operations with the resources
+// whose kinds are matched by profiles. It returns false if a profile matches all kinds.
+// DELETE is registered only for profiles which list it in operations explicitly.
+func BuildWebhookRulesFromProfiles(profiles []ProfileMatch, served []ServedResource) ([]admregv1.RuleWithOperations, bool) {
+	if len(served) == 0 {
+		return nil, false
+	}
+	// operations for each resource, keyed by `<group>/<resource>`
+	operations := map[string]map[admregv1.OperationType]bool{}
+	for _, p := range profiles {
+		if len(p.Kinds) == 0 {
+			return nil, false
+		}
+		ops := profileOperations(p.Operations)
+		if len(ops) == 0 {
+			continue
+		}
+		for _, k := range p.Kinds {
+			if matchesAllKinds(k) {
+				return nil, false
+			}
+			for _, r := range served {
+				if !matchKinds(k, r) {
+					continue
+				}
+				key := r.Group + "/" + r.Resource
+				if operations[key] == nil {
+					operations[key] = map[admregv1.OperationType]bool{}
+				}
+				for _, op := range ops {
+					operations[key][op] = true
+				}
+			}
+		}
+	}
+
+	// resources are grouped by API group and operations
+	type ruleKey struct {
+		group string
+		ops   string
+	}
+	resources := map[ruleKey][]string{}
+	for key, opSet := range operations {
+		parts := strings.SplitN(key, "/", 2)
+		ops := []string{}
+		for op := range opSet {
+			ops = append(ops, string(op))
+		}
+		sort.Strings(ops)
+		rk := ruleKey{group: parts[0], ops: strings.Join(ops, ",")}
+		resources[rk] = append(resources[rk], parts[1])
+	}
+	keys := []ruleKey{}
+	for rk := range resources {
+		keys = append(keys, rk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].ops < keys[j].ops
+	})
+	scope := admregv1.AllScopes
+	rules := []admregv1.RuleWithOperations{}
+	for _, rk := range keys {
+		res := resources[rk]
+		sort.Strings(res)
+		ops := []admregv1.OperationType{}
+		for _, op := range strings.Split(rk.ops, ",") {
+			ops = append(ops, admregv1.OperationType(op))
+		}
+		rules = append(rules, admregv1.RuleWithOperations{
+			Operations: ops,
+			Rule: admregv1.Rule{
+				APIGroups:   []string{rk.group},
+				APIVersions: []string{"*"},
+				Resources:   res,
+				Scope:       &scope,
+			},
+		})
+	}
+	return rules, true
+}
+
+// BuildWebhookNamespaceSelectorFromProfiles returns a namespace selector which selects every namespace matched by any profile,
+// or nil if it cannot be narrowed. Cluster scoped resources are not filtered by a namespace selector.
+func BuildWebhookNamespaceSelectorFromProfiles(profiles []ProfileMatch) *metav1.LabelSelector {
+	if len(profiles) == 0 {
+		return nil
+	}
+	var selector *metav1.LabelSelector
+	// a selector common to all profiles
+	if profiles[0].NamespaceSelector != nil {
+		selector = profiles[0].NamespaceSelector.DeepCopy()
+		for _, p := range profiles[1:] {
+			if !reflect.DeepEqual(p.NamespaceSelector, profiles[0].NamespaceSelector) {
+				selector = nil
+				break
+			}
+		}
+	}
+	// otherwise namespaces listed by all profiles without a wildcard
+	if selector == nil {
+		namespaces := []string{}
+		for _, p := range profiles {
+			if len(p.Namespaces) == 0 {
+				namespaces = nil
+				break
+			}
+			for _, ns := range p.Namespaces {
+				if strings.Contains(ns, "*") {
+					namespaces = nil
+					break
+				}
+				if !containsString(namespaces, ns) {
+					namespaces = append(namespaces, ns)
+				}
+			}
+			if namespaces == nil {
+				break
+			}
+		}
+		if len(namespaces) > 0 {
+			sort.Strings(namespaces)
+			selector = &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpIn, Values: namespaces},
+				},
+			}
+		}
+	}
+	// namespaces excluded by all profiles
+	excluded := []string{}
+	for _, ns := range profiles[0].ExcludedNamespaces {
+		if strings.Contains(ns, "*") {
+			continue
+		}
+		all := true
+		for _, p := range profiles[1:] {
+			if !containsString(p.ExcludedNamespaces, ns) {
+				all = false
+				break
+			}
+		}
+		if all && !containsString(excluded, ns) {
+			excluded = append(excluded, ns)
+		}
+	}
+	if len(excluded) > 0 {
+		sort.Strings(excluded)
+		if selector == nil {
+			selector = &metav1.LabelSelector{}
+		}
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: excluded,
+		})
+	}
+	return selector
+}
+
+// BuildWebhookObjectSelectorFromProfiles returns the label selector of profiles if all profiles have the same one.
+func BuildWebhookObjectSelectorFromProfiles(profiles []ProfileMatch) *metav1.LabelSelector {
+	if len(profiles) == 0 || profiles[0].LabelSelector == nil {
+		return nil
+	}
+	for _, p := range profiles[1:] {
+		if !reflect.DeepEqual(p.LabelSelector, profiles[0].LabelSelector) {
+			return nil
+		}
+	}
+	return profiles[0].LabelSelector.DeepCopy()
+}
+
+// WebhookRulesChanged reports whether the rules or selectors of the resource webhook differ.
+func WebhookRulesChanged(found, expected *admregv1.ValidatingWebhookConfiguration) bool {
+	if len(found.Webhooks) == 0 || len(expected.Webhooks) == 0 {
+		return len(found.Webhooks) != len(expected.Webhooks)
+	}
+	f, e := found.Webhooks[0], expected.Webhooks[0]
+	// empty rules and selectors are set by defaulting of the API server
+	if len(f.Rules) != 0 || len(e.Rules) != 0 {
+		if !reflect.DeepEqual(f.Rules, e.Rules) {
+			return true
+		}
+	}
+	return !equalSelector(f.NamespaceSelector, e.NamespaceSelector) || !equalSelector(f.ObjectSelector, e.ObjectSelector)
+}
+
+func equalSelector(a, b *metav1.LabelSelector) bool {
+	if a == nil {
+		a = &metav1.LabelSelector{}
+	}
+	if b == nil {
+		b = &metav1.LabelSelector{}
+	}
+	if len(a.MatchLabels) == 0 && len(b.MatchLabels) == 0 && len(a.MatchExpressions) == 0 && len(b.MatchExpressions) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func includesNamespaceResource(rules []admregv1.RuleWithOperations) bool {
+	for _, rule := range rules {
+		if containsString(rule.APIGroups, "") && containsString(rule.Resources, "namespaces") {
+			return true
+		}
+	}
+	return false
+}
+
+// profileOperations returns the operations registered for a profile; CREATE and UPDATE if not specified.
+// A wildcard does not include DELETE, which is matched only if it is listed.
+func profileOperations(operations []string) []admregv1.OperationType {
+	if len(operations) == 0 {
+		return []admregv1.OperationType{admregv1.Create, admregv1.Update}
+	}
+	ops := []admregv1.OperationType{}
+	for _, op := range operations {
+		switch strings.ToUpper(op) {
+		case "*":
+			ops = append(ops, admregv1.Create, admregv1.Update)
+		case string(admregv1.Create):
+			ops = append(ops, admregv1.Create)
+		case string(admregv1.Update):
+			ops = append(ops, admregv1.Update)
+		case string(admregv1.Delete):
+			ops = append(ops, admregv1.Delete)
+		}
+	}
+	return ops
+}
+
+func profilesVerifyDelete(profiles []ProfileMatch) bool {
+	for _, p := range profiles {
+		for _, op := range p.Operations {
+			if strings.ToUpper(op) == string(admregv1.Delete) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAllKinds(k ProfileMatchKinds) bool {
+	if len(k.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range k.Kinds {
+		if kind == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchKinds(k ProfileMatchKinds, r ServedResource) bool {
+	kindMatched := false
+	for _, kind := range k.Kinds {
+		if matchPattern(kind, r.Kind) {
+			kindMatched = true
+			break
+		}
+	}
+	if !kindMatched {
+		return false
+	}
+	if len(k.ApiGroups) == 0 {
+		return true
+	}
+	for _, g := range k.ApiGroups {
+		if matchPattern(g, r.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a value with a pattern in which `*` matches any string, as profiles are matched.
+func matchPattern(pattern, value string) bool {
+	if pattern == "*" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1alpha1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1alpha1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testServedResources = []ServedResource{
+	{Group: "", Resource: "configmaps", Kind: "ConfigMap"},
+	{Group: "", Resource: "secrets", Kind: "Secret"},
+	{Group: "", Resource: "namespaces", Kind: "Namespace"},
+	{Group: "apps", Resource: "deployments", Kind: "Deployment"},
+	{Group: "apps", Resource: "daemonsets", Kind: "DaemonSet"},
+	{Group: "rbac.authorization.k8s.io", Resource: "roles", Kind: "Role"},
+	{Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Kind: "ClusterRole"},
+}
+
+func testRule(group string, resources []string, ops ...admregv1.OperationType) admregv1.RuleWithOperations {
+	scope := admregv1.AllScopes
+	return admregv1.RuleWithOperations{
+		Operations: ops,
+		Rule: admregv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{"*"},
+			Resources:   resources,
+			Scope:       &scope,
+		},
+	}
+}
+
+func TestBuildWebhookRulesFromProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []ProfileMatch
+		served   []ServedResource
+		want     []admregv1.RuleWithOperations
+		// false if the wide rules of the IntegrityShield CR are used
+		narrowed bool
+	}{
+		{
+			name:     "served resources are unknown",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}}}},
+			served:   nil,
+			narrowed: false,
+		},
+		{
+			name:     "profile without kinds",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}}}, {Namespaces: []string{"sample-ns"}}},
+			served:   testServedResources,
+			narrowed: false,
+		},
+		{
+			name:     "kinds entry without kinds",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{ApiGroups: []string{"apps"}}}}},
+			served:   testServedResources,
+			narrowed: false,
+		},
+		{
+			name:     "wildcard kind",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}, {Kinds: []string{"*"}, ApiGroups: []string{"apps"}}}}},
+			served:   testServedResources,
+			narrowed: false,
+		},
+		{
+			name:     "single kind",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}}}},
+			served:   testServedResources,
+			want:     []admregv1.RuleWithOperations{testRule("", []string{"configmaps"}, admregv1.Create, admregv1.Update)},
+			narrowed: true,
+		},
+		{
+			name:     "kind pattern across groups",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"*Role"}}}}},
+			served:   testServedResources,
+			want:     []admregv1.RuleWithOperations{testRule("rbac.authorization.k8s.io", []string{"clusterroles", "roles"}, admregv1.Create, admregv1.Update)},
+			narrowed: true,
+		},
+		{
+			name:     "kind with api group",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"Deployment", "Secret"}, ApiGroups: []string{"apps"}}}}},
+			served:   testServedResources,
+			want:     []admregv1.RuleWithOperations{testRule("apps", []string{"deployments"}, admregv1.Create, admregv1.Update)},
+			narrowed: true,
+		},
+		{
+			name:     "kind not served",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"Unknown"}}}}},
+			served:   testServedResources,
+			want:     []admregv1.RuleWithOperations{},
+			narrowed: true,
+		},
+		{
+			name: "operations of profiles",
+			profiles: []ProfileMatch{
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap", "Secret"}}}, Operations: []string{"CREATE"}},
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"Secret"}}}, Operations: []string{"update"}},
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"Deployment"}}}, Operations: []string{"*"}},
+			},
+			served: testServedResources,
+			want: []admregv1.RuleWithOperations{
+				testRule("", []string{"configmaps"}, admregv1.Create),
+				testRule("", []string{"secrets"}, admregv1.Create, admregv1.Update),
+				testRule("apps", []string{"deployments"}, admregv1.Create, admregv1.Update),
+			},
+			narrowed: true,
+		},
+		{
+			name: "profile only for delete",
+			profiles: []ProfileMatch{
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"Secret"}}}, Operations: []string{"DELETE"}},
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}}},
+			},
+			served: testServedResources,
+			want: []admregv1.RuleWithOperations{
+				testRule("", []string{"configmaps"}, admregv1.Create, admregv1.Update),
+				testRule("", []string{"secrets"}, admregv1.Delete),
+			},
+			narrowed: true,
+		},
+		{
+			name: "delete is not included in wildcard",
+			profiles: []ProfileMatch{
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"ConfigMap"}}}, Operations: []string{"*", "delete"}},
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"Secret"}}}, Operations: []string{"*"}},
+			},
+			served: testServedResources,
+			want: []admregv1.RuleWithOperations{
+				testRule("", []string{"configmaps"}, admregv1.Create, admregv1.Delete, admregv1.Update),
+				testRule("", []string{"secrets"}, admregv1.Create, admregv1.Update),
+			},
+			narrowed: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, narrowed := BuildWebhookRulesFromProfiles(tc.profiles, tc.served)
+			if narrowed != tc.narrowed {
+				t.Fatalf("expected narrowed=%v, but got %v with rules %+v", tc.narrowed, narrowed, rules)
+			}
+			if !narrowed {
+				return
+			}
+			if !reflect.DeepEqual(rules, tc.want) {
+				t.Errorf("unexpected rules\nwant: %+v\ngot:  %+v", tc.want, rules)
+			}
+		})
+	}
+}
+
+func TestBuildWebhookNamespaceSelectorFromProfiles(t *testing.T) {
+	selectorA := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+	selectorB := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}
+	nameIn := func(values ...string) metav1.LabelSelectorRequirement {
+		return metav1.LabelSelectorRequirement{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpIn, Values: values}
+	}
+	nameNotIn := func(values ...string) metav1.LabelSelectorRequirement {
+		return metav1.LabelSelectorRequirement{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: values}
+	}
+
+	tests := []struct {
+		name     string
+		profiles []ProfileMatch
+		want     *metav1.LabelSelector
+	}{
+		{
+			name:     "no profiles",
+			profiles: nil,
+			want:     nil,
+		},
+		{
+			name:     "no namespace conditions",
+			profiles: []ProfileMatch{{}, {}},
+			want:     nil,
+		},
+		{
+			name:     "common namespace selector",
+			profiles: []ProfileMatch{{NamespaceSelector: selectorA}, {NamespaceSelector: selectorA}},
+			want:     selectorA,
+		},
+		{
+			name:     "mixed namespace selectors",
+			profiles: []ProfileMatch{{NamespaceSelector: selectorA}, {NamespaceSelector: selectorB}},
+			want:     nil,
+		},
+		{
+			name:     "namespace selector and namespace list",
+			profiles: []ProfileMatch{{NamespaceSelector: selectorA, Namespaces: []string{"ns-a"}}, {Namespaces: []string{"ns-b"}}},
+			want:     &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{nameIn("ns-a", "ns-b")}},
+		},
+		{
+			name:     "namespace lists",
+			profiles: []ProfileMatch{{Namespaces: []string{"ns-b", "ns-a"}}, {Namespaces: []string{"ns-a", "ns-c"}}},
+			want:     &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{nameIn("ns-a", "ns-b", "ns-c")}},
+		},
+		{
+			name:     "profile without namespaces",
+			profiles: []ProfileMatch{{Namespaces: []string{"ns-a"}}, {}},
+			want:     nil,
+		},
+		{
+			name:     "wildcard namespace",
+			profiles: []ProfileMatch{{Namespaces: []string{"ns-a"}}, {Namespaces: []string{"sample-*"}}},
+			want:     nil,
+		},
+		{
+			name: "namespaces excluded by all profiles",
+			profiles: []ProfileMatch{
+				{ExcludedNamespaces: []string{"kube-system", "kube-*", "openshift"}},
+				{ExcludedNamespaces: []string{"openshift", "kube-system"}},
+			},
+			want: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{nameNotIn("kube-system", "openshift")}},
+		},
+		{
+			name: "namespaces excluded by some profiles",
+			profiles: []ProfileMatch{
+				{ExcludedNamespaces: []string{"kube-system"}},
+				{},
+			},
+			want: nil,
+		},
+		{
+			name: "common namespace selector with excluded namespaces",
+			profiles: []ProfileMatch{
+				{NamespaceSelector: selectorA, ExcludedNamespaces: []string{"kube-system"}},
+				{NamespaceSelector: selectorA, ExcludedNamespaces: []string{"kube-system"}},
+			},
+			want: &metav1.LabelSelector{
+				MatchLabels:      map[string]string{"team": "a"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{nameNotIn("kube-system")},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := BuildWebhookNamespaceSelectorFromProfiles(tc.profiles)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("unexpected namespace selector\nwant: %+v\ngot:  %+v", tc.want, got)
+			}
+		})
+	}
+	if len(selectorA.MatchExpressions) != 0 {
+		t.Errorf("namespace selector of a profile is modified: %+v", selectorA)
+	}
+}
+
+func TestBuildValidatingWebhookConfigurationForProfilesWithDelete(t *testing.T) {
+	cr := &apiv1alpha1.IntegrityShield{}
+	cr.Spec.WebhookNamespacedResource = admregv1.Rule{APIGroups: []string{"*"}, APIVersions: []string{"*"}, Resources: []string{"*"}}
+	cr.Spec.WebhookClusterResource = admregv1.Rule{APIGroups: []string{"*"}, APIVersions: []string{"*"}, Resources: []string{"*"}}
+
+	tests := []struct {
+		name     string
+		profiles []ProfileMatch
+		want     []admregv1.OperationType
+	}{
+		{
+			name:     "wide rules",
+			profiles: []ProfileMatch{{Kinds: []ProfileMatchKinds{{Kinds: []string{"*"}}}, Operations: []string{"*"}}},
+			want:     []admregv1.OperationType{admregv1.Create, admregv1.Update},
+		},
+		{
+			name: "wide rules with a profile for delete",
+			profiles: []ProfileMatch{
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"*"}}}},
+				{Kinds: []ProfileMatchKinds{{Kinds: []string{"Secret"}}}, Operations: []string{"DELETE"}},
+			},
+			want: []admregv1.OperationType{admregv1.Create, admregv1.Update, admregv1.Delete},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wc := BuildValidatingWebhookConfigurationForProfiles(cr, tc.profiles, testServedResources)
+			for _, rule := range wc.Webhooks[0].Rules {
+				if !reflect.DeepEqual(rule.Operations, tc.want) {
+					t.Errorf("expected operations %v, but got %v", tc.want, rule.Operations)
+				}
+			}
+		})
+	}
+}
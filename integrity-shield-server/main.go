@@ -18,10 +18,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
@@ -36,6 +40,9 @@ const (
 	tlsKeyFile  = `tls.key`
 )
 
+// clientCAConfigured is true if client certificates are verified with CLIENT_CA_FILE.
+var clientCAConfigured bool
+
 func init() {
 	log.SetFormatter(&log.JSONFormatter{})
 	log.Info("Integrity Shield has been started.")
@@ -106,7 +113,21 @@ func requestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := shield.RequestHandler(*request, parameters)
+	// action and dry-run are set by the admission controller in remote mode. They are honoured only from
+	// a client authenticated with a certificate, so that other clients cannot suppress denials or audit records.
+	dryRun := false
+	if clientCertVerified(r) {
+		if action, ok := inputMap["action"].(string); ok {
+			parameters.Action = action
+		}
+		dryRun, _ = inputMap["dryRun"].(bool)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), shield.DefaultRequestTimeout)
+	defer cancel()
+	ec := shield.NewEvaluationContext(*request)
+	ec.DryRun = dryRun
+	result := shield.EvaluateRequest(ctx, ec, parameters)
 	resp, err := json.Marshal(result)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("marshaling request handler result: %v", err), http.StatusInternalServerError)
@@ -119,6 +140,22 @@ func requestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// requireClientCert rejects requests without a verified client certificate if a client CA is configured.
+func requireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if clientCAConfigured && !clientCertVerified(r) {
+			http.Error(w, "a client certificate is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientCertVerified reports whether the client presented a certificate issued by the client CA.
+func clientCertVerified(r *http.Request) bool {
+	return clientCAConfigured && r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
 func checkLiveness(w http.ResponseWriter, r *http.Request) {
 	msg := "liveness ok"
 	_, _ = w.Write([]byte(msg))
@@ -142,13 +179,30 @@ func main() {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api", defaultHandler)
-	mux.HandleFunc("/api/request", requestHandler)
+	mux.HandleFunc("/api/request", requireClientCert(requestHandler))
 	mux.HandleFunc("/health/liveness", checkLiveness)
 	mux.HandleFunc("/health/readiness", checkReadiness)
 
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	// client certificates are verified if a client CA is given, e.g. for the admission controller in remote mode.
+	// Kubelet probes do not send a certificate, so a certificate is required only by the request API.
+	if clientCAPath := os.Getenv("CLIENT_CA_FILE"); clientCAPath != "" {
+		clientCA, err := ioutil.ReadFile(clientCAPath)
+		if err != nil {
+			panic(fmt.Sprintf("unable to load client CA: %v", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(clientCA) {
+			panic(fmt.Sprintf("no client CA certificate is found in %s", clientCAPath))
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		clientCAConfigured = true
+	}
+
 	serverObj := &http.Server{
 		Addr:      ":8080",
-		TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12},
+		TLSConfig: tlsConfig,
 		Handler:   mux,
 	}
 
@@ -0,0 +1,282 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package audit
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	SourceAdmissionController = "admission-controller"
+	SourceRequestHandler      = "request-handler"
+)
+
+const (
+	defaultBufferSize    = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = 1 * time.Second
+	dropLogInterval      = 1000
+)
+
+// Record is a single admission decision written to audit sinks.
+type Record struct {
+	Time       time.Time         `json:"time"`
+	Source     string            `json:"source"`
+	RequestUID string            `json:"requestUID"`
+	Operation  string            `json:"operation"`
+	User       UserInfo          `json:"user"`
+	Object     ObjectReference   `json:"object"`
+	Allow      bool              `json:"allow"`
+	Reason     string            `json:"reason,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Signer     string            `json:"signer,omitempty"`
+	Profiles   []ProfileDecision `json:"profiles,omitempty"`
+	LatencyMs  float64           `json:"latencyMs"`
+	// name of the break-glass grant by which verification was bypassed
+	BreakGlass string `json:"breakGlass,omitempty"`
+	// whether the decision was reused from the decision cache of an identical request
+	Cached bool `json:"cached,omitempty"`
+}
+
+type UserInfo struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+type ProfileDecision struct {
+	Name             string `json:"name"`
+	Allow            bool   `json:"allow"`
+	Reason           string `json:"reason,omitempty"`
+	Message          string `json:"message,omitempty"`
+	Signer           string `json:"signer,omitempty"`
+	AnnotationDomain string `json:"annotationDomain,omitempty"`
+	// action of the profile and whether the profile would have denied the request if it were enforced
+	Action   string `json:"action,omitempty"`
+	Detected bool   `json:"detected,omitempty"`
+}
+
+// NewRecord fills request attributes of a record; the caller sets the decision.
+func NewRecord(source string, req admission.Request, start time.Time) *Record {
+	gv := schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}
+	now := time.Now()
+	return &Record{
+		Time:       now.UTC(),
+		Source:     source,
+		RequestUID: string(req.UID),
+		Operation:  string(req.Operation),
+		User: UserInfo{
+			Username: req.UserInfo.Username,
+			Groups:   req.UserInfo.Groups,
+		},
+		Object: ObjectReference{
+			APIVersion: gv.String(),
+			Kind:       req.Kind.Kind,
+			Namespace:  req.Namespace,
+			Name:       req.Name,
+		},
+		LatencyMs: float64(now.Sub(start).Microseconds()) / 1000,
+	}
+}
+
+var (
+	dispatchersMu sync.Mutex
+	dispatchers   = map[string]*Dispatcher{}
+	// configs with which dispatchers failed to be built; they are not retried until the config of the owner is changed
+	failedConfigs = map[string]k8smnfconfig.AuditConfig{}
+)
+
+// Emit hands a record to the dispatcher owned by `owner`. The dispatcher is (re)built
+// whenever the given config differs from the one it was built with.
+// Emit never blocks; records are dropped when sink buffers are full.
+func Emit(owner string, config k8smnfconfig.AuditConfig, record *Record) {
+	if !config.Enabled || len(config.Sinks) == 0 || record == nil {
+		return
+	}
+	d := getDispatcher(owner, config)
+	if d == nil {
+		return
+	}
+	d.Emit(record)
+}
+
+func getDispatcher(owner string, config k8smnfconfig.AuditConfig) *Dispatcher {
+	dispatchersMu.Lock()
+	defer dispatchersMu.Unlock()
+	current, ok := dispatchers[owner]
+	if ok && reflect.DeepEqual(current.config, config) {
+		return current
+	}
+	if failed, isFailed := failedConfigs[owner]; isFailed && reflect.DeepEqual(failed, config) {
+		return nil
+	}
+	d, err := NewDispatcher(config)
+	if err != nil {
+		log.Errorf("failed to initialize audit sinks; records are dropped until the audit config is changed; %s", err.Error())
+		failedConfigs[owner] = config
+		if ok {
+			delete(dispatchers, owner)
+			go current.Close()
+		}
+		return nil
+	}
+	delete(failedConfigs, owner)
+	dispatchers[owner] = d
+	if ok {
+		// flush remaining records of the old config in background
+		go current.Close()
+	}
+	return d
+}
+
+// Dispatcher fans out records to buffered sinks.
+type Dispatcher struct {
+	mu     sync.RWMutex
+	closed bool
+	config k8smnfconfig.AuditConfig
+	sinks  []*bufferedSink
+}
+
+func NewDispatcher(config k8smnfconfig.AuditConfig) (*Dispatcher, error) {
+	d := &Dispatcher{config: config}
+	for _, sc := range config.Sinks {
+		sink, err := NewSink(sc)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.sinks = append(d.sinks, newBufferedSink(sink, sc))
+	}
+	return d, nil
+}
+
+func (d *Dispatcher) Emit(record *Record) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, s := range d.sinks {
+		s.enqueue(record)
+	}
+}
+
+// Close stops accepting records and waits until buffered records are flushed.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, s := range d.sinks {
+		close(s.queue)
+	}
+	d.mu.Unlock()
+	for _, s := range d.sinks {
+		<-s.done
+	}
+}
+
+// bufferedSink decouples the admission path from a sink with a bounded queue.
+type bufferedSink struct {
+	sink          Sink
+	queue         chan *Record
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	done          chan struct{}
+}
+
+func newBufferedSink(sink Sink, config k8smnfconfig.AuditSinkConfig) *bufferedSink {
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	s := &bufferedSink{
+		sink:          sink,
+		queue:         make(chan *Record, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: defaultFlushInterval,
+		done:          make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *bufferedSink) enqueue(record *Record) {
+	select {
+	case s.queue <- record:
+	default:
+		dropped := atomic.AddUint64(&s.dropped, 1)
+		if dropped%dropLogInterval == 1 {
+			log.Warningf("audit sink `%s` is too slow; %d records have been dropped", s.sink.Name(), dropped)
+		}
+	}
+}
+
+func (s *bufferedSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Record, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.sink.Write(batch); err != nil {
+			log.Errorf("failed to write %d audit records to sink `%s`; %s", len(batch), s.sink.Name(), err.Error())
+		}
+		batch = make([]*Record, 0, s.batchSize)
+	}
+	for {
+		select {
+		case record, ok := <-s.queue:
+			if !ok {
+				flush()
+				if err := s.sink.Close(); err != nil {
+					log.Errorf("failed to close audit sink `%s`; %s", s.sink.Name(), err.Error())
+				}
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,383 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package audit
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	defaultMaxSizeMB      = 100
+	defaultTimeoutSeconds = 5
+	maxRetry              = 3
+	retryInterval         = 200 * time.Millisecond
+
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsType        = "io.integrityshield.admission.decision"
+	cloudEventsSource      = "/integrity-shield"
+	cloudEventsContentType = "application/cloudevents+json; charset=UTF-8"
+)
+
+// Sink writes a batch of records. Write is called from a single goroutine per sink.
+type Sink interface {
+	Name() string
+	Write(records []*Record) error
+	Close() error
+}
+
+func NewSink(config k8smnfconfig.AuditSinkConfig) (Sink, error) {
+	name := config.Name
+	if name == "" {
+		name = config.Type
+	}
+	switch config.Type {
+	case k8smnfconfig.AuditSinkTypeFile:
+		return newFileSink(name, config)
+	case k8smnfconfig.AuditSinkTypeWebhook:
+		client, err := newHTTPClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return &webhookSink{name: name, url: config.URL, headers: config.Headers, client: client}, nil
+	case k8smnfconfig.AuditSinkTypeCloudEvents:
+		client, err := newHTTPClient(config)
+		if err != nil {
+			return nil, err
+		}
+		source := config.EventSource
+		if source == "" {
+			source = cloudEventsSource
+		}
+		return &cloudEventsSink{name: name, url: config.URL, headers: config.Headers, source: source, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown audit sink type `%s`", config.Type)
+}
+
+//
+// JSONL file
+//
+
+var (
+	fileWritersMu sync.Mutex
+	fileWriters   = map[string]*fileWriter{}
+)
+
+// fileSink is a reference to the file writer of a path. Sinks of all owners and configs which
+// write to the same path share one writer, so that records are not interleaved and the file is rotated once.
+type fileSink struct {
+	name   string
+	writer *fileWriter
+	closed bool
+}
+
+func newFileSink(name string, config k8smnfconfig.AuditSinkConfig) (*fileSink, error) {
+	if config.Path == "" {
+		return nil, errors.New("`path` is required for file audit sink")
+	}
+	maxSizeMB := config.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	path := filepath.Clean(config.Path)
+
+	fileWritersMu.Lock()
+	defer fileWritersMu.Unlock()
+	w, ok := fileWriters[path]
+	if ok {
+		if w.maxBytes != int64(maxSizeMB)*1024*1024 || w.maxBackups != config.MaxBackups {
+			log.Warningf("audit log file `%s` is shared by sinks with different rotation settings; the settings of the first sink are used", path)
+		}
+	} else {
+		w = &fileWriter{
+			path:       path,
+			maxBytes:   int64(maxSizeMB) * 1024 * 1024,
+			maxBackups: config.MaxBackups,
+		}
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+		fileWriters[path] = w
+	}
+	w.refs++
+	return &fileSink{name: name, writer: w}, nil
+}
+
+func (s *fileSink) Name() string {
+	return s.name
+}
+
+func (s *fileSink) Write(records []*Record) error {
+	return s.writer.write(records)
+}
+
+// Close closes the file when no other sink writes to it.
+func (s *fileSink) Close() error {
+	fileWritersMu.Lock()
+	defer fileWritersMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.writer.refs--
+	if s.writer.refs > 0 {
+		return nil
+	}
+	delete(fileWriters, s.writer.path)
+	return s.writer.close()
+}
+
+// fileWriter appends one JSON record per line and rotates the file when it exceeds maxSizeMB.
+type fileWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	// number of sinks which write to the file; guarded by fileWritersMu
+	refs int
+}
+
+func (w *fileWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create audit log directory")
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to open audit log file `%s`", w.path))
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+func (w *fileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if w.maxBackups > 0 {
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *fileWriter) write(records []*Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		if w.size > 0 && w.size+int64(len(line)) > w.maxBytes {
+			if err := w.rotate(); err != nil {
+				return errors.Wrap(err, "failed to rotate audit log file")
+			}
+		}
+		n, err := w.file.Write(line)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *fileWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+//
+// HTTPS webhook
+//
+
+// webhookSink posts a batch of records as a JSON array.
+type webhookSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *webhookSink) Name() string {
+	return s.name
+}
+
+func (s *webhookSink) Write(records []*Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.url, "application/json", s.headers, body)
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+//
+// CloudEvents HTTP binding (structured content mode)
+//
+
+type cloudEventsSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	source  string
+	client  *http.Client
+}
+
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	ID              string    `json:"id"`
+	Time            time.Time `json:"time"`
+	Subject         string    `json:"subject,omitempty"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            *Record   `json:"data"`
+}
+
+func (s *cloudEventsSink) Name() string {
+	return s.name
+}
+
+func (s *cloudEventsSink) Write(records []*Record) error {
+	for _, r := range records {
+		subject := r.Object.Kind + "/" + r.Object.Name
+		if r.Object.Namespace != "" {
+			subject = r.Object.Namespace + "/" + subject
+		}
+		evt := cloudEvent{
+			SpecVersion:     cloudEventsSpecVersion,
+			Type:            cloudEventsType,
+			Source:          s.source + "/" + r.Source,
+			ID:              string(uuid.NewUUID()),
+			Time:            r.Time,
+			Subject:         subject,
+			DataContentType: "application/json",
+			Data:            r,
+		}
+		body, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		if err := post(s.client, s.url, cloudEventsContentType, s.headers, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *cloudEventsSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func newHTTPClient(config k8smnfconfig.AuditSinkConfig) (*http.Client, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("`url` is required for %s audit sink", config.Type)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read CA file `%s`", config.CAFile))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificate is found in CA file `%s`", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	timeout := config.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = defaultTimeoutSeconds
+	}
+	return &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, MaxIdleConnsPerHost: 2},
+	}, nil
+}
+
+func post(client *http.Client, url, contentType string, headers map[string]string, body []byte) error {
+	var lastErr error
+	for i := 0; i < maxRetry; i++ {
+		if i > 0 {
+			time.Sleep(retryInterval * time.Duration(1<<uint(i-1)))
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("audit sink returned status %d", resp.StatusCode)
+		// client errors are not retried
+		if resp.StatusCode < 500 {
+			break
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned without calling the server while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit to integrity shield server is open after consecutive failures")
+
+// circuitBreaker stops calls to a failing server for a while so that admission requests fail fast
+// instead of waiting for timeouts. After the open period, one call is let through to probe the server.
+type circuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openPeriod       time.Duration
+	failures         int
+	openedAt         time.Time
+	probing          bool
+}
+
+func newCircuitBreaker(failureThreshold int, openPeriod time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openPeriod:       openPeriod,
+	}
+}
+
+// allow reports whether a call can be sent.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.failureThreshold {
+		return true
+	}
+	if time.Since(cb.openedAt) < cb.openPeriod || cb.probing {
+		return false
+	}
+	// half-open
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures >= cb.failureThreshold {
+		log.Info("circuit to integrity shield server is closed")
+	}
+	cb.failures = 0
+	cb.probing = false
+}
+
+// cancel releases a probe which ended without a result.
+func (cb *circuitBreaker) cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.failures >= cb.failureThreshold {
+		if !cb.probing {
+			log.Warningf("circuit to integrity shield server is open for %s after %d consecutive failures", cb.openPeriod, cb.failures)
+		}
+		cb.openedAt = time.Now()
+		cb.probing = false
+	}
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	openPeriod := 50 * time.Millisecond
+	cb := newCircuitBreaker(2, openPeriod)
+
+	// closed
+	if !cb.allow() {
+		t.Fatal("closed circuit rejects a call")
+	}
+	cb.failure()
+	if !cb.allow() {
+		t.Fatal("circuit is opened before the failure threshold")
+	}
+	cb.failure()
+
+	// open
+	if cb.allow() {
+		t.Fatal("open circuit allows a call")
+	}
+
+	// half-open: only one probe is let through
+	time.Sleep(openPeriod)
+	if !cb.allow() {
+		t.Fatal("circuit does not allow a probe after the open period")
+	}
+	if cb.allow() {
+		t.Fatal("circuit allows a second call while probing")
+	}
+
+	// a failed probe opens the circuit again
+	cb.failure()
+	if cb.allow() {
+		t.Fatal("circuit is not opened again after a failed probe")
+	}
+
+	// a cancelled probe is released without closing the circuit
+	time.Sleep(openPeriod)
+	if !cb.allow() {
+		t.Fatal("circuit does not allow a probe after the open period")
+	}
+	cb.cancel()
+	if !cb.allow() {
+		t.Fatal("circuit does not allow a probe after a cancelled probe")
+	}
+
+	// a successful probe closes the circuit
+	cb.success()
+	for i := 0; i < 3; i++ {
+		if !cb.allow() {
+			t.Fatal("circuit is not closed after a successful probe")
+		}
+	}
+	cb.failure()
+	if !cb.allow() {
+		t.Fatal("failures before the circuit was closed are counted")
+	}
+}
@@ -0,0 +1,291 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	defaultTimeoutSeconds           = 10
+	defaultMaxRetries               = 2
+	defaultRetryBackoffMilliseconds = 100
+	defaultMaxIdleConnsPerHost      = 16
+	defaultFailureThreshold         = 5
+	defaultOpenSeconds              = 30
+)
+
+// Config is the config of a client of the request handler API of integrity shield server.
+type Config struct {
+	// URL of the request handler API, e.g. https://integrity-shield-api.<namespace>.svc:8123/api/request
+	URL string `json:"url,omitempty"`
+	// CA certificate to verify the server, and a client certificate and key for mTLS
+	CAFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// server name in the certificate of the server if it is different from the host of URL
+	ServerName string `json:"serverName,omitempty"`
+	// timeout of each attempt; a request is never evaluated beyond the deadline of its context
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// retries after a connection error, a 5xx response or a 429 response, with exponential backoff
+	MaxRetries               int `json:"maxRetries,omitempty"`
+	RetryBackoffMilliseconds int `json:"retryBackoffMilliseconds,omitempty"`
+	// idle connections kept open to the server
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty"`
+	// calls are rejected without being sent for openSeconds after failureThreshold consecutive failures,
+	// which are connection errors and 5xx responses
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	OpenSeconds      int `json:"openSeconds,omitempty"`
+}
+
+// EvaluateInput is the body of a request to the request handler API.
+type EvaluateInput struct {
+	Request    *admission.Request            `json:"request"`
+	Parameters *k8smnfconfig.ParameterObject `json:"parameters"`
+	// action of the profile, which is not serialized with parameters
+	Action string `json:"action,omitempty"`
+	// DryRun disables side effects of the evaluation such as events and audit records
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Client calls the request handler API of integrity shield server. It is safe for concurrent use,
+// and should be shared so that connections are reused.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	breaker    *circuitBreaker
+}
+
+// NewClient creates a client with a connection pool and a circuit breaker.
+func NewClient(config Config) (*Client, error) {
+	if config.URL == "" {
+		return nil, errors.New("url of integrity shield server is empty")
+	}
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = config.getMaxIdleConnsPerHost()
+	transport.MaxIdleConns = config.getMaxIdleConnsPerHost()
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Transport: transport},
+		breaker:    newCircuitBreaker(config.getFailureThreshold(), time.Duration(config.getOpenSeconds())*time.Second),
+	}, nil
+}
+
+// Config returns the config with which the client is created.
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// Evaluate calls the request handler of integrity shield server for a request and the parameters of a profile.
+// An error is returned only if the server cannot decide the result; a denial is a result.
+func (c *Client) Evaluate(ctx context.Context, req admission.Request, paramObj *k8smnfconfig.ParameterObject, dryRun bool) (*shield.ResultFromRequestHandler, error) {
+	body, err := json.Marshal(EvaluateInput{
+		Request:    &req,
+		Parameters: paramObj,
+		Action:     paramObj.Action,
+		DryRun:     dryRun,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the input of request handler")
+	}
+	if !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	res, err := c.evaluateWithRetry(ctx, body)
+	switch {
+	case err == nil:
+		c.breaker.success()
+	case ctx.Err() != nil:
+		// a request cancelled by the caller is not a failure of the server
+		c.breaker.cancel()
+	case isServerFailure(err):
+		c.breaker.failure()
+	default:
+		// the server is reachable though it rejects the request, e.g. with a 4xx response
+		c.breaker.success()
+	}
+	return res, err
+}
+
+// callError is an error of a call to integrity shield server.
+type callError struct {
+	error
+	// serverFailure is true for a transport error or a 5xx response, which are counted by the circuit breaker
+	serverFailure bool
+	// retryable is true if the call may succeed when it is sent again
+	retryable bool
+}
+
+func isServerFailure(err error) bool {
+	ce, ok := errors.Cause(err).(*callError)
+	return ok && ce.serverFailure
+}
+
+func (c *Client) evaluateWithRetry(ctx context.Context, body []byte) (*shield.ResultFromRequestHandler, error) {
+	backoff := time.Duration(c.config.getRetryBackoffMilliseconds()) * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= c.config.getMaxRetries(); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, errors.Wrap(lastErr, ctx.Err().Error())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		res, err := c.evaluate(ctx, body)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+		if !err.retryable {
+			break
+		}
+		log.Debugf("retrying a request to integrity shield server; %s", err.Error())
+	}
+	return nil, lastErr
+}
+
+// evaluate sends a request once. A connection error, a 5xx response and a 429 response are retryable.
+func (c *Client) evaluate(ctx context.Context, body []byte) (*shield.ResultFromRequestHandler, *callError) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.getTimeoutSeconds())*time.Second)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, &callError{error: errors.Wrap(err, "failed to create a request to integrity shield server")}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &callError{error: errors.Wrap(err, "failed to call integrity shield server"), serverFailure: true, retryable: true}
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &callError{error: errors.Wrap(err, "failed to read a response from integrity shield server"), serverFailure: true, retryable: true}
+	}
+	if resp.StatusCode != http.StatusOK {
+		serverFailure := resp.StatusCode >= 500
+		return nil, &callError{
+			error:         errors.New(fmt.Sprintf("integrity shield server returned status %d: %s", resp.StatusCode, string(respBody))),
+			serverFailure: serverFailure,
+			retryable:     serverFailure || resp.StatusCode == http.StatusTooManyRequests,
+		}
+	}
+	var res *shield.ResultFromRequestHandler
+	if err := json.Unmarshal(respBody, &res); err != nil || res == nil {
+		return nil, &callError{error: errors.New(fmt.Sprintf("failed to unmarshal a response from integrity shield server: %s", string(respBody)))}
+	}
+	return res, nil
+}
+
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+	if config.CAFile != "" {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read a CA certificate `%s`", config.CAFile))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New(fmt.Sprintf("no CA certificate is found in `%s`", config.CAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		// the key pair is loaded on each handshake so that a rotated certificate is used without restart
+		if _, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err != nil {
+			return nil, errors.Wrap(err, "failed to load a client certificate")
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			pair, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load a client certificate")
+			}
+			return &pair, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+func (c Config) getTimeoutSeconds() int {
+	if c.TimeoutSeconds <= 0 {
+		return defaultTimeoutSeconds
+	}
+	return c.TimeoutSeconds
+}
+
+func (c Config) getMaxRetries() int {
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	if c.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return c.MaxRetries
+}
+
+func (c Config) getRetryBackoffMilliseconds() int {
+	if c.RetryBackoffMilliseconds <= 0 {
+		return defaultRetryBackoffMilliseconds
+	}
+	return c.RetryBackoffMilliseconds
+}
+
+func (c Config) getMaxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost <= 0 {
+		return defaultMaxIdleConnsPerHost
+	}
+	return c.MaxIdleConnsPerHost
+}
+
+func (c Config) getFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return defaultFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+func (c Config) getOpenSeconds() int {
+	if c.OpenSeconds <= 0 {
+		return defaultOpenSeconds
+	}
+	return c.OpenSeconds
+}
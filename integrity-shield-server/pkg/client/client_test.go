@@ -0,0 +1,213 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// testServer responds with the statuses in order, and then with an allowed result.
+type testServer struct {
+	*httptest.Server
+	statuses []int
+	calls    int32
+	// last input received by the server
+	input EvaluateInput
+}
+
+func newTestServer(t *testing.T, statuses ...int) *testServer {
+	ts := &testServer{statuses: statuses}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&ts.calls, 1)) - 1
+		if i < len(ts.statuses) {
+			w.WriteHeader(ts.statuses[i])
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&ts.input)
+		_ = json.NewEncoder(w).Encode(shield.ResultFromRequestHandler{Allow: true, Message: "allowed"})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) callCount() int {
+	return int(atomic.LoadInt32(&ts.calls))
+}
+
+func newTestClient(t *testing.T, config Config) *Client {
+	if config.RetryBackoffMilliseconds == 0 {
+		config.RetryBackoffMilliseconds = 1
+	}
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEvaluate(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, Config{URL: ts.URL})
+	req := admission.Request{}
+	req.Name = "sample-cm"
+	res, err := c.Evaluate(context.Background(), req, &k8smnfconfig.ParameterObject{Action: "detect"}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !res.Allow {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if ts.input.Request == nil || ts.input.Request.Name != "sample-cm" || ts.input.Action != "detect" || !ts.input.DryRun {
+		t.Errorf("unexpected input: %+v", ts.input)
+	}
+}
+
+func TestEvaluateRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		wantErr    bool
+		wantCalls  int
+	}{
+		{name: "no error", wantCalls: 1},
+		{name: "5xx is retried", statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, wantCalls: 3},
+		{name: "429 is retried", statuses: []int{http.StatusTooManyRequests}, wantCalls: 2},
+		{name: "retries are exhausted", statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, wantErr: true, wantCalls: 3},
+		{name: "retry is disabled", statuses: []int{http.StatusBadGateway}, maxRetries: -1, wantErr: true, wantCalls: 1},
+		{name: "4xx is not retried", statuses: []int{http.StatusBadRequest}, wantErr: true, wantCalls: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, tc.statuses...)
+			c := newTestClient(t, Config{URL: ts.URL, MaxRetries: tc.maxRetries})
+			_, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error=%v, but got %v", tc.wantErr, err)
+			}
+			if ts.callCount() != tc.wantCalls {
+				t.Errorf("expected %d calls, but got %d", tc.wantCalls, ts.callCount())
+			}
+		})
+	}
+}
+
+func TestEvaluateCircuitBreaker(t *testing.T) {
+	const threshold = 2
+	openPeriod := 100 * time.Millisecond
+
+	// 4xx responses do not open the circuit
+	ts := newTestServer(t, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound)
+	c := newTestClient(t, Config{URL: ts.URL, MaxRetries: -1, FailureThreshold: threshold})
+	for i := 0; i < 3; i++ {
+		if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err == nil {
+			t.Fatal("expected an error of a 4xx response")
+		}
+	}
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err != nil {
+		t.Fatalf("circuit is opened by 4xx responses: %s", err.Error())
+	}
+
+	// 5xx responses open the circuit, and a successful probe closes it
+	ts = newTestServer(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	c = newTestClient(t, Config{URL: ts.URL, MaxRetries: -1, FailureThreshold: threshold})
+	c.breaker.openPeriod = openPeriod
+	for i := 0; i < threshold; i++ {
+		if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err == nil {
+			t.Fatal("expected an error of a 5xx response")
+		}
+	}
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); errors.Cause(err) != ErrCircuitOpen {
+		t.Fatalf("expected the circuit to be open, but got %v", err)
+	}
+	if ts.callCount() != threshold {
+		t.Errorf("a call is sent while the circuit is open: %d calls", ts.callCount())
+	}
+	time.Sleep(openPeriod)
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err != nil {
+		t.Fatalf("probe after the open period failed: %s", err.Error())
+	}
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err != nil {
+		t.Fatalf("circuit is not closed after a successful probe: %s", err.Error())
+	}
+
+	// connection errors open the circuit
+	ts = newTestServer(t)
+	ts.Close()
+	c = newTestClient(t, Config{URL: ts.URL, MaxRetries: -1, FailureThreshold: threshold})
+	for i := 0; i < threshold; i++ {
+		if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err == nil || errors.Cause(err) == ErrCircuitOpen {
+			t.Fatalf("expected a connection error, but got %v", err)
+		}
+	}
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); errors.Cause(err) != ErrCircuitOpen {
+		t.Fatalf("expected the circuit to be open, but got %v", err)
+	}
+}
+
+func TestEvaluateTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	// an attempt which exceeds the timeout is a failure of the server
+	c := newTestClient(t, Config{URL: ts.URL, TimeoutSeconds: 1, MaxRetries: -1, FailureThreshold: 1})
+	start := time.Now()
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("attempt is not cancelled by the timeout: %s", elapsed)
+	}
+	if _, err := c.Evaluate(context.Background(), admission.Request{}, &k8smnfconfig.ParameterObject{}, false); errors.Cause(err) != ErrCircuitOpen {
+		t.Errorf("expected the circuit to be open after a timeout, but got %v", err)
+	}
+
+	// a request cancelled by the caller is neither retried nor counted as a failure
+	atomic.StoreInt32(&calls, 0)
+	c = newTestClient(t, Config{URL: ts.URL, TimeoutSeconds: 10, FailureThreshold: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Evaluate(ctx, admission.Request{}, &k8smnfconfig.ParameterObject{}, false); err == nil {
+		t.Fatal("expected an error of the deadline of the caller")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 call, but got %d", n)
+	}
+	if !c.breaker.allow() {
+		t.Error("circuit is opened by a request cancelled by the caller")
+	}
+}
@@ -0,0 +1,53 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+const (
+	AuditSinkTypeFile        = "file"
+	AuditSinkTypeWebhook     = "webhook"
+	AuditSinkTypeCloudEvents = "cloudevents"
+)
+
+// AuditConfig defines where decision records are written.
+type AuditConfig struct {
+	Enabled bool              `json:"enabled,omitempty"`
+	Sinks   []AuditSinkConfig `json:"sinks,omitempty"`
+}
+
+type AuditSinkConfig struct {
+	Name string `json:"name,omitempty"`
+	// file, webhook or cloudevents
+	Type string `json:"type"`
+	// number of records buffered before new records are dropped
+	BufferSize int `json:"bufferSize,omitempty"`
+	// max number of records sent in one webhook request
+	BatchSize int `json:"batchSize,omitempty"`
+
+	// file sink
+	Path       string `json:"path,omitempty"`
+	MaxSizeMB  int    `json:"maxSizeMB,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty"`
+
+	// webhook and cloudevents sink
+	URL                string            `json:"url,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	CAFile             string            `json:"caFile,omitempty"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify,omitempty"`
+	TimeoutSeconds     int               `json:"timeoutSeconds,omitempty"`
+	// cloudevents sink
+	EventSource string `json:"eventSource,omitempty"`
+}
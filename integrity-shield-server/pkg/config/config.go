@@ -33,6 +33,22 @@ import (
 const defaultKeyInConfigMap = "config.yaml"
 const defaultConstraintConfigName = "constraint-config"
 
+// Failure policy applied when a request cannot be evaluated because of a timeout or a failed dependency
+const (
+	FailurePolicyOpen   = "fail-open"
+	FailurePolicyClosed = "fail-closed"
+)
+
+// Actions of a profile for a request which it would deny
+const (
+	// the request is denied
+	ActionEnforce = "enforce"
+	// the request is allowed with a warning to the user
+	ActionDetect = "detect"
+	// the request is allowed silently and only recorded in audit records, events and status
+	ActionAuditOnly = "audit-only"
+)
+
 // Constraint Config
 type ConstraintConfig struct {
 	Constraints []ActionConfig `json:"constraints,omitempty"`
@@ -53,7 +69,7 @@ type Rule struct {
 	Exclude []string `json:"exclude,omitempty"`
 }
 
-func LoadConstraintConfig() (ConstraintConfig, error) {
+func LoadConstraintConfig(ctx context.Context) (ConstraintConfig, error) {
 	var empty ConstraintConfig
 	namespace := os.Getenv("POD_NAMESPACE")
 	if namespace == "" {
@@ -77,7 +93,7 @@ func LoadConstraintConfig() (ConstraintConfig, error) {
 		log.Error(err)
 		return empty, err
 	}
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, configName, metav1.GetOptions{})
 	if err != nil {
 		return empty, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
 	}
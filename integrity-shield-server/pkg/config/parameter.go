@@ -23,28 +23,95 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+const (
+	ShieldAnnotationKeyDomain = "integrityshield.io"
+	CosignAnnotationKeyDomain = "cosign.sigstore.dev"
+)
+
+// Annotations which the admission controller stamps on admitted objects verified by a profile
+const (
+	VerifiedByAnnotationKey = ShieldAnnotationKeyDomain + "/verified-by"
+	SignerAnnotationKey     = ShieldAnnotationKeyDomain + "/signer"
+	ProfileAnnotationKey    = ShieldAnnotationKeyDomain + "/profile"
+	VerifiedAtAnnotationKey = ShieldAnnotationKeyDomain + "/verified-at"
+)
+
+// VerificationAnnotationKeys are the keys of annotations stamped on verified objects.
+var VerificationAnnotationKeys = []string{VerifiedByAnnotationKey, SignerAnnotationKey, ProfileAnnotationKey, VerifiedAtAnnotationKey}
+
+// VerificationAnnotationIgnoreFields excludes stamped annotations of all objects from signature comparison and mutation check,
+// because they are added after the manifest is signed.
+func VerificationAnnotationIgnoreFields() k8smanifest.ObjectFieldBinding {
+	fields := []string{}
+	for _, key := range VerificationAnnotationKeys {
+		fields = append(fields, "metadata.annotations."+key)
+	}
+	return k8smanifest.ObjectFieldBinding{
+		Fields:  fields,
+		Objects: k8smanifest.ObjectReferenceList{{Name: "*"}},
+	}
+}
+
+// annotation domains tried when `annotationDomains` is not specified
+var defaultAnnotationDomains = []string{ShieldAnnotationKeyDomain, CosignAnnotationKeyDomain}
+
+// a domain is matched if any of these annotations `<domain>/<name>` exists
+var signatureAnnotationBaseNames = []string{"signature", "message", "bundle", "imageRef"}
+
 type ParameterObject struct {
 	ConstraintName                   string                          `json:"constraintName,omitempty"`
 	SignatureRef                     SignatureRef                    `json:"signatureRef,omitempty"`
+	AnnotationDomains                []string                        `json:"annotationDomains,omitempty"`
+	ProvenanceRequirements           *ProvenanceRequirements         `json:"provenanceRequirements,omitempty"`
+	FailurePolicy                    string                          `json:"failurePolicy,omitempty"`
 	KeyConfigs                       []KeyConfig                     `json:"keyConfigs,omitempty"`
 	InScopeObjects                   k8smanifest.ObjectReferenceList `json:"inScopeObjects,omitempty"`
 	SkipUsers                        ObjectUserBindingList           `json:"skipUsers,omitempty"`
 	TargetServiceAccount             []string                        `json:"targetServiceAccount,omitempty"`
 	ImageProfile                     ImageProfile                    `json:"imageProfile,omitempty"`
+	Action                           string                          `json:"-"`
 	k8smanifest.VerifyResourceOption `json:""`
 }
 
+// SignatureRef points to signature stores. All fields accept Go-template placeholders
+// which are expanded with the verified resource, e.g. `{{.Namespace}}` and `{{.Name}}`.
 type SignatureRef struct {
+	// OCI image which contains the signed manifest
+	ImageRef              string      `json:"imageRef,omitempty"`
+	SignatureResourceRef  ResourceRef `json:"signatureResourceRef,omitempty"`
+	ProvenanceResourceRef ResourceRef `json:"provenanceResourceRef,omitempty"`
+	// Refs are tried in order after the reference above until one of them verifies the resource
+	Refs []SignatureSource `json:"refs,omitempty"`
+}
+
+type SignatureSource struct {
 	ImageRef              string      `json:"imageRef,omitempty"`
 	SignatureResourceRef  ResourceRef `json:"signatureResourceRef,omitempty"`
 	ProvenanceResourceRef ResourceRef `json:"provenanceResourceRef,omitempty"`
 }
 
 type ResourceRef struct {
+	// ConfigMap (default) or Secret
+	Kind      string `json:"kind,omitempty"`
 	Name      string `json:"name,omitempty"`
 	Namespace string `json:"namespace,omitempty"`
 }
 
+// ProvenanceRequirements are rules on SLSA provenance predicates of a resource.
+// Patterns accept a wildcard `*`. A resource is allowed if at least one attestation satisfies all rules.
+type ProvenanceRequirements struct {
+	BuilderIDs         []string            `json:"builderIDs,omitempty"`
+	SourceRepositories []string            `json:"sourceRepositories,omitempty"`
+	BuildTypes         []string            `json:"buildTypes,omitempty"`
+	Materials          []MaterialReference `json:"materials,omitempty"`
+}
+
+// MaterialReference requires a material whose URI matches the pattern and whose digests contain all given digests.
+type MaterialReference struct {
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
 type KeyConfig struct {
 	KeySecretName      string `json:"keySecretName,omitempty"`
 	KeySecretNamespace string `json:"keySecretNamespace,omitempty"`
@@ -60,10 +127,53 @@ type ObjectUserBinding struct {
 type ImageProfile struct {
 }
 
+// GetFailurePolicy returns the policy applied when the profile cannot be evaluated.
+// Without a policy of its own, a profile follows the admission controller, which is fail-open by default.
+func (p *ParameterObject) GetFailurePolicy() string {
+	if p.FailurePolicy == FailurePolicyClosed {
+		return FailurePolicyClosed
+	}
+	return FailurePolicyOpen
+}
+
+// GetAction returns the action of the profile. Without an action of its own, a profile is enforced
+// if constraint-config enforces it and detected otherwise.
+func (p *ParameterObject) GetAction(enforcedByConstraintConfig bool) string {
+	switch p.Action {
+	case ActionEnforce, ActionDetect, ActionAuditOnly:
+		return p.Action
+	}
+	if enforcedByConstraintConfig {
+		return ActionEnforce
+	}
+	return ActionDetect
+}
+
 func (p *ParameterObject) DeepCopyInto(p2 *ParameterObject) {
 	copier.Copy(&p2, &p)
 }
 
+// MatchAnnotationDomain returns the first domain in `annotationDomains` for which the resource has
+// signature annotations. If none matches, the first configured domain is returned with matched=false,
+// or an empty string if no domain is configured so that the default domain of k8s-manifest-sigstore is used.
+func (p *ParameterObject) MatchAnnotationDomain(annotations map[string]string) (string, bool) {
+	domains := p.AnnotationDomains
+	if len(domains) == 0 {
+		domains = defaultAnnotationDomains
+	}
+	for _, domain := range domains {
+		for _, name := range signatureAnnotationBaseNames {
+			if _, found := annotations[domain+"/"+name]; found {
+				return domain, true
+			}
+		}
+	}
+	if len(p.AnnotationDomains) != 0 {
+		return p.AnnotationDomains[0], false
+	}
+	return "", false
+}
+
 func (u ObjectUserBinding) Match(obj unstructured.Unstructured, username string) bool {
 	if u.Objects.Match(obj) {
 		if k8smnfutil.MatchWithPatternArray(username, u.Users) {
@@ -17,6 +17,7 @@
 package config
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -24,6 +25,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
@@ -59,6 +61,7 @@ type RequestHandlerConfig struct {
 	RequestFilterProfile    RequestFilterProfile    `json:"requestFilterProfile,omitempty"`
 	Log                     LogConfig               `json:"log,omitempty"`
 	SideEffectConfig        SideEffectConfig        `json:"sideEffect,omitempty"`
+	Audit                   AuditConfig             `json:"audit,omitempty"`
 	Options                 []string
 }
 
@@ -71,6 +74,9 @@ type LogConfig struct {
 type SideEffectConfig struct {
 	// Event
 	CreateDenyEvent bool `json:"createDenyEvent"`
+	// rate limit of deny events per involved object
+	DenyEventQPS   float32 `json:"denyEventQPS,omitempty"`
+	DenyEventBurst int     `json:"denyEventBurst,omitempty"`
 }
 
 type ImageVerificationConfig struct {
@@ -110,21 +116,36 @@ func SetupLogger(config LogConfig, req admission.Request) {
 	}
 }
 
-func LoadKeySecret(keySecretNamespace, keySecretName string) (string, error) {
+// keyDirRoot is the directory in which keys in secrets are saved as files
+var keyDirRoot = "/tmp"
+
+// getKeySecretData returns the data of a key secret; it is replaced in tests
+var getKeySecretData = func(keySecretNamespace, keySecretName string) (map[string][]byte, error) {
 	obj, err := kubeutil.GetResource("v1", "Secret", keySecretNamespace, keySecretName)
 	if err != nil {
-		return "", errors.Wrap(err, fmt.Sprintf("failed to get a secret `%s` in `%s` namespace", keySecretName, keySecretNamespace))
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a secret `%s` in `%s` namespace", keySecretName, keySecretNamespace))
 	}
 	objBytes, _ := json.Marshal(obj.Object)
 	var secret v1.Secret
 	_ = json.Unmarshal(objBytes, &secret)
-	keyDir := fmt.Sprintf("/tmp/%s/%s/", keySecretNamespace, keySecretName)
+	return secret.Data, nil
+}
+
+// keyFileLocks serializes writes to the same key file; profiles sharing a key are evaluated concurrently
+var keyFileLocks sync.Map
+
+func LoadKeySecret(keySecretNamespace, keySecretName string) (string, error) {
+	data, err := getKeySecretData(keySecretNamespace, keySecretName)
+	if err != nil {
+		return "", err
+	}
+	keyDir := filepath.Join(keyDirRoot, keySecretNamespace, keySecretName)
 	sumErr := []string{}
 	keyPath := ""
-	for fname, keyData := range secret.Data {
+	for fname, keyData := range data {
 		os.MkdirAll(keyDir, os.ModePerm)
 		fpath := filepath.Join(keyDir, fname)
-		err := ioutil.WriteFile(fpath, keyData, 0644)
+		err := writeKeyFile(fpath, keyData)
 		if err != nil {
 			sumErr = append(sumErr, err.Error())
 			continue
@@ -142,7 +163,36 @@ func LoadKeySecret(keySecretNamespace, keySecretName string) (string, error) {
 	return keyPath, nil
 }
 
-func LoadRequestHandlerConfig() (*RequestHandlerConfig, error) {
+// writeKeyFile replaces a key file by renaming a temporary file, so that a profile verifying
+// with the key never reads a partially written file. The file is kept if the key is not changed.
+func writeKeyFile(fpath string, data []byte) error {
+	lock, _ := keyFileLocks.LoadOrStore(fpath, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current, err := ioutil.ReadFile(fpath); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fpath), filepath.Base(fpath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fpath)
+}
+
+func LoadRequestHandlerConfig(ctx context.Context) (*RequestHandlerConfig, error) {
 	namespace := os.Getenv("POD_NAMESPACE")
 	if namespace == "" {
 		namespace = defaultPodNamespace
@@ -159,14 +209,14 @@ func LoadRequestHandlerConfig() (*RequestHandlerConfig, error) {
 	// load
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 	clientset, err := kubeclient.NewForConfig(config)
 	if err != nil {
 		log.Error(err)
-		return nil, nil
+		return nil, err
 	}
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, configName, metav1.GetOptions{})
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
 	}
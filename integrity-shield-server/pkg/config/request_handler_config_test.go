@@ -0,0 +1,82 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestLoadKeySecretSharedByProfiles evaluates two profiles which share a key secret concurrently,
+// while the key is rotated, and checks that the key file is never read partially written.
+// Run with -race.
+func TestLoadKeySecretSharedByProfiles(t *testing.T) {
+	oldRoot, oldGetter := keyDirRoot, getKeySecretData
+	defer func() { keyDirRoot, getKeySecretData = oldRoot, oldGetter }()
+	keyDirRoot = t.TempDir()
+
+	keys := [][]byte{
+		bytes.Repeat([]byte("a"), 64*1024),
+		bytes.Repeat([]byte("b"), 128*1024),
+	}
+	var rotation int32
+	getKeySecretData = func(keySecretNamespace, keySecretName string) (map[string][]byte, error) {
+		i := atomic.AddInt32(&rotation, 1)
+		return map[string][]byte{"cosign.pub": keys[int(i)%len(keys)]}, nil
+	}
+
+	keyConfig := KeyConfig{KeySecretName: "keyring-secret", KeySecretNamespace: "integrity-shield-operator-system"}
+	profiles := []ParameterObject{
+		{ConstraintName: "profile-a", KeyConfigs: []KeyConfig{keyConfig}},
+		{ConstraintName: "profile-b", KeyConfigs: []KeyConfig{keyConfig}},
+	}
+
+	const iterations = 50
+	var wg sync.WaitGroup
+	errCh := make(chan string, len(profiles)*iterations)
+	for _, p := range profiles {
+		wg.Add(1)
+		go func(p ParameterObject) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				for _, kc := range p.KeyConfigs {
+					keyPath, err := LoadKeySecret(kc.KeySecretNamespace, kc.KeySecretName)
+					if err != nil {
+						errCh <- err.Error()
+						continue
+					}
+					data, err := ioutil.ReadFile(keyPath)
+					if err != nil {
+						errCh <- err.Error()
+						continue
+					}
+					if !bytes.Equal(data, keys[0]) && !bytes.Equal(data, keys[1]) {
+						errCh <- "profile `" + p.ConstraintName + "` read a partially written key"
+					}
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errCh)
+	for msg := range errCh {
+		t.Error(msg)
+	}
+}
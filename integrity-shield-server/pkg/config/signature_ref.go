@@ -0,0 +1,153 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	ResourceRefKindConfigMap = "ConfigMap"
+	ResourceRefKindSecret    = "Secret"
+)
+
+// ResolvedSignatureRef is a signature reference in the format passed to k8s-manifest-sigstore.
+type ResolvedSignatureRef struct {
+	ImageRef              string
+	SignatureResourceRef  string
+	ProvenanceResourceRef string
+}
+
+// fields available in signature ref templates
+type signatureRefTemplateData struct {
+	Namespace  string
+	Name       string
+	Kind       string
+	APIVersion string
+}
+
+// Resolve expands template placeholders with the given resource and returns candidate
+// references in the order they should be tried. An empty candidate is returned if no
+// reference is configured, so that signatures in annotations are used.
+func (r SignatureRef) Resolve(obj unstructured.Unstructured) ([]ResolvedSignatureRef, error) {
+	return r.ResolveWithName(obj, obj.GetNamespace(), obj.GetName())
+}
+
+// ResolveWithName is Resolve with the namespace and name of an admission request, which the object omits
+// on CREATE with generateName or when the namespace is given only in the request.
+// The namespace and name of the object are used if they are empty.
+func (r SignatureRef) ResolveWithName(obj unstructured.Unstructured, namespace, name string) ([]ResolvedSignatureRef, error) {
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+	if name == "" {
+		name = obj.GetName()
+	}
+	data := signatureRefTemplateData{
+		Namespace:  namespace,
+		Name:       name,
+		Kind:       obj.GetKind(),
+		APIVersion: obj.GetAPIVersion(),
+	}
+	sources := []SignatureSource{
+		{
+			ImageRef:              r.ImageRef,
+			SignatureResourceRef:  r.SignatureResourceRef,
+			ProvenanceResourceRef: r.ProvenanceResourceRef,
+		},
+	}
+	sources = append(sources, r.Refs...)
+
+	candidates := []ResolvedSignatureRef{}
+	for i, src := range sources {
+		resolved, err := src.resolve(data)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to resolve signatureRef[%d]", i))
+		}
+		if resolved == (ResolvedSignatureRef{}) {
+			continue
+		}
+		candidates = append(candidates, resolved)
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, ResolvedSignatureRef{})
+	}
+	return candidates, nil
+}
+
+func (s SignatureSource) resolve(data signatureRefTemplateData) (ResolvedSignatureRef, error) {
+	var resolved ResolvedSignatureRef
+	var err error
+	resolved.ImageRef, err = renderRefTemplate(s.ImageRef, data)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.SignatureResourceRef, err = s.SignatureResourceRef.resolve(data)
+	if err != nil {
+		return resolved, err
+	}
+	resolved.ProvenanceResourceRef, err = s.ProvenanceResourceRef.resolve(data)
+	if err != nil {
+		return resolved, err
+	}
+	return resolved, nil
+}
+
+// resolve returns a reference like `k8s://ConfigMap/<namespace>/<name>`, or an empty string
+// if name or namespace is empty after the placeholders are expanded.
+func (r ResourceRef) resolve(data signatureRefTemplateData) (string, error) {
+	name, err := renderRefTemplate(r.Name, data)
+	if err != nil {
+		return "", err
+	}
+	namespace, err := renderRefTemplate(r.Namespace, data)
+	if err != nil {
+		return "", err
+	}
+	if name == "" || namespace == "" {
+		return "", nil
+	}
+	kind := r.Kind
+	if kind == "" {
+		kind = ResourceRefKindConfigMap
+	}
+	if kind != ResourceRefKindConfigMap && kind != ResourceRefKindSecret {
+		return "", fmt.Errorf("unsupported kind `%s` in resource ref; only %s and %s are supported", kind, ResourceRefKindConfigMap, ResourceRefKindSecret)
+	}
+	return fmt.Sprintf("k8s://%s/%s/%s", kind, namespace, name), nil
+}
+
+func renderRefTemplate(text string, data signatureRefTemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("signatureRef").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to parse template `%s`", text))
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("failed to execute template `%s`", text))
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
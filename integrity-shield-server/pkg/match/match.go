@@ -0,0 +1,239 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// operation of a delete request, which is matched only if a condition lists it
+const deleteOperation = "DELETE"
+
+// Condition is the match condition of a profile or a constraint, which decides resources in its scope.
+// The admission controller and the observer use the same condition so that they agree on the scope.
+type Condition struct {
+	Kinds              []Kinds
+	Namespaces         []string
+	ExcludedNamespaces []string
+	LabelSelector      *metav1.LabelSelector
+	NamespaceSelector  *metav1.LabelSelector
+	Operations         []string
+	Users              []string
+	Groups             []string
+	Names              []string
+	AnnotationSelector *metav1.LabelSelector
+}
+
+type Kinds struct {
+	Kinds     []string
+	ApiGroups []string
+}
+
+// Target is a resource to be matched.
+type Target struct {
+	// attributes of the admission request; they are not checked if Operation is empty,
+	// e.g. for a resource which the observer verifies in the cluster
+	Operation string
+	Username  string
+	Groups    []string
+
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+	Object    *unstructured.Unstructured
+	// NamespaceLabels returns labels of the namespace of the resource.
+	// It is called only if the condition has a namespace selector.
+	NamespaceLabels func() (map[string]string, error)
+}
+
+// Match checks a resource with a condition.
+// When the resource does not match, the reason explains which condition did not match, so that
+// it reads as "not matched because <reason>".
+// An error is returned only when labels of the namespace are required and cannot be fetched.
+func Match(c Condition, t Target) (bool, string, error) {
+	// check operation and requesting user
+	if t.Operation != "" {
+		if !MatchOperation(c.Operations, t.Operation) {
+			return false, fmt.Sprintf("operation `%s` is not in match.operations", t.Operation), nil
+		}
+		if !MatchUser(c.Users, c.Groups, t.Username, t.Groups) {
+			return false, fmt.Sprintf("neither user `%s` nor its groups are in match.users or match.groups", t.Username), nil
+		}
+	}
+	// check if excludedNamespace
+	for _, ens := range c.ExcludedNamespaces {
+		if k8smnfutil.MatchPattern(ens, t.Namespace) {
+			return false, fmt.Sprintf("namespace `%s` is in match.excludedNamespaces", t.Namespace), nil
+		}
+	}
+	// check if matched kinds/namespace/label
+	if !MatchNamespace(c.Namespaces, t.Namespace) {
+		return false, fmt.Sprintf("namespace `%s` is not in match.namespaces", t.Namespace), nil
+	}
+	if !MatchKinds(c.Kinds, t.Group, t.Kind) {
+		return false, fmt.Sprintf("kind `%s` is not in match.kinds", t.Kind), nil
+	}
+	if !MatchName(c.Names, t.Name) {
+		return false, fmt.Sprintf("name `%s` is not in match.names", t.Name), nil
+	}
+	var objLabels, objAnnotations map[string]string
+	if t.Object != nil {
+		objLabels = t.Object.GetLabels()
+		objAnnotations = t.Object.GetAnnotations()
+	}
+	if !matchSelector(c.LabelSelector, objLabels) {
+		return false, "labels do not satisfy match.labelSelector", nil
+	}
+	if !matchSelector(c.AnnotationSelector, objAnnotations) {
+		return false, "annotations do not satisfy match.annotationSelector", nil
+	}
+	nslabelMatched, err := matchNamespaceSelector(c.NamespaceSelector, t)
+	if err != nil {
+		return false, "", err
+	}
+	if !nslabelMatched {
+		return false, "namespace labels do not satisfy match.namespaceSelector", nil
+	}
+	return true, "", nil
+}
+
+// MatchOperation matches if the operation matches any of operations. DELETE is matched only if it is listed
+// explicitly, so that profiles which do not specify operations or use a wildcard never verify deleted objects.
+func MatchOperation(operations []string, operation string) bool {
+	if operation == deleteOperation {
+		for _, op := range operations {
+			if strings.ToUpper(op) == deleteOperation {
+				return true
+			}
+		}
+		return false
+	}
+	if len(operations) == 0 {
+		return true
+	}
+	for _, op := range operations {
+		if k8smnfutil.MatchPattern(strings.ToUpper(op), operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchUser matches if the user name matches any of users or any group of the user matches any of groups.
+func MatchUser(users, groups []string, username string, userGroups []string) bool {
+	if len(users) == 0 && len(groups) == 0 {
+		return true
+	}
+	for _, u := range users {
+		if k8smnfutil.MatchPattern(u, username) {
+			return true
+		}
+	}
+	for _, g := range groups {
+		for _, userGroup := range userGroups {
+			if k8smnfutil.MatchPattern(g, userGroup) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchNamespace matches if namespaces is empty, the resource is cluster-scoped, or the namespace matches any of namespaces.
+func MatchNamespace(namespaces []string, namespace string) bool {
+	if len(namespaces) == 0 || namespace == "" {
+		return true
+	}
+	for _, ns := range namespaces {
+		if k8smnfutil.MatchPattern(ns, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchKinds matches if kinds is empty, or both the kind and the API group match any entry of kinds.
+// An entry without kinds or apiGroups matches any kind or any group.
+func MatchKinds(kinds []Kinds, group, kind string) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if matchAny(k.Kinds, kind) && matchAny(k.ApiGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func MatchName(names []string, name string) bool {
+	return matchAny(names, name)
+}
+
+// matchAny matches if patterns is empty or the value matches any of patterns.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if k8smnfutil.MatchPattern(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSelector(selector *metav1.LabelSelector, labelsMap map[string]string) bool {
+	if selector == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
+		return false
+	}
+	return s.Matches(labels.Set(labelsMap))
+}
+
+func matchNamespaceSelector(selector *metav1.LabelSelector, t Target) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	// cluster scope resource never matches with namespace selector
+	if t.Namespace == "" || t.NamespaceLabels == nil {
+		return false, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
+		return false, nil
+	}
+	labelsMap, err := t.NamespaceLabels()
+	if err != nil {
+		log.Errorf("failed to get labels of a namespace `%s`:`%s`", t.Namespace, err.Error())
+		return false, err
+	}
+	return s.Matches(labels.Set(labelsMap)), nil
+}
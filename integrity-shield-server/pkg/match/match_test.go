@@ -0,0 +1,186 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package match
+
+import (
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testObject(namespace, name string, labels, annotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestMatch(t *testing.T) {
+	namespaceLabels := func() (map[string]string, error) {
+		return map[string]string{"env": "prod"}, nil
+	}
+	configMap := Target{
+		Kind:            "ConfigMap",
+		Namespace:       "sample-ns",
+		Name:            "sample-cm",
+		Object:          testObject("sample-ns", "sample-cm", map[string]string{"app": "sample"}, map[string]string{"owner": "team-a"}),
+		NamespaceLabels: namespaceLabels,
+	}
+	clusterRole := Target{
+		Group:           "rbac.authorization.k8s.io",
+		Kind:            "ClusterRole",
+		Name:            "sample-role",
+		Object:          testObject("", "sample-role", nil, nil),
+		NamespaceLabels: namespaceLabels,
+	}
+	withRequest := func(t Target, operation, username string, groups ...string) Target {
+		t.Operation = operation
+		t.Username = username
+		t.Groups = groups
+		return t
+	}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		target    Target
+		matched   bool
+		wantErr   bool
+	}{
+		{name: "empty condition", condition: Condition{}, target: configMap, matched: true},
+		{name: "empty condition for cluster scope", condition: Condition{}, target: clusterRole, matched: true},
+
+		// namespaces
+		{name: "namespace", condition: Condition{Namespaces: []string{"sample-ns"}}, target: configMap, matched: true},
+		{name: "namespace pattern", condition: Condition{Namespaces: []string{"sample-*"}}, target: configMap, matched: true},
+		{name: "namespace not listed", condition: Condition{Namespaces: []string{"other-ns"}}, target: configMap, matched: false},
+		{name: "namespaces for cluster scope", condition: Condition{Namespaces: []string{"other-ns"}}, target: clusterRole, matched: true},
+		{name: "excluded namespace", condition: Condition{ExcludedNamespaces: []string{"sample-ns"}}, target: configMap, matched: false},
+		{name: "excluded namespace pattern", condition: Condition{ExcludedNamespaces: []string{"kube-*", "sample-*"}}, target: configMap, matched: false},
+		{name: "excluded namespace over namespaces", condition: Condition{Namespaces: []string{"sample-*"}, ExcludedNamespaces: []string{"sample-ns"}}, target: configMap, matched: false},
+		{name: "other excluded namespace", condition: Condition{ExcludedNamespaces: []string{"kube-*"}}, target: configMap, matched: true},
+		{name: "excluded namespaces for cluster scope", condition: Condition{ExcludedNamespaces: []string{"kube-*"}}, target: clusterRole, matched: true},
+
+		// kinds
+		{name: "kind", condition: Condition{Kinds: []Kinds{{Kinds: []string{"ConfigMap"}}}}, target: configMap, matched: true},
+		{name: "kind not listed", condition: Condition{Kinds: []Kinds{{Kinds: []string{"Secret"}}}}, target: configMap, matched: false},
+		{name: "kind and group", condition: Condition{Kinds: []Kinds{{Kinds: []string{"ClusterRole"}, ApiGroups: []string{"rbac.authorization.k8s.io"}}}}, target: clusterRole, matched: true},
+		{name: "kind in another group", condition: Condition{Kinds: []Kinds{{Kinds: []string{"ClusterRole"}, ApiGroups: []string{"apps"}}}}, target: clusterRole, matched: false},
+		{name: "group without kinds", condition: Condition{Kinds: []Kinds{{ApiGroups: []string{"rbac.authorization.k8s.io"}}}}, target: clusterRole, matched: true},
+
+		// names
+		{name: "name", condition: Condition{Names: []string{"sample-cm"}}, target: configMap, matched: true},
+		{name: "name pattern", condition: Condition{Names: []string{"other-*", "sample-*"}}, target: configMap, matched: true},
+		{name: "name not matched", condition: Condition{Names: []string{"other-*"}}, target: configMap, matched: false},
+		{name: "name pattern for cluster scope", condition: Condition{Names: []string{"*-role"}}, target: clusterRole, matched: true},
+
+		// label and annotation selectors
+		{name: "label selector", condition: Condition{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "sample"}}}, target: configMap, matched: true},
+		{name: "label selector not satisfied", condition: Condition{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}}}, target: configMap, matched: false},
+		{
+			name: "label selector with expressions",
+			condition: Condition{LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "skip", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}}},
+			target:  clusterRole,
+			matched: true,
+		},
+		{name: "label selector without object", condition: Condition{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "sample"}}}, target: Target{Kind: "ConfigMap", Namespace: "sample-ns"}, matched: false},
+		{name: "annotation selector", condition: Condition{AnnotationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"owner": "team-a"}}}, target: configMap, matched: true},
+		{
+			name: "annotation selector not satisfied",
+			condition: Condition{AnnotationSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "owner", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"team-a"}},
+			}}},
+			target:  configMap,
+			matched: false,
+		},
+		{
+			name:      "invalid selector",
+			condition: Condition{LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown"}}}},
+			target:    configMap,
+			matched:   false,
+		},
+
+		// namespace selector
+		{name: "namespace selector", condition: Condition{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}}, target: configMap, matched: true},
+		{name: "namespace selector not satisfied", condition: Condition{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}}}, target: configMap, matched: false},
+		{name: "namespace selector for cluster scope", condition: Condition{NamespaceSelector: &metav1.LabelSelector{}}, target: clusterRole, matched: false},
+		{
+			name:      "namespace labels not available",
+			condition: Condition{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+			target: func() Target {
+				t := configMap
+				t.NamespaceLabels = func() (map[string]string, error) { return nil, errors.New("namespace is not found") }
+				return t
+			}(),
+			matched: false,
+			wantErr: true,
+		},
+
+		// operations and users are checked only for admission requests
+		{name: "operation not checked without request", condition: Condition{Operations: []string{"UPDATE"}}, target: configMap, matched: true},
+		{name: "users not checked without request", condition: Condition{Users: []string{"system:admin"}, Groups: []string{"system:masters"}}, target: configMap, matched: true},
+		{name: "operation", condition: Condition{Operations: []string{"CREATE", "UPDATE"}}, target: withRequest(configMap, "UPDATE", "sample-user"), matched: true},
+		{name: "operation in lower case", condition: Condition{Operations: []string{"update"}}, target: withRequest(configMap, "UPDATE", "sample-user"), matched: true},
+		{name: "operation not listed", condition: Condition{Operations: []string{"CREATE"}}, target: withRequest(configMap, "UPDATE", "sample-user"), matched: false},
+		{name: "delete", condition: Condition{Operations: []string{"CREATE", "delete"}}, target: withRequest(configMap, "DELETE", "sample-user"), matched: true},
+		{name: "delete without operations", condition: Condition{}, target: withRequest(configMap, "DELETE", "sample-user"), matched: false},
+		{name: "delete with wildcard", condition: Condition{Operations: []string{"*"}}, target: withRequest(configMap, "DELETE", "sample-user"), matched: false},
+		{name: "user", condition: Condition{Users: []string{"system:serviceaccount:sample-ns:*"}}, target: withRequest(configMap, "CREATE", "system:serviceaccount:sample-ns:deployer"), matched: true},
+		{name: "group of user", condition: Condition{Users: []string{"system:admin"}, Groups: []string{"system:masters"}}, target: withRequest(configMap, "CREATE", "sample-user", "system:authenticated", "system:masters"), matched: true},
+		{name: "user not listed", condition: Condition{Users: []string{"system:admin"}, Groups: []string{"system:masters"}}, target: withRequest(configMap, "CREATE", "sample-user", "system:authenticated"), matched: false},
+
+		// all conditions
+		{
+			name: "all conditions",
+			condition: Condition{
+				Kinds:              []Kinds{{Kinds: []string{"ConfigMap"}, ApiGroups: []string{""}}},
+				Namespaces:         []string{"sample-*"},
+				ExcludedNamespaces: []string{"kube-*"},
+				LabelSelector:      &metav1.LabelSelector{MatchLabels: map[string]string{"app": "sample"}},
+				NamespaceSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				Operations:         []string{"CREATE"},
+				Users:              []string{"sample-user"},
+				Names:              []string{"sample-*"},
+				AnnotationSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"owner": "team-a"}},
+			},
+			target:  withRequest(configMap, "CREATE", "sample-user"),
+			matched: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			matched, reason, err := Match(tc.condition, tc.target)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error=%v, but got %v", tc.wantErr, err)
+			}
+			if matched != tc.matched {
+				t.Errorf("expected matched=%v, but got %v (%s)", tc.matched, matched, reason)
+			}
+			if !matched && err == nil && reason == "" {
+				t.Error("no reason is returned for a resource which is not matched")
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+	admv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kubeclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// fields which are not regarded as mutation
+var mutationCheckMask = []string{
+	"metadata.annotations.namespace",
+	"metadata.annotations.kubectl.\"kubernetes.io/last-applied-configuration\"",
+	"metadata.annotations.deprecated.daemonset.template.generation",
+	"metadata.creationTimestamp",
+	"metadata.uid",
+	"metadata.generation",
+	"metadata.managedFields",
+	"metadata.selfLink",
+	"metadata.resourceVersion",
+	"status",
+}
+
+// EvaluationContext holds attributes of an admission request which are decoded once per request
+// and shared by all profiles. Profiles may be evaluated concurrently, so they must not modify it.
+type EvaluationContext struct {
+	Request   admission.Request
+	Object    *unstructured.Unstructured
+	OldObject *unstructured.Unstructured
+	UserInfo  authenticationv1.UserInfo
+	// DryRun disables side effects of the evaluation such as events and audit records
+	DryRun bool
+	// NamespaceLister is used to get the namespace from an informer cache instead of the API server if set
+	NamespaceLister corelisters.NamespaceLister
+
+	err error
+
+	diffOnce sync.Once
+	diff     *mapnode.DiffResult
+	diffErr  error
+
+	nsOnce          sync.Once
+	namespaceLabels map[string]string
+	nsErr           error
+}
+
+// NewEvaluationContext decodes the object and the old object of the request.
+// A delete request has no requested object, so the object being deleted is evaluated as the object.
+// A decode error is kept in the context and returned by Err().
+func NewEvaluationContext(req admission.Request) *EvaluationContext {
+	ec := &EvaluationContext{
+		Request:  req,
+		UserInfo: req.AdmissionRequest.UserInfo,
+	}
+	raw := req.AdmissionRequest.Object.Raw
+	if req.AdmissionRequest.Operation == admv1.Delete {
+		raw = req.AdmissionRequest.OldObject.Raw
+	}
+	var obj unstructured.Unstructured
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		ec.err = errors.Wrap(err, fmt.Sprintf("failed to Unmarshal a requested object into %T", obj))
+		return ec
+	}
+	ec.Object = &obj
+	if isUpdateRequest(req.AdmissionRequest.Operation) && len(req.AdmissionRequest.OldObject.Raw) > 0 {
+		var oldObj unstructured.Unstructured
+		if err := json.Unmarshal(req.AdmissionRequest.OldObject.Raw, &oldObj); err != nil {
+			ec.err = errors.Wrap(err, fmt.Sprintf("failed to Unmarshal an old object into %T", oldObj))
+			return ec
+		}
+		ec.OldObject = &oldObj
+	}
+	return ec
+}
+
+// Err returns the error which occurred while decoding the request.
+func (ec *EvaluationContext) Err() error {
+	return ec.err
+}
+
+// Mutated reports whether the object is changed from the old object except ignoreFields.
+// The masked diff is computed only once and filtered for each profile.
+func (ec *EvaluationContext) Mutated(ignoreFields []string) (bool, error) {
+	ec.diffOnce.Do(func() {
+		ec.diff, ec.diffErr = ec.computeDiff()
+	})
+	if ec.diffErr != nil {
+		return false, ec.diffErr
+	}
+	dr := ec.diff
+	if dr == nil || dr.Size() == 0 {
+		return false, nil
+	}
+	// ignoreField check
+	_, unfiltered, _ := dr.Filter(ignoreFields)
+	if unfiltered == nil || unfiltered.Size() == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (ec *EvaluationContext) computeDiff() (*mapnode.DiffResult, error) {
+	if ec.Object == nil || ec.OldObject == nil {
+		return nil, nil
+	}
+	// nodes are built from copies so that masking never touches the shared objects
+	newNode, err := mapnode.NewFromMap(ec.Object.DeepCopy().Object)
+	if err != nil || newNode == nil {
+		return nil, err
+	}
+	oldNode, err := mapnode.NewFromMap(ec.OldObject.DeepCopy().Object)
+	if err != nil || oldNode == nil {
+		return nil, err
+	}
+	return oldNode.Mask(mutationCheckMask).Diff(newNode.Mask(mutationCheckMask)), nil
+}
+
+// NamespaceLabels returns labels of the namespace of the request. The namespace is fetched
+// at most once per request, and only if a profile needs it.
+func (ec *EvaluationContext) NamespaceLabels(ctx context.Context) (map[string]string, error) {
+	ec.nsOnce.Do(func() {
+		namespace := ec.Request.Namespace
+		if namespace == "" {
+			return
+		}
+		if ec.NamespaceLister != nil {
+			ns, err := ec.NamespaceLister.Get(namespace)
+			if err == nil {
+				ec.namespaceLabels = ns.GetLabels()
+				return
+			}
+			// a namespace created just before the request may not be in the cache yet
+			if !k8serrors.IsNotFound(err) {
+				ec.nsErr = errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s` from cache", namespace))
+				return
+			}
+		}
+		client, err := getKubeClient()
+		if err != nil {
+			ec.nsErr = err
+			return
+		}
+		ns, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			ec.nsErr = errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s`", namespace))
+			return
+		}
+		ec.namespaceLabels = ns.GetLabels()
+	})
+	return ec.namespaceLabels, ec.nsErr
+}
+
+var (
+	kubeClient     kubeclient.Interface
+	kubeClientErr  error
+	kubeClientOnce sync.Once
+)
+
+// getKubeClient returns a clientset shared by all requests.
+func getKubeClient() (kubeclient.Interface, error) {
+	kubeClientOnce.Do(func() {
+		config, err := kubeutil.GetKubeConfig()
+		if err != nil {
+			kubeClientErr = err
+			return
+		}
+		kubeClient, kubeClientErr = kubeclient.NewForConfig(config)
+	})
+	return kubeClient, kubeClientErr
+}
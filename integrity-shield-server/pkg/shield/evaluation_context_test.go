@@ -0,0 +1,181 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+	admv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// number of profiles evaluated for a request in benchmarks
+const benchmarkProfiles = 20
+
+func benchmarkRequest(tb testing.TB) admission.Request {
+	newObj := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "sample-app",
+			"namespace": "sample-ns",
+			"labels":    map[string]interface{}{"app": "sample-app"},
+			"annotations": map[string]interface{}{
+				"integrityshield.io/message":   "H4sIAAAAAAAA/wAAAP//AQAA//8AAAAAAAAAAA==",
+				"integrityshield.io/signature": "MEUCIQDk2b0j7Oq5x0y7nVQm4mJ2l2w4vXy0K3KDq2j5y8V0",
+			},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "registry.example.com/app:1.1.0"},
+					},
+				},
+			},
+		},
+	}
+	oldObj := runtime.DeepCopyJSON(newObj)
+	oldObj["spec"].(map[string]interface{})["replicas"] = int64(1)
+	newRaw, err := json.Marshal(newObj)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	oldRaw, err := json.Marshal(oldObj)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Operation: admv1.Update,
+		Namespace: "sample-ns",
+		Name:      "sample-app",
+		Object:    runtime.RawExtension{Raw: newRaw},
+		OldObject: runtime.RawExtension{Raw: oldRaw},
+	}}
+}
+
+// decodePerProfile reproduces decoding done for each profile without evaluation context:
+// label match, request handler and mutation check decode the raw objects separately.
+func decodePerProfile(req admission.Request, ignoreFields []string) (bool, error) {
+	var forLabel unstructured.Unstructured
+	if err := json.Unmarshal(req.AdmissionRequest.Object.Raw, &forLabel); err != nil {
+		return false, err
+	}
+	_ = forLabel.GetLabels()
+	var forHandler unstructured.Unstructured
+	if err := json.Unmarshal(req.AdmissionRequest.Object.Raw, &forHandler); err != nil {
+		return false, err
+	}
+	newNode, err := mapnode.NewFromBytes(req.AdmissionRequest.Object.Raw)
+	if err != nil {
+		return false, err
+	}
+	oldNode, err := mapnode.NewFromBytes(req.AdmissionRequest.OldObject.Raw)
+	if err != nil {
+		return false, err
+	}
+	dr := oldNode.Mask(mutationCheckMask).Diff(newNode.Mask(mutationCheckMask))
+	if dr == nil || dr.Size() == 0 {
+		return false, nil
+	}
+	_, unfiltered, _ := dr.Filter(ignoreFields)
+	return unfiltered != nil && unfiltered.Size() > 0, nil
+}
+
+func BenchmarkDecodePerProfile(b *testing.B) {
+	req := benchmarkRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for p := 0; p < benchmarkProfiles; p++ {
+			if _, err := decodePerProfile(req, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkEvaluationContext(b *testing.B) {
+	req := benchmarkRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ec := NewEvaluationContext(req)
+		if err := ec.Err(); err != nil {
+			b.Fatal(err)
+		}
+		for p := 0; p < benchmarkProfiles; p++ {
+			_ = ec.Object.GetLabels()
+			if _, err := ec.Mutated(nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEvaluationContextMutated(t *testing.T) {
+	req := benchmarkRequest(t)
+	ec := NewEvaluationContext(req)
+	if err := ec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	mutated, err := ec.Mutated(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mutated {
+		t.Error("expected mutation of spec.replicas to be detected")
+	}
+	mutated, err = ec.Mutated([]string{"spec.replicas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutated {
+		t.Error("expected spec.replicas to be ignored")
+	}
+	if ec.Object.GetAnnotations()["integrityshield.io/signature"] == "" {
+		t.Error("shared object must not be modified by mutation check")
+	}
+}
+
+func TestEvaluationContextDelete(t *testing.T) {
+	req := benchmarkRequest(t)
+	req.Operation = admv1.Delete
+	req.Object = runtime.RawExtension{}
+	ec := NewEvaluationContext(req)
+	if err := ec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if ec.Object == nil || ec.Object.GetName() != "sample-app" {
+		t.Fatalf("expected the deleted object to be evaluated, but got %v", ec.Object)
+	}
+	if ec.OldObject != nil {
+		t.Error("expected no old object for a delete request")
+	}
+	mutated, err := ec.Mutated(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mutated {
+		t.Error("expected a delete request not to be regarded as mutation")
+	}
+}
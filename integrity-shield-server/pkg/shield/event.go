@@ -0,0 +1,238 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	EventReportingController = "integrityshield.io/request-handler"
+	EventReasonDeny          = "Deny"
+	EventReasonBreakGlass    = "BreakGlass"
+)
+
+const (
+	defaultDenyEventQPS   = float32(1.0 / 60.0)
+	defaultDenyEventBurst = 5
+	maxEventRateLimiters  = 4096
+	eventRateLimiterTTL   = 30 * time.Minute
+	// Event.Note can have 1024 bytes at most
+	maxEventNoteLength     = 1024
+	eventNoteTrimmedSuffix = " ... Trimmed. `Event.Note` can have 1024 chars at maximum."
+)
+
+var (
+	eventRecorder     events.EventRecorder
+	eventRecorderErr  error
+	eventRecorderOnce sync.Once
+
+	eventLimiter = &eventRateLimiter{limiters: map[string]*objectRateLimiter{}}
+)
+
+// getEventRecorder lazily starts an events.k8s.io/v1 broadcaster shared by all requests.
+// The broadcaster aggregates isomorphic events into a series and writes them
+// to the API server asynchronously, so recording never blocks the admission path.
+func getEventRecorder() (events.EventRecorder, error) {
+	eventRecorderOnce.Do(func() {
+		config, err := kubeutil.GetKubeConfig()
+		if err != nil {
+			eventRecorderErr = err
+			return
+		}
+		client, err := kubeclient.NewForConfig(config)
+		if err != nil {
+			eventRecorderErr = err
+			return
+		}
+		broadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: client.EventsV1()})
+		broadcaster.StartRecordingToSink(make(chan struct{}))
+		eventRecorder = broadcaster.NewRecorder(scheme.Scheme, EventReportingController)
+	})
+	return eventRecorder, eventRecorderErr
+}
+
+func recordDenyEvent(req admission.Request, ar *ResultFromRequestHandler, constraintName string, config k8smnfconfig.SideEffectConfig) error {
+	// no event is generated for allowed request
+	if ar.Allow {
+		return nil
+	}
+	objKey := eventObjectKey(req, EventReasonDeny)
+	if !eventLimiter.tryAccept(objKey, config.DenyEventQPS, config.DenyEventBurst) {
+		log.WithFields(log.Fields{
+			"namespace": req.Namespace,
+			"name":      req.Name,
+			"kind":      req.Kind.Kind,
+			"operation": req.Operation,
+		}).Debugf("%s event is dropped by rate limiter", EventReasonDeny)
+		return nil
+	}
+	note := "[" + constraintName + "]" + ar.Message
+	return recordWarningEvent(req, EventReasonDeny, note)
+}
+
+// RecordBreakGlassEvent records an event on an object whose request bypassed verification by a break-glass grant.
+// Every bypassed request must be tagged, so break-glass events are not rate limited; repeated events for the same
+// object are aggregated into a series with a count by the event broadcaster.
+func RecordBreakGlassEvent(req admission.Request, breakGlassName, message string) error {
+	note := "[" + breakGlassName + "]" + message
+	return recordWarningEvent(req, EventReasonBreakGlass, note)
+}
+
+func eventObjectKey(req admission.Request, reason string) string {
+	gv := schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}
+	return fmt.Sprintf("%s/%s/%s/%s/%s", reason, gv.String(), req.Kind.Kind, req.Namespace, req.Name)
+}
+
+func recordWarningEvent(req admission.Request, reason, note string) error {
+	gv := schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}
+	// the name is empty for objects created with generateName
+	regarding := &corev1.ObjectReference{
+		Namespace:  req.Namespace,
+		APIVersion: gv.String(),
+		Kind:       req.Kind.Kind,
+		Name:       req.Name,
+	}
+	var related *corev1.ObjectReference
+	if req.Namespace == "" {
+		// an event regarding a cluster-scoped object would be filed into `default`;
+		// it is filed into the namespace of integrity shield instead, regarding its pod and related to the object
+		pod := shieldPodReference()
+		if pod == nil {
+			log.WithFields(log.Fields{
+				"name": req.Name,
+				"kind": req.Kind.Kind,
+			}).Debugf("%s event on a cluster-scoped object is not recorded because POD_NAME is not set", reason)
+			return nil
+		}
+		related = regarding
+		regarding = pod
+	}
+
+	recorder, err := getEventRecorder()
+	if err != nil {
+		log.Errorf("failed to initialize event recorder; %s", err.Error())
+		return err
+	}
+
+	note = truncateNote(note)
+	action := strings.ToLower(string(req.Operation))
+	recorder.Eventf(regarding, related, corev1.EventTypeWarning, reason, action, "%s", note)
+
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+	}).Debug("Event is queued:", eventObjectKey(req, reason))
+
+	return nil
+}
+
+// truncateNote trims a note to the maximum length of `Event.Note` without splitting a multi-byte character.
+func truncateNote(note string) string {
+	if len(note) <= maxEventNoteLength {
+		return note
+	}
+	limit := maxEventNoteLength - len(eventNoteTrimmedSuffix)
+	for limit > 0 && !utf8.RuneStart(note[limit]) {
+		limit--
+	}
+	return note[:limit] + eventNoteTrimmedSuffix
+}
+
+// shieldPodReference returns a reference to the pod of integrity shield, or nil if POD_NAME is not set.
+func shieldPodReference() *corev1.ObjectReference {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		return nil
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	return &corev1.ObjectReference{
+		Namespace:  namespace,
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       name,
+	}
+}
+
+// eventRateLimiter keeps a token bucket per involved object so that a crash-looping
+// controller cannot flood the Events API with denials for the same resource.
+type eventRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*objectRateLimiter
+}
+
+type objectRateLimiter struct {
+	limiter  flowcontrol.RateLimiter
+	lastSeen time.Time
+}
+
+func (l *eventRateLimiter) tryAccept(key string, qps float32, burst int) bool {
+	if qps <= 0 {
+		qps = defaultDenyEventQPS
+	}
+	if burst <= 0 {
+		burst = defaultDenyEventBurst
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ol, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= maxEventRateLimiters {
+			l.evict(now)
+		}
+		ol = &objectRateLimiter{limiter: flowcontrol.NewTokenBucketRateLimiter(qps, burst)}
+		l.limiters[key] = ol
+	}
+	ol.lastSeen = now
+	return ol.limiter.TryAccept()
+}
+
+// evict drops limiters which have not been used recently; if all of them are
+// still active, the map is reset to keep memory bounded.
+func (l *eventRateLimiter) evict(now time.Time) {
+	for k, ol := range l.limiters {
+		if now.Sub(ol.lastSeen) > eventRateLimiterTTL {
+			delete(l.limiters, k)
+		}
+	}
+	if len(l.limiters) >= maxEventRateLimiters {
+		l.limiters = map[string]*objectRateLimiter{}
+	}
+}
@@ -0,0 +1,350 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status of provenance check
+const (
+	ProvenanceStatusVerified   = "Verified"
+	ProvenanceStatusNotFound   = "NotFound"
+	ProvenanceStatusNotMatched = "NotMatched"
+)
+
+// predicate type of SLSA provenance; the version follows the prefix, e.g. v0.1 and v0.2
+const slsaProvenancePredicateTypePrefix = "https://slsa.dev/provenance/"
+
+// in-toto statement with SLSA provenance predicate (v0.1, v0.2)
+type provenanceStatement struct {
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+// artifact which the provenance is about
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation struct {
+		ConfigSource struct {
+			URI string `json:"uri"`
+		} `json:"configSource"`
+	} `json:"invocation"`
+	// SLSA v0.1 has the source as the first material with recipe.definedInMaterial
+	Materials []provenanceMaterial `json:"materials"`
+}
+
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// dsse envelope in which the statement is signed
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// CheckProvenance evaluates provenance attestations in the verify result against the requirements.
+// Only statements in a DSSE envelope signed by one of the keys in keyPath (comma separated, same as
+// VerifyResourceOption.KeyPath) are evaluated, so that provenance written by anyone else is not trusted.
+// A statement must also be SLSA provenance whose subject is the verified artifact, so that provenance
+// of another artifact signed by the same key is not accepted.
+// It returns the provenance status and a message which explains why the requirements are not satisfied.
+func CheckProvenance(result *k8smanifest.VerifyResourceResult, requirements *k8smnfconfig.ProvenanceRequirements, keyPath string) (string, string) {
+	pubkeys, err := loadPublicKeys(keyPath)
+	if err != nil {
+		log.Errorf("failed to load public keys for provenance verification; %s", err.Error())
+	}
+	statements := []provenanceStatement{}
+	unverified := 0
+	otherArtifact := 0
+	if result != nil {
+		for _, p := range result.Provenances {
+			if p == nil || p.Attestation == "" {
+				continue
+			}
+			st, err := decodeProvenanceStatement(p.Attestation, pubkeys)
+			if err != nil {
+				log.Debugf("provenance attestation is not used; %s", err.Error())
+				unverified++
+				continue
+			}
+			if err := checkProvenanceStatement(st, p.Hash); err != nil {
+				log.Debugf("provenance attestation is not used; %s", err.Error())
+				otherArtifact++
+				continue
+			}
+			statements = append(statements, st)
+		}
+	}
+	if len(statements) == 0 {
+		if otherArtifact > 0 {
+			return ProvenanceStatusNotFound, "Provenance is required for this request, but no signed provenance is SLSA provenance of the verified manifest."
+		}
+		if unverified > 0 {
+			return ProvenanceStatusNotFound, "Provenance is required for this request, but no provenance is signed with the keys of the profile."
+		}
+		return ProvenanceStatusNotFound, "Provenance is required for this request, but no provenance is found."
+	}
+	mismatches := []string{}
+	for _, st := range statements {
+		reasons := matchProvenance(st.Predicate, requirements)
+		if len(reasons) == 0 {
+			return ProvenanceStatusVerified, ""
+		}
+		mismatches = append(mismatches, strings.Join(reasons, ", "))
+	}
+	return ProvenanceStatusNotMatched, fmt.Sprintf("Provenance is required for this request, but no provenance satisfies the requirements: %s", strings.Join(mismatches, "; "))
+}
+
+// checkProvenanceStatement checks that the statement is SLSA provenance and that one of its subjects has
+// the digest of the verified artifact. The digest is hex encoded sha256, with or without the `sha256:` prefix.
+func checkProvenanceStatement(st provenanceStatement, artifactDigest string) error {
+	if !strings.HasPrefix(st.PredicateType, slsaProvenancePredicateTypePrefix) {
+		return errors.New(fmt.Sprintf("predicate type `%s` is not SLSA provenance", st.PredicateType))
+	}
+	digest := strings.TrimPrefix(artifactDigest, "sha256:")
+	if digest == "" {
+		return errors.New("the digest of the verified artifact is unknown")
+	}
+	for _, sub := range st.Subject {
+		if strings.TrimPrefix(sub.Digest["sha256"], "sha256:") == digest {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("no subject of the provenance has the digest `%s` of the verified artifact", digest))
+}
+
+func matchProvenance(p provenancePredicate, requirements *k8smnfconfig.ProvenanceRequirements) []string {
+	reasons := []string{}
+	if requirements == nil {
+		return reasons
+	}
+	if len(requirements.BuilderIDs) > 0 && !k8smnfutil.MatchWithPatternArray(p.Builder.ID, requirements.BuilderIDs) {
+		reasons = append(reasons, fmt.Sprintf("builder `%s` is not allowed", p.Builder.ID))
+	}
+	if len(requirements.BuildTypes) > 0 && !k8smnfutil.MatchWithPatternArray(p.BuildType, requirements.BuildTypes) {
+		reasons = append(reasons, fmt.Sprintf("build type `%s` is not allowed", p.BuildType))
+	}
+	if len(requirements.SourceRepositories) > 0 {
+		sources := []string{}
+		if p.Invocation.ConfigSource.URI != "" {
+			sources = append(sources, p.Invocation.ConfigSource.URI)
+		}
+		for _, m := range p.Materials {
+			sources = append(sources, m.URI)
+		}
+		sourceMatched := false
+		for _, s := range sources {
+			if k8smnfutil.MatchWithPatternArray(trimSourceURI(s), requirements.SourceRepositories) {
+				sourceMatched = true
+				break
+			}
+		}
+		if !sourceMatched {
+			reasons = append(reasons, fmt.Sprintf("source repository %v is not allowed", sources))
+		}
+	}
+	for _, rm := range requirements.Materials {
+		if !hasMaterial(p.Materials, rm) {
+			reasons = append(reasons, fmt.Sprintf("material `%s` with digest %v is not found", rm.URI, rm.Digest))
+		}
+	}
+	return reasons
+}
+
+func hasMaterial(materials []provenanceMaterial, required k8smnfconfig.MaterialReference) bool {
+	for _, m := range materials {
+		if required.URI != "" && !k8smnfutil.MatchPattern(required.URI, trimSourceURI(m.URI)) && !k8smnfutil.MatchPattern(required.URI, m.URI) {
+			continue
+		}
+		digestMatched := true
+		for alg, val := range required.Digest {
+			if m.Digest[alg] != val {
+				digestMatched = false
+				break
+			}
+		}
+		if digestMatched {
+			return true
+		}
+	}
+	return false
+}
+
+// trimSourceURI removes the scheme prefix and the ref suffix from a source uri,
+// e.g. `git+https://github.com/org/repo@refs/heads/main` -> `github.com/org/repo`
+func trimSourceURI(uri string) string {
+	s := uri
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.Index(s, "@"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSuffix(s, ".git")
+}
+
+// decodeProvenanceStatement decodes a DSSE envelope given as JSON or base64 encoded JSON,
+// and returns the statement in it only if the envelope is signed with any of pubkeys.
+func decodeProvenanceStatement(attestation string, pubkeys []crypto.PublicKey) (provenanceStatement, error) {
+	var st provenanceStatement
+	data := []byte(attestation)
+	if !json.Valid(data) {
+		decoded, err := base64.StdEncoding.DecodeString(attestation)
+		if err != nil {
+			return st, err
+		}
+		data = decoded
+	}
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return st, err
+	}
+	if envelope.Payload == "" {
+		return st, errors.New("the attestation is not a DSSE envelope")
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return st, err
+	}
+	if err := verifyEnvelope(envelope.PayloadType, payload, envelope.Signatures, pubkeys); err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// verifyEnvelope verifies the signatures of a DSSE envelope over its pre-authentication encoding.
+func verifyEnvelope(payloadType string, payload []byte, signatures []dsseSignature, pubkeys []crypto.PublicKey) error {
+	if len(signatures) == 0 {
+		return errors.New("the DSSE envelope has no signatures")
+	}
+	if len(pubkeys) == 0 {
+		return errors.New("no public keys to verify the DSSE envelope")
+	}
+	pae := dssePAE(payloadType, payload)
+	digest := sha256.Sum256(pae)
+	for _, s := range signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		for _, pubkey := range pubkeys {
+			if verifySignature(pubkey, pae, digest[:], sig) {
+				return nil
+			}
+		}
+	}
+	return errors.New("the DSSE envelope is not signed with any of the public keys")
+}
+
+// dssePAE returns the pre-authentication encoding of DSSE v1
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func verifySignature(pubkey crypto.PublicKey, message, digest, sig []byte) bool {
+	switch k := pubkey.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest, sig)
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, digest, sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	}
+	return false
+}
+
+// loadPublicKeys reads PEM encoded public keys from the comma separated key paths.
+func loadPublicKeys(keyPath string) ([]crypto.PublicKey, error) {
+	pubkeys := []crypto.PublicKey{}
+	sumErr := []string{}
+	for _, p := range strings.Split(keyPath, ",") {
+		if p == "" {
+			continue
+		}
+		keyBytes, err := ioutil.ReadFile(p)
+		if err != nil {
+			sumErr = append(sumErr, err.Error())
+			continue
+		}
+		pubkey, err := parsePublicKey(keyBytes)
+		if err != nil {
+			sumErr = append(sumErr, fmt.Sprintf("%s: %s", p, err.Error()))
+			continue
+		}
+		pubkeys = append(pubkeys, pubkey)
+	}
+	if len(sumErr) > 0 {
+		return pubkeys, errors.New(strings.Join(sumErr, "; "))
+	}
+	return pubkeys, nil
+}
+
+func parsePublicKey(keyBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block is found")
+	}
+	if pubkey, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return pubkey, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the public key")
+	}
+	return cert.PublicKey, nil
+}
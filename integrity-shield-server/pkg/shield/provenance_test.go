@@ -0,0 +1,255 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+)
+
+const testPayloadType = "application/vnd.in-toto+json"
+
+const testArtifactDigest = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+func testStatement() []byte {
+	return []byte(`{
+  "_type": "https://in-toto.io/Statement/v0.1",
+  "subject": [
+    {"name": "registry.example.com/manifests/sample", "digest": {"sha256": "` + testArtifactDigest + `"}}
+  ],
+  "predicateType": "https://slsa.dev/provenance/v0.1",
+  "predicate": {
+    "builder": {"id": "https://github.com/Attestations/GitHubHostedActions@v1"},
+    "recipe": {"type": "https://github.com/Attestations/GitHubActionsWorkflow@v1"},
+    "buildType": "https://github.com/Attestations/GitHubActionsWorkflow@v1",
+    "invocation": {"configSource": {"uri": "git+https://github.com/example-org/app@refs/heads/main"}},
+    "materials": [
+      {"uri": "git+https://github.com/example-org/app.git", "digest": {"sha1": "0123456789abcdef0123456789abcdef01234567"}},
+      {"uri": "https://github.com/example-org/lib", "digest": {"sha1": "89abcdef0123456789abcdef0123456789abcdef"}}
+    ]
+  }
+}`)
+}
+
+func generateTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writeTestPublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyPath, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath
+}
+
+func signedEnvelope(t *testing.T, key *ecdsa.PrivateKey, payload []byte) string {
+	envelope := dsseEnvelope{
+		PayloadType: testPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+	}
+	if key != nil {
+		digest := sha256.Sum256(dssePAE(testPayloadType, payload))
+		sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope.Signatures = []dsseSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}}
+	}
+	envBytes, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(envBytes)
+}
+
+func TestDecodeProvenanceStatement(t *testing.T) {
+	key := generateTestKey(t)
+	otherKey := generateTestKey(t)
+	pubkeys, err := loadPublicKeys(writeTestPublicKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := signedEnvelope(t, key, testStatement())
+	tampered := dsseEnvelope{}
+	_ = json.Unmarshal([]byte(signed), &tampered)
+	tampered.Payload = base64.StdEncoding.EncodeToString([]byte(`{"predicate": {"builder": {"id": "https://evil.example.com"}}}`))
+	tamperedBytes, _ := json.Marshal(tampered)
+
+	tests := []struct {
+		name        string
+		attestation string
+		pubkeys     []crypto.PublicKey
+		wantErr     bool
+	}{
+		{name: "signed envelope", attestation: signed, pubkeys: pubkeys},
+		{name: "base64 encoded signed envelope", attestation: base64.StdEncoding.EncodeToString([]byte(signed)), pubkeys: pubkeys},
+		{name: "signed with another key", attestation: signedEnvelope(t, otherKey, testStatement()), pubkeys: pubkeys, wantErr: true},
+		{name: "unsigned envelope", attestation: signedEnvelope(t, nil, testStatement()), pubkeys: pubkeys, wantErr: true},
+		{name: "tampered payload", attestation: string(tamperedBytes), pubkeys: pubkeys, wantErr: true},
+		{name: "bare statement", attestation: string(testStatement()), pubkeys: pubkeys, wantErr: true},
+		{name: "no keys", attestation: signed, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := decodeProvenanceStatement(tc.attestation, tc.pubkeys)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, but the statement is accepted: %+v", st)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if st.Predicate.Builder.ID != "https://github.com/Attestations/GitHubHostedActions@v1" {
+				t.Errorf("unexpected builder: %s", st.Predicate.Builder.ID)
+			}
+		})
+	}
+}
+
+func TestMatchProvenance(t *testing.T) {
+	var st provenanceStatement
+	if err := json.Unmarshal(testStatement(), &st); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		requirements *k8smnfconfig.ProvenanceRequirements
+		matched      bool
+	}{
+		{name: "no requirements", requirements: nil, matched: true},
+		{name: "builder matched", requirements: &k8smnfconfig.ProvenanceRequirements{BuilderIDs: []string{"https://github.com/Attestations/*"}}, matched: true},
+		{name: "builder not matched", requirements: &k8smnfconfig.ProvenanceRequirements{BuilderIDs: []string{"https://tekton.dev/chains/v2"}}, matched: false},
+		{name: "build type matched", requirements: &k8smnfconfig.ProvenanceRequirements{BuildTypes: []string{"https://github.com/Attestations/GitHubActionsWorkflow@v1"}}, matched: true},
+		{name: "build type not matched", requirements: &k8smnfconfig.ProvenanceRequirements{BuildTypes: []string{"https://tekton.dev/v1beta1/TaskRun"}}, matched: false},
+		{name: "source from config source", requirements: &k8smnfconfig.ProvenanceRequirements{SourceRepositories: []string{"github.com/example-org/app"}}, matched: true},
+		{name: "source from materials", requirements: &k8smnfconfig.ProvenanceRequirements{SourceRepositories: []string{"github.com/example-org/lib"}}, matched: true},
+		{name: "source pattern", requirements: &k8smnfconfig.ProvenanceRequirements{SourceRepositories: []string{"github.com/example-org/*"}}, matched: true},
+		{name: "source not matched", requirements: &k8smnfconfig.ProvenanceRequirements{SourceRepositories: []string{"github.com/other-org/*"}}, matched: false},
+		{
+			name: "material with digest",
+			requirements: &k8smnfconfig.ProvenanceRequirements{Materials: []k8smnfconfig.MaterialReference{
+				{URI: "github.com/example-org/app", Digest: map[string]string{"sha1": "0123456789abcdef0123456789abcdef01234567"}},
+			}},
+			matched: true,
+		},
+		{
+			name: "material with untrimmed uri",
+			requirements: &k8smnfconfig.ProvenanceRequirements{Materials: []k8smnfconfig.MaterialReference{
+				{URI: "https://github.com/example-org/lib"},
+			}},
+			matched: true,
+		},
+		{
+			name: "material with another digest",
+			requirements: &k8smnfconfig.ProvenanceRequirements{Materials: []k8smnfconfig.MaterialReference{
+				{URI: "github.com/example-org/app", Digest: map[string]string{"sha1": "89abcdef0123456789abcdef0123456789abcdef"}},
+			}},
+			matched: false,
+		},
+		{
+			name: "material not found",
+			requirements: &k8smnfconfig.ProvenanceRequirements{Materials: []k8smnfconfig.MaterialReference{
+				{URI: "github.com/example-org/unknown"},
+			}},
+			matched: false,
+		},
+		{
+			name: "all rules",
+			requirements: &k8smnfconfig.ProvenanceRequirements{
+				BuilderIDs:         []string{"https://github.com/Attestations/GitHubHostedActions@v1"},
+				BuildTypes:         []string{"https://github.com/Attestations/GitHubActionsWorkflow@v1"},
+				SourceRepositories: []string{"github.com/example-org/app"},
+				Materials:          []k8smnfconfig.MaterialReference{{Digest: map[string]string{"sha1": "89abcdef0123456789abcdef0123456789abcdef"}}},
+			},
+			matched: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reasons := matchProvenance(st.Predicate, tc.requirements)
+			if matched := len(reasons) == 0; matched != tc.matched {
+				t.Errorf("expected matched=%v, but got reasons %v", tc.matched, reasons)
+			}
+		})
+	}
+}
+
+func TestCheckProvenanceStatement(t *testing.T) {
+	var st provenanceStatement
+	if err := json.Unmarshal(testStatement(), &st); err != nil {
+		t.Fatal(err)
+	}
+	withPredicateType := func(predicateType string) provenanceStatement {
+		s := st
+		s.PredicateType = predicateType
+		return s
+	}
+	tests := []struct {
+		name           string
+		statement      provenanceStatement
+		artifactDigest string
+		wantErr        bool
+	}{
+		{name: "subject of the artifact", statement: st, artifactDigest: testArtifactDigest},
+		{name: "digest with algorithm", statement: st, artifactDigest: "sha256:" + testArtifactDigest},
+		{name: "slsa v0.2", statement: withPredicateType("https://slsa.dev/provenance/v0.2"), artifactDigest: testArtifactDigest},
+		{name: "another artifact", statement: st, artifactDigest: "0000000000000000000000000000000000000000000000000000000000000000", wantErr: true},
+		{name: "unknown artifact", statement: st, artifactDigest: "", wantErr: true},
+		{name: "no subject", statement: provenanceStatement{PredicateType: st.PredicateType, Predicate: st.Predicate}, artifactDigest: testArtifactDigest, wantErr: true},
+		{name: "not slsa provenance", statement: withPredicateType("https://spdx.dev/Document"), artifactDigest: testArtifactDigest, wantErr: true},
+		{name: "no predicate type", statement: withPredicateType(""), artifactDigest: testArtifactDigest, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkProvenanceStatement(tc.statement, tc.artifactDigest)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error=%v, but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckProvenanceWithoutAttestation(t *testing.T) {
+	status, _ := CheckProvenance(nil, &k8smnfconfig.ProvenanceRequirements{}, "")
+	if status != ProvenanceStatusNotFound {
+		t.Errorf("expected %s, but got %s", ProvenanceStatusNotFound, status)
+	}
+}
@@ -18,72 +18,127 @@ package shield
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/audit"
 	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
-	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
-	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/admission/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	kubeclient "k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
-
-	corev1 "k8s.io/api/core/v1"
-
-	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const defaultConfigKeyInConfigMap = "config.yaml"
 const defaultPodNamespace = "integrity-shield-operator-system"
 const defaultHandlerConfigMapName = "request-handler-config"
-const ImageRefAnnotationKeyShield = "integrityshield.io/signature"
-const AnnotationKeyDomain = "integrityshield.io"
-const SignatureAnnotationTypeShield = "IntegrityShield"
+
+// DefaultRequestTimeout is applied when the caller gives no deadline.
+// It is shorter than the webhook timeout so that a response is returned before the API server gives up.
+const DefaultRequestTimeout = 9 * time.Second
+
+// Reasons of the request handler decision
 const (
-	EventTypeAnnotationKey       = "integrityshield.io/eventType"
-	EventResultAnnotationKey     = "integrityshield.io/eventResult"
-	EventTypeValueVerifyResult   = "verify-result"
-	EventTypeAnnotationValueDeny = "deny"
+	ReasonVerified                = "Verified"
+	ReasonNotProtected            = "NotProtected"
+	ReasonNoMutation              = "NoMutation"
+	ReasonSkipUser                = "SkipUser"
+	ReasonSkipObject              = "SkipObject"
+	ReasonOutOfScope              = "OutOfScope"
+	ReasonSignatureNotFound       = "SignatureNotFound"
+	ReasonDiffFound               = "DiffFound"
+	ReasonSignerNotMatched        = "SignerNotMatched"
+	ReasonImageVerificationFailed = "ImageVerificationFailed"
+	ReasonProvenanceNotFound      = "ProvenanceNotFound"
+	ReasonProvenanceNotMatched    = "ProvenanceNotMatched"
+	// the request could not be evaluated and the failure policy decided the response
+	ReasonFailOpen   = "FailOpen"
+	ReasonFailClosed = "FailClosed"
 )
 
-func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObject) *ResultFromRequestHandler {
-	// load constraint config
-	cconfig, err := k8smnfconfig.LoadConstraintConfig()
-	if err != nil {
-		log.Errorf("failed to load constraint config", err.Error())
+// concise descriptions of deny reasons used in admission warnings
+var denySummaries = map[string]string{
+	ReasonSignatureNotFound:       "unsigned",
+	ReasonDiffFound:               "modified from the signed manifest",
+	ReasonSignerNotMatched:        "signed by an untrusted signer",
+	ReasonImageVerificationFailed: "unsigned container image",
+	ReasonProvenanceNotFound:      "no provenance",
+	ReasonProvenanceNotMatched:    "provenance requirements not satisfied",
+	ReasonFailClosed:              "verification could not be completed",
+}
+
+// maxWarningLength keeps each warning short enough to be shown at apply time
+const maxWarningLength = 120
+
+// DenyWarning returns a concise message for a request which would be denied with the reason.
+func DenyWarning(reason string) string {
+	summary, ok := denySummaries[reason]
+	if !ok {
+		summary = reason
+	}
+	msg := "this would be denied: " + summary
+	if len(msg) > maxWarningLength {
+		// cut at a rune boundary so that the warning stays valid UTF-8
+		limit := maxWarningLength
+		for limit > 0 && !utf8.RuneStart(msg[limit]) {
+			limit--
+		}
+		msg = msg[:limit]
 	}
-	// get enforce action
-	enforce := k8smnfconfig.CheckIfEnforceConstraint(paramObj.ConstraintName, cconfig.Constraints)
+	return msg
+}
 
-	// unmarshal admission request object
-	var resource unstructured.Unstructured
-	objectBytes := req.AdmissionRequest.Object.Raw
-	err = json.Unmarshal(objectBytes, &resource)
-	if err != nil {
-		log.Errorf("failed to Unmarshal a requested object into %T; %s", resource, err.Error())
-		errMsg := "IntegrityShield failed to decide the response. Failed to Unmarshal a requested object: " + err.Error()
-		return makeResultFromRequestHandler(false, errMsg, enforce, req)
+func RequestHandler(ctx context.Context, req admission.Request, paramObj *k8smnfconfig.ParameterObject) *ResultFromRequestHandler {
+	return EvaluateRequest(ctx, NewEvaluationContext(req), paramObj)
+}
+
+// EvaluateRequest is RequestHandler for a request which is already decoded into an evaluation context.
+// The context is only read, so it can be shared by profiles evaluated concurrently.
+func EvaluateRequest(ctx context.Context, ec *EvaluationContext, paramObj *k8smnfconfig.ParameterObject) *ResultFromRequestHandler {
+	start := time.Now()
+	req := ec.Request
+	failurePolicy := paramObj.GetFailurePolicy()
+	// load constraint config
+	cconfig, cconfigErr := k8smnfconfig.LoadConstraintConfig(ctx)
+	if cconfigErr != nil {
+		log.Errorf("failed to load constraint config; %s", cconfigErr.Error())
 	}
+	// constraint config is optional; a missing one is only logged as before
+	cconfigMissing := cconfigErr != nil && k8serrors.IsNotFound(errors.Cause(cconfigErr))
+	// get action, unless the profile has its own action
+	action := paramObj.GetAction(enforcedByConstraintConfig(paramObj.ConstraintName, cconfig, cconfigErr))
 
 	// load request handler config
-	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig()
+	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig(ctx)
 	if err != nil {
-		log.Errorf("failed to load request handler config", err.Error())
-		errMsg := "IntegrityShield failed to decide the response. Failed to load request handler config: " + err.Error()
-		return makeResultFromRequestHandler(false, errMsg, enforce, req)
+		log.Errorf("failed to load request handler config; %s", err.Error())
+		allow, reason, errMsg := ApplyFailurePolicy(ctx, failurePolicy, "Failed to load request handler config", err)
+		return makeResultFromRequestHandler(allow, reason, errMsg, action, req)
 	}
 	if rhconfig == nil {
 		log.Warning("request handler config is empty")
 		rhconfig = &k8smnfconfig.RequestHandlerConfig{}
 	}
+	if cconfigErr != nil && !cconfigMissing {
+		allow, reason, errMsg := ApplyFailurePolicy(ctx, failurePolicy, "Failed to load constraint config", cconfigErr)
+		r := makeResultFromRequestHandler(allow, reason, errMsg, action, req)
+		return finalizeResult(ec, r, paramObj, rhconfig, start)
+	}
+
+	// admission request object decoded in evaluation context
+	if err := ec.Err(); err != nil {
+		log.Errorf("failed to decode the request; %s", err.Error())
+		allow, reason, errMsg := ApplyFailurePolicy(ctx, failurePolicy, "Failed to Unmarshal a requested object", err)
+		r := makeResultFromRequestHandler(allow, reason, errMsg, action, req)
+		return finalizeResult(ec, r, paramObj, rhconfig, start)
+	}
+	resource := *ec.Object
 
 	// setup log
 	k8smnfconfig.SetupLogger(rhconfig.Log, req)
@@ -100,9 +155,9 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 	skipObjectMatched := false
 
 	//filter by user listed in common profile
-	commonSkipUserMatched = rhconfig.RequestFilterProfile.SkipUsers.Match(resource, req.AdmissionRequest.UserInfo.Username)
+	commonSkipUserMatched = rhconfig.RequestFilterProfile.SkipUsers.Match(resource, ec.UserInfo.Username)
 	// TODO: inserted ad hoc logic: need to fix
-	if commonSkipUserMatched && req.AdmissionRequest.UserInfo.Username == "system:admin" {
+	if commonSkipUserMatched && ec.UserInfo.Username == "system:admin" {
 		if req.Namespace == "akmebank-dev-ns" || req.Namespace == "akmebank-stage-ns" {
 			commonSkipUserMatched = false
 		}
@@ -113,7 +168,7 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 
 	// Proccess with parameter
 	//filter by user
-	skipUserMatched := paramObj.SkipUsers.Match(resource, req.AdmissionRequest.UserInfo.Username)
+	skipUserMatched := paramObj.SkipUsers.Match(resource, ec.UserInfo.Username)
 
 	//check scope
 	inScopeObjMatched := paramObj.InScopeObjects.Match(resource)
@@ -121,36 +176,42 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 	// mutation check
 	if isUpdateRequest(req.AdmissionRequest.Operation) {
 		ignoreFields := getMatchedIgnoreFields(paramObj.IgnoreFields, rhconfig.RequestFilterProfile.IgnoreFields, resource)
-		mutated, err := mutationCheck(req.AdmissionRequest.OldObject.Raw, req.AdmissionRequest.Object.Raw, ignoreFields)
+		mutated, err := ec.Mutated(ignoreFields)
 		if err != nil {
-			log.Errorf("failed to check mutation", err.Error())
-			errMsg := "IntegrityShield failed to decide the response. Failed to check mutation: " + err.Error()
-			return makeResultFromRequestHandler(false, errMsg, enforce, req)
+			log.Errorf("failed to check mutation; %s", err.Error())
+			allow, reason, errMsg := ApplyFailurePolicy(ctx, failurePolicy, "Failed to check mutation", err)
+			r := makeResultFromRequestHandler(allow, reason, errMsg, action, req)
+			return finalizeResult(ec, r, paramObj, rhconfig, start)
 		}
 		if !mutated {
-			return makeResultFromRequestHandler(true, "no mutation found", enforce, req)
+			r := makeResultFromRequestHandler(true, ReasonNoMutation, "no mutation found", action, req)
+			return finalizeResult(ec, r, paramObj, rhconfig, start)
 		}
 	}
 
 	allow := false
 	message := ""
+	reason := ""
+	signer := ""
+	annotationDomain := ""
 	if skipUserMatched || commonSkipUserMatched {
 		allow = true
+		reason = ReasonSkipUser
 		message = "SkipUsers rule matched."
 	} else if !inScopeObjMatched {
 		allow = true
+		reason = ReasonOutOfScope
 		message = "InScopeObjects rule did not match. Out of scope of verification."
 	} else if skipObjectMatched {
 		allow = true
+		reason = ReasonSkipObject
 		message = "SkipObjects rule matched."
 	} else {
-		var signatureAnnotationType string
-		annotations := resource.GetAnnotations()
-		_, found := annotations[ImageRefAnnotationKeyShield]
-		if found {
-			signatureAnnotationType = SignatureAnnotationTypeShield
+		domain, matched := paramObj.MatchAnnotationDomain(resource.GetAnnotations())
+		if matched {
+			annotationDomain = domain
 		}
-		vo := setVerifyOption(paramObj, rhconfig, signatureAnnotationType)
+		vo := setVerifyOption(paramObj, rhconfig, domain)
 		log.WithFields(log.Fields{
 			"namespace": req.Namespace,
 			"name":      req.Name,
@@ -158,15 +219,9 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 			"operation": req.Operation,
 			"userName":  req.UserInfo.Username,
 		}).Debug("VerifyOption: ", vo)
-		// call VerifyResource with resource, verifyOption, keypath, imageRef
-		result, err := k8smanifest.VerifyResource(resource, vo)
-		log.WithFields(log.Fields{
-			"namespace": req.Namespace,
-			"name":      req.Name,
-			"kind":      req.Kind.Kind,
-			"operation": req.Operation,
-			"userName":  req.UserInfo.Username,
-		}).Debug("VerifyResource result: ", result)
+		// call VerifyResource with resource, verifyOption, keypath and each signature ref
+		// verification may modify the object, so it works on a copy of the shared one
+		result, err := VerifyResourceWithSignatureRefs(ctx, *resource.DeepCopy(), req.Namespace, req.Name, vo, paramObj.SignatureRef)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"namespace": req.Namespace,
@@ -175,29 +230,32 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 				"operation": req.Operation,
 				"userName":  req.UserInfo.Username,
 			}).Warning("Signature verification is required for this request, but verifyResource return error ; %s", err.Error())
-			r := makeResultFromRequestHandler(false, err.Error(), enforce, req)
-			// generate events
-			if rhconfig.SideEffectConfig.CreateDenyEvent {
-				_ = createOrUpdateEvent(req, r, paramObj.ConstraintName)
-			}
-			return r
+			allow, reason, errMsg := ApplyFailurePolicy(ctx, failurePolicy, "Failed to verify the resource", err)
+			r := makeResultFromRequestHandler(allow, reason, errMsg, action, req)
+			return finalizeResult(ec, r, paramObj, rhconfig, start)
 		}
 
+		signer = result.Signer
 		if result.InScope {
 			if result.Verified {
 				allow = true
+				reason = ReasonVerified
 				message = fmt.Sprintf("singed by a valid signer: %s", result.Signer)
 			} else {
 				allow = false
+				reason = ReasonSignatureNotFound
 				message = "Signature verification is required for this request, but no signature is found."
 				if result.Diff != nil && result.Diff.Size() > 0 {
+					reason = ReasonDiffFound
 					message = fmt.Sprintf("Signature verification is required for this request, but failed to verify signature. diff found: %s", result.Diff.String())
 				} else if result.Signer != "" {
+					reason = ReasonSignerNotMatched
 					message = fmt.Sprintf("Signature verification is required for this request, but no signer config matches with this resource. This is signed by %s", result.Signer)
 				}
 			}
 		} else {
 			allow = true
+			reason = ReasonNotProtected
 			message = "not protected"
 		}
 		// image verify result
@@ -214,35 +272,124 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 			imageMessage = "Image signature verification is required, but failed to verify signature."
 		}
 		if allow && !imageAllow {
+			reason = ReasonImageVerificationFailed
 			message = imageMessage
 			allow = false
 		}
+		// provenance check
+		if allow && result.InScope && paramObj.ProvenanceRequirements != nil {
+			status, provMessage := CheckProvenance(result, paramObj.ProvenanceRequirements, vo.KeyPath)
+			if status != ProvenanceStatusVerified {
+				allow = false
+				reason = ReasonProvenanceNotMatched
+				if status == ProvenanceStatusNotFound {
+					reason = ReasonProvenanceNotFound
+				}
+				message = provMessage
+			}
+		}
 	}
 
-	r := makeResultFromRequestHandler(allow, message, enforce, req)
+	r := makeResultFromRequestHandler(allow, reason, message, action, req)
+	r.Signer = signer
+	r.AnnotationDomain = annotationDomain
+	return finalizeResult(ec, r, paramObj, rhconfig, start)
+}
 
-	// generate events
-	if rhconfig.SideEffectConfig.CreateDenyEvent {
-		_ = createOrUpdateEvent(req, r, paramObj.ConstraintName)
-	}
-	return r
+// enforcedByConstraintConfig reports whether constraint-config enforces a profile. The failure policy is always
+// enforced if constraint-config fails to be loaded. A missing constraint-config enforces no profile, as an empty one.
+func enforcedByConstraintConfig(constraintName string, cconfig k8smnfconfig.ConstraintConfig, cconfigErr error) bool {
+	cconfigMissing := cconfigErr != nil && k8serrors.IsNotFound(errors.Cause(cconfigErr))
+	return (cconfigErr != nil && !cconfigMissing) || k8smnfconfig.CheckIfEnforceConstraint(constraintName, cconfig.Constraints)
 }
 
 type ResultFromRequestHandler struct {
 	Allow   bool   `json:"allow"`
 	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+	Signer  string `json:"signer,omitempty"`
 	Profile string `json:"profile,omitempty"`
+	// annotation domain in which signature annotations were found
+	AnnotationDomain string `json:"annotationDomain,omitempty"`
+	// warnings returned to the user, e.g. the request is allowed only because the constraint is not enforced
+	Warnings []string `json:"warnings,omitempty"`
+	// action applied to the request, and whether the request would have been denied if it were enforced
+	Action   string `json:"action,omitempty"`
+	Detected bool   `json:"detected,omitempty"`
 }
 
-func makeResultFromRequestHandler(allow bool, msg string, enforce bool, req admission.Request) *ResultFromRequestHandler {
+// finalizeResult runs side effects of a decision: deny event and audit record
+func finalizeResult(ec *EvaluationContext, r *ResultFromRequestHandler, paramObj *k8smnfconfig.ParameterObject, rhconfig *k8smnfconfig.RequestHandlerConfig, start time.Time) *ResultFromRequestHandler {
+	if ec.DryRun {
+		return r
+	}
+	recordSideEffects(ec.Request, r, paramObj, rhconfig, start, false)
+	return r
+}
+
+// RecordCachedResult runs side effects of a result which is reused without evaluation, e.g. from the decision
+// cache of the admission controller, so that a reused decision creates the same deny event and audit record.
+func RecordCachedResult(ctx context.Context, req admission.Request, r *ResultFromRequestHandler, paramObj *k8smnfconfig.ParameterObject) {
+	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig(ctx)
+	if err != nil {
+		log.Errorf("failed to load request handler config; %s", err.Error())
+		return
+	}
+	if rhconfig == nil {
+		return
+	}
+	recordSideEffects(req, r, paramObj, rhconfig, time.Now(), true)
+}
+
+func recordSideEffects(req admission.Request, r *ResultFromRequestHandler, paramObj *k8smnfconfig.ParameterObject, rhconfig *k8smnfconfig.RequestHandlerConfig, start time.Time, cached bool) {
+	// generate events
+	if rhconfig.SideEffectConfig.CreateDenyEvent {
+		_ = recordDenyEvent(req, r, paramObj.ConstraintName, rhconfig.SideEffectConfig)
+	}
+	// audit
+	if rhconfig.Audit.Enabled {
+		record := audit.NewRecord(audit.SourceRequestHandler, req, start)
+		record.Allow = r.Allow
+		record.Reason = r.Reason
+		record.Message = r.Message
+		record.Signer = r.Signer
+		record.Cached = cached
+		record.Profiles = []audit.ProfileDecision{
+			{
+				Name:             paramObj.ConstraintName,
+				Allow:            r.Allow,
+				Reason:           r.Reason,
+				Message:          r.Message,
+				Signer:           r.Signer,
+				AnnotationDomain: r.AnnotationDomain,
+				Action:           r.Action,
+				Detected:         r.Detected,
+			},
+		}
+		audit.Emit(audit.SourceRequestHandler, rhconfig.Audit, record)
+	}
+}
+
+// ApplyFailurePolicy decides the response for a request which could not be evaluated
+// because the deadline was exceeded or a dependency failed.
+func ApplyFailurePolicy(ctx context.Context, failurePolicy, msg string, err error) (bool, string, string) {
+	cause := "error"
+	if ctx.Err() == context.DeadlineExceeded {
+		cause = "deadline exceeded"
+	}
+	errMsg := fmt.Sprintf("IntegrityShield failed to decide the response (%s, %s). %s: %s", cause, failurePolicy, msg, err.Error())
+	if failurePolicy == k8smnfconfig.FailurePolicyOpen {
+		return true, ReasonFailOpen, errMsg
+	}
+	return false, ReasonFailClosed, errMsg
+}
+
+func makeResultFromRequestHandler(allow bool, reason, msg string, action string, req admission.Request) *ResultFromRequestHandler {
 	res := &ResultFromRequestHandler{}
 	res.Allow = allow
+	res.Reason = reason
 	res.Message = msg
-	if !allow && !enforce {
-		res.Allow = true
-		res.Message = fmt.Sprintf("allowed because not enforced: %s", msg)
-
-	}
+	ApplyAction(res, action)
 	log.WithFields(log.Fields{
 		"namespace": req.Namespace,
 		"name":      req.Name,
@@ -254,6 +401,24 @@ func makeResultFromRequestHandler(allow bool, msg string, enforce bool, req admi
 	return res
 }
 
+// ApplyAction allows a denied result unless the action is enforce. With detect, the user is warned;
+// with audit-only, the result is only recorded.
+func ApplyAction(res *ResultFromRequestHandler, action string) {
+	res.Action = action
+	if res.Allow || action == k8smnfconfig.ActionEnforce {
+		return
+	}
+	res.Allow = true
+	res.Detected = true
+	switch action {
+	case k8smnfconfig.ActionAuditOnly:
+		res.Message = fmt.Sprintf("allowed because audit-only: %s", res.Message)
+	default:
+		res.Message = fmt.Sprintf("allowed because not enforced: %s", res.Message)
+		res.Warnings = []string{DenyWarning(res.Reason) + " (not enforced)"}
+	}
+}
+
 func isUpdateRequest(operation v1.Operation) bool {
 	return (operation == v1.Update)
 }
@@ -264,77 +429,27 @@ func getMatchedIgnoreFields(pi, ci k8smanifest.ObjectFieldBindingList, resource
 	_, commonfields := ci.Match(resource)
 	allIgnoreFields = append(allIgnoreFields, fields...)
 	allIgnoreFields = append(allIgnoreFields, commonfields...)
+	allIgnoreFields = append(allIgnoreFields, k8smnfconfig.VerificationAnnotationIgnoreFields().Fields...)
 	return allIgnoreFields
 }
 
-func mutationCheck(rawOldObject, rawObject []byte, IgnoreFields []string) (bool, error) {
-	var oldObject *mapnode.Node
-	var newObject *mapnode.Node
-	mask := []string{
-		"metadata.annotations.namespace",
-		"metadata.annotations.kubectl.\"kubernetes.io/last-applied-configuration\"",
-		"metadata.annotations.deprecated.daemonset.template.generation",
-		"metadata.creationTimestamp",
-		"metadata.uid",
-		"metadata.generation",
-		"metadata.managedFields",
-		"metadata.selfLink",
-		"metadata.resourceVersion",
-		"status",
-	}
-	if v, err := mapnode.NewFromBytes(rawObject); err != nil || v == nil {
-		return false, err
-	} else {
-		v = v.Mask(mask)
-		obj := v.ToMap()
-		newObject, _ = mapnode.NewFromMap(obj)
-	}
-	if v, err := mapnode.NewFromBytes(rawOldObject); err != nil || v == nil {
-		return false, err
-	} else {
-		v = v.Mask(mask)
-		oldObj := v.ToMap()
-		oldObject, _ = mapnode.NewFromMap(oldObj)
-	}
-	// diff
-	dr := oldObject.Diff(newObject)
-	if dr == nil || dr.Size() == 0 {
-		return false, nil
-	}
-	// ignoreField check
-	unfiltered := &mapnode.DiffResult{}
-	if dr != nil && dr.Size() > 0 {
-		_, unfiltered, _ = dr.Filter(IgnoreFields)
-	}
-	if unfiltered.Size() == 0 {
-		return false, nil
-	}
-	return true, nil
-}
-
-func setVerifyOption(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfig.RequestHandlerConfig, signatureAnnotationType string) *k8smanifest.VerifyResourceOption {
+func setVerifyOption(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfig.RequestHandlerConfig, annotationDomain string) *k8smanifest.VerifyResourceOption {
 	// get verifyOption and imageRef from Parameter
 	vo := &paramObj.VerifyResourceOption
 	// vo.CheckDryRunForApply = true
-	if paramObj.SignatureRef.ImageRef != "" {
-		vo.ImageRef = paramObj.SignatureRef.ImageRef
-	}
-	if paramObj.SignatureRef.SignatureResourceRef.Name != "" && paramObj.SignatureRef.SignatureResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", paramObj.SignatureRef.SignatureResourceRef.Namespace, paramObj.SignatureRef.SignatureResourceRef.Name)
-		vo.SignatureResourceRef = ref
-	}
-	if paramObj.SignatureRef.ProvenanceResourceRef.Name != "" && paramObj.SignatureRef.ProvenanceResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", paramObj.SignatureRef.ProvenanceResourceRef.Namespace, paramObj.SignatureRef.ProvenanceResourceRef.Name)
-		vo.ProvenanceResourceRef = ref
-	}
+	// signature refs are set for each candidate in VerifyResourceWithSignatureRefs
 
 	namespace := os.Getenv("POD_NAMESPACE")
 	if namespace == "" {
 		namespace = defaultPodNamespace
 	}
 	vo.DryRunNamespace = namespace
-	if signatureAnnotationType == SignatureAnnotationTypeShield {
-		vo.AnnotationConfig.AnnotationKeyDomain = AnnotationKeyDomain
+	if annotationDomain != "" {
+		vo.AnnotationConfig.AnnotationKeyDomain = annotationDomain
+	}
+	// provenance is fetched only when the profile has requirements on it
+	if paramObj.ProvenanceRequirements != nil {
+		vo.Provenance = true
 	}
 	// prepare local key for verifyResource
 	if len(paramObj.KeyConfigs) != 0 {
@@ -343,7 +458,8 @@ func setVerifyOption(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfi
 			if keyconfig.KeySecretName != "" {
 				keyPath, err := k8smnfconfig.LoadKeySecret(keyconfig.KeySecretNamespace, keyconfig.KeySecretName)
 				if err != nil {
-					log.Errorf("failed to load key secret", err.Error())
+					log.Errorf("failed to load key secret; %s", err.Error())
+					continue
 				}
 				keyPathList = append(keyPathList, keyPath)
 			}
@@ -353,13 +469,11 @@ func setVerifyOption(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfi
 			vo.KeyPath = keyPathString
 		}
 	}
-	// merge params in request handler config
-	if len(config.RequestFilterProfile.IgnoreFields) == 0 {
-		return vo
-	}
+	// merge params in request handler config; stamped verification annotations are always ignored
 	fields := k8smanifest.ObjectFieldBindingList{}
 	fields = append(fields, vo.IgnoreFields...)
 	fields = append(fields, config.RequestFilterProfile.IgnoreFields...)
+	fields = append(fields, k8smnfconfig.VerificationAnnotationIgnoreFields())
 	vo.IgnoreFields = fields
 	return vo
 }
@@ -375,93 +489,3 @@ func skipObjectsMatch(l k8smanifest.ObjectReferenceList, obj unstructured.Unstru
 	}
 	return false
 }
-
-func createOrUpdateEvent(req admission.Request, ar *ResultFromRequestHandler, constraintName string) error {
-	// no event is generated for allowed request
-	if ar.Allow {
-		return nil
-	}
-
-	config, err := kubeutil.GetKubeConfig()
-	if err != nil {
-		return err
-	}
-	client, err := kubeclient.NewForConfig(config)
-	if err != nil {
-		return err
-	}
-
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
-	}
-	gv := schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}
-	evtNamespace := req.Namespace
-	if evtNamespace == "" {
-		evtNamespace = namespace
-	}
-	involvedObject := corev1.ObjectReference{
-		Namespace:  req.Namespace,
-		APIVersion: gv.String(),
-		Kind:       req.Kind.Kind,
-		Name:       req.Name,
-	}
-	evtName := fmt.Sprintf("ishield-deny-%s-%s-%s", strings.ToLower(string(req.Operation)), strings.ToLower(req.Kind.Kind), req.Name)
-	sourceName := "IntegrityShield"
-
-	now := time.Now()
-	evt := &corev1.Event{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      evtName,
-			Namespace: evtNamespace,
-			Annotations: map[string]string{
-				EventTypeAnnotationKey:   EventTypeValueVerifyResult,
-				EventResultAnnotationKey: EventTypeAnnotationValueDeny,
-			},
-		},
-		InvolvedObject:      involvedObject,
-		Type:                sourceName,
-		Source:              corev1.EventSource{Component: sourceName},
-		ReportingController: sourceName,
-		ReportingInstance:   evtName,
-		Action:              evtName,
-		Reason:              "Deny",
-		FirstTimestamp:      metav1.NewTime(now),
-	}
-	isExistingEvent := false
-	current, getErr := client.CoreV1().Events(evtNamespace).Get(context.Background(), evtName, metav1.GetOptions{})
-	if current != nil && getErr == nil {
-		isExistingEvent = true
-		evt = current
-	}
-
-	tmpMessage := "[" + constraintName + "]" + ar.Message
-	// tmpMessage := ar.Message
-	// Event.Message can have 1024 chars at most
-	if len(tmpMessage) > 1024 {
-		tmpMessage = tmpMessage[:950] + " ... Trimmed. `Event.Message` can have 1024 chars at maximum."
-	}
-	evt.Message = tmpMessage
-	evt.Count = evt.Count + 1
-	evt.EventTime = metav1.NewMicroTime(now)
-	evt.LastTimestamp = metav1.NewTime(now)
-
-	if isExistingEvent {
-		_, err = client.CoreV1().Events(evtNamespace).Update(context.Background(), evt, metav1.UpdateOptions{})
-	} else {
-		_, err = client.CoreV1().Events(evtNamespace).Create(context.Background(), evt, metav1.CreateOptions{})
-	}
-	if err != nil {
-		log.Errorf("failed to generate deny event; %s", err.Error())
-		return err
-	}
-
-	log.WithFields(log.Fields{
-		"namespace": req.Namespace,
-		"name":      req.Name,
-		"kind":      req.Kind.Kind,
-		"operation": req.Operation,
-	}).Debug("Deny event is generated:", evtName)
-
-	return nil
-}
@@ -0,0 +1,84 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestEnforcedByConstraintConfig(t *testing.T) {
+	enforcing := k8smnfconfig.ConstraintConfig{Constraints: []k8smnfconfig.ActionConfig{
+		{ConstraintName: "enforced-*", Action: k8smnfconfig.Action{Enforce: true}},
+	}}
+	notFound := k8serrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, "constraint-config")
+
+	tests := []struct {
+		name           string
+		constraintName string
+		cconfig        k8smnfconfig.ConstraintConfig
+		cconfigErr     error
+		profileAction  string
+		wantAction     string
+	}{
+		{name: "enforced by constraint config", constraintName: "enforced-profile", cconfig: enforcing, wantAction: k8smnfconfig.ActionEnforce},
+		{name: "not listed in constraint config", constraintName: "sample-profile", cconfig: enforcing, wantAction: k8smnfconfig.ActionDetect},
+		{name: "missing constraint config", constraintName: "enforced-profile", cconfigErr: errors.Wrap(notFound, "failed to get constraint config"), wantAction: k8smnfconfig.ActionDetect},
+		{name: "constraint config not loaded", constraintName: "sample-profile", cconfigErr: errors.New("connection refused"), wantAction: k8smnfconfig.ActionEnforce},
+		{name: "action of profile", constraintName: "sample-profile", cconfigErr: errors.New("connection refused"), profileAction: k8smnfconfig.ActionAuditOnly, wantAction: k8smnfconfig.ActionAuditOnly},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			paramObj := &k8smnfconfig.ParameterObject{ConstraintName: tc.constraintName, Action: tc.profileAction}
+			action := paramObj.GetAction(enforcedByConstraintConfig(tc.constraintName, tc.cconfig, tc.cconfigErr))
+			if action != tc.wantAction {
+				t.Errorf("expected action %s, but got %s", tc.wantAction, action)
+			}
+		})
+	}
+}
+
+func TestDenyWarning(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{name: "known reason", reason: ReasonSignatureNotFound, want: "this would be denied: unsigned"},
+		{name: "unknown reason", reason: "CustomReason", want: "this would be denied: CustomReason"},
+		{name: "long reason", reason: strings.Repeat("a", 200), want: "this would be denied: " + strings.Repeat("a", maxWarningLength-len("this would be denied: "))},
+		// the limit falls in the middle of a 3-byte rune
+		{name: "long multi-byte reason", reason: "a" + strings.Repeat("あ", 60), want: "this would be denied: a" + strings.Repeat("あ", 32)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := DenyWarning(tc.reason)
+			if got != tc.want {
+				t.Errorf("expected %q, but got %q", tc.want, got)
+			}
+			if len(got) > maxWarningLength || !utf8.ValidString(got) {
+				t.Errorf("warning is too long or invalid UTF-8: %q", got)
+			}
+		})
+	}
+}
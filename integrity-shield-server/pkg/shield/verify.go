@@ -0,0 +1,101 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"context"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// VerifyResourceWithSignatureRefs calls VerifyResource with each candidate of the signatureRef in order.
+// The first result which verifies the resource is returned. Otherwise the first failed result is returned,
+// or the last error if every candidate returned an error.
+// It returns the context error as soon as the context is done.
+// namespace and name are those of the admission request, which are used in signatureRef templates.
+func VerifyResourceWithSignatureRefs(ctx context.Context, resource unstructured.Unstructured, namespace, name string, vo *k8smanifest.VerifyResourceOption, signatureRef k8smnfconfig.SignatureRef) (*k8smanifest.VerifyResourceResult, error) {
+	candidates, err := signatureRef.ResolveWithName(resource, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	var firstResult *k8smanifest.VerifyResourceResult
+	var lastErr error
+	for _, ref := range candidates {
+		tmpVo := *vo
+		if ref.ImageRef != "" {
+			tmpVo.ImageRef = ref.ImageRef
+		}
+		if ref.SignatureResourceRef != "" {
+			tmpVo.SignatureResourceRef = ref.SignatureResourceRef
+		}
+		if ref.ProvenanceResourceRef != "" {
+			tmpVo.ProvenanceResourceRef = ref.ProvenanceResourceRef
+		}
+		result, err := verifyResourceWithContext(ctx, resource, &tmpVo)
+		log.WithFields(log.Fields{
+			"namespace":            resource.GetNamespace(),
+			"name":                 resource.GetName(),
+			"kind":                 resource.GetKind(),
+			"imageRef":             ref.ImageRef,
+			"signatureResourceRef": ref.SignatureResourceRef,
+		}).Debug("VerifyResource result: ", result)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if result.Verified || !result.InScope {
+			return result, nil
+		}
+		if firstResult == nil {
+			firstResult = result
+		}
+	}
+	if firstResult != nil {
+		return firstResult, nil
+	}
+	return nil, lastErr
+}
+
+type verifyResourceResponse struct {
+	result *k8smanifest.VerifyResourceResult
+	err    error
+}
+
+// verifyResourceWithContext stops waiting for VerifyResource when the context is done.
+// VerifyResource itself does not take a context, so the call keeps running in background until it returns.
+func verifyResourceWithContext(ctx context.Context, resource unstructured.Unstructured, vo *k8smanifest.VerifyResourceOption) (*k8smanifest.VerifyResourceResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	respCh := make(chan verifyResourceResponse, 1)
+	go func() {
+		result, err := k8smanifest.VerifyResource(resource, vo)
+		respCh <- verifyResourceResponse{result: result, err: err}
+	}()
+	select {
+	case resp := <-respCh:
+		return resp.result, resp.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
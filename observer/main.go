@@ -17,8 +17,8 @@ package main
 import (
 	"fmt"
 	"os"
-	"strconv"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/IBM/integrity-shield/observer/pkg/observer"
 )
@@ -30,20 +30,13 @@ func main() {
 		fmt.Println("Failed to initialize Observer; err: ", err.Error())
 		return
 	}
-	intervalInt, _ := strconv.Atoi(os.Getenv("INTERVAL"))
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		close(stopCh)
+	}()
 	fmt.Println("observer started.")
-	insp.Run()
-	abort := make(chan struct{})
-	ticker := time.NewTicker(time.Duration(intervalInt) * time.Minute)
-	for {
-		select {
-		case <-ticker.C:
-			insp.Run()
-
-		case <-abort:
-			fmt.Println("Launch aborted!")
-			return
-		}
-	}
-
+	insp.Start(stopCh)
 }
@@ -42,6 +42,7 @@ type VerifyResult struct {
 	Signer     string     `json:"signer,omitempty"`
 	SignedTime *time.Time `json:"signedTime,omitempty"`
 	SigRef     string     `json:"sigRef,omitempty"`
+	Provenance string     `json:"provenance,omitempty"`
 }
 
 // VerifyResourceStatusStatus defines the observed state of VerifyResourceStatus
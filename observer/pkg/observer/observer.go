@@ -25,9 +25,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	shieldmatch "github.com/IBM/integrity-shield/integrity-shield-server/pkg/match"
 	vrres "github.com/IBM/integrity-shield/observer/pkg/apis/verifyresourcestatus/v1alpha1"
 	vrresclient "github.com/IBM/integrity-shield/observer/pkg/client/verifyresourcestatus/clientset/versioned/typed/verifyresourcestatus/v1alpha1"
 	"github.com/pkg/errors"
@@ -36,13 +38,15 @@ import (
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const timeFormat = "2006-01-02 15:04:05"
@@ -67,7 +71,23 @@ const VerifyResourceIgnoreLabel = "integrityshield.io/verifyResourceIgnored"
 type Observer struct {
 	APIResources []groupResource
 
+	kubeconfig    *rest.Config
 	dynamicClient dynamic.Interface
+	kubeClient    kubeclient.Interface
+
+	namespaceLister corelisters.NamespaceLister
+
+	// resources are watched by informers and queued to be verified on changes
+	queue           workqueue.RateLimitingInterface
+	informerFactory dynamicinformer.DynamicSharedInformerFactory
+	informers       map[schema.GroupVersionResource]cache.SharedIndexInformer
+	informerStopCh  chan struct{}
+
+	// mu guards constraints, results and dirty
+	mu          sync.Mutex
+	constraints map[string]*observedConstraint
+	results     map[string]map[queueItem]VerifyResultDetail
+	dirty       bool
 }
 
 // Observer Result Detail
@@ -82,6 +102,8 @@ type VerifyResultDetail struct {
 	Message              string                            `json:"message"`
 	Violation            bool                              `json:"violation"`
 	VerifyResourceResult *k8smanifest.VerifyResourceResult `json:"verifyResourceResult"`
+	// result of provenance check; empty if the constraint has no provenance requirements
+	ProvenanceStatus string `json:"provenanceStatus,omitempty"`
 }
 type ConstraintResult struct {
 	ConstraintName  string               `json:"constraintName"`
@@ -101,11 +123,6 @@ type groupResource struct {
 	APIResource metav1.APIResource `json:"resource"`
 }
 
-type groupResourceWithTargetNS struct {
-	groupResource    `json:""`
-	TargetNamespaces []string `json:"targetNamespace"`
-}
-
 var logLevelMap = map[string]log.Level{
 	"panic": log.PanicLevel,
 	"fatal": log.FatalLevel,
@@ -117,13 +134,18 @@ var logLevelMap = map[string]log.Level{
 }
 
 func NewObserver() *Observer {
-	insp := &Observer{}
+	insp := &Observer{
+		informers:   map[schema.GroupVersionResource]cache.SharedIndexInformer{},
+		constraints: map[string]*observedConstraint{},
+		results:     map[string]map[queueItem]VerifyResultDetail{},
+	}
 	return insp
 }
 
 func (self *Observer) Init() error {
 	log.Info("init Observer....")
 	kubeconf, _ := kubeutil.GetKubeConfig()
+	self.kubeconfig = kubeconf
 
 	var err error
 
@@ -138,6 +160,12 @@ func (self *Observer) Init() error {
 	}
 	self.dynamicClient = dynamicClient
 
+	kubeClient, err := kubeclient.NewForConfig(kubeconf)
+	if err != nil {
+		return err
+	}
+	self.kubeClient = kubeClient
+
 	// log
 	if os.Getenv("LOG_FORMAT") == "json" {
 		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
@@ -155,118 +183,23 @@ func (self *Observer) Init() error {
 	return nil
 }
 
-func (self *Observer) Run() {
-	// load config -> requestHandlerConfig
-	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig()
-	if err != nil {
-		log.Error("Failed to load RequestHandlerConfig; err: ", err.Error())
-	}
-	// load observer config
-	cconfig, err := k8smnfconfig.LoadConstraintConfig()
-	if err != nil {
-		log.Error("Failed to load Observer config; err: ", err.Error())
-	}
-	// load constraints
-	constraints, err := self.loadConstraints()
-	if err != nil {
-		log.Error("Failed to load constraints; err: ", err.Error())
-	}
-	// ObservationDetailResults
-	var constraintResults []ConstraintResult
-	for _, constraint := range constraints {
-		constraintName := constraint.Parameters.ConstraintName
-		var violations []vrres.VerifyResult
-		var nonViolations []vrres.VerifyResult
-		narrowedGVKList := self.getPossibleProtectedGVKs(constraint.Match)
-		if narrowedGVKList == nil {
-			log.Info("there is no resources to observe in the constraint:", constraint.Parameters.ConstraintName)
-			return
-		}
-		// get all resources of extracted GVKs
-		resources := []unstructured.Unstructured{}
-		for _, gResource := range narrowedGVKList {
-			tmpResources, _ := self.getAllResoucesByGroupResource(gResource)
-			resources = append(resources, tmpResources...)
-		}
-
-		// check all resources by verifyResource
-		ignoreFields := constraint.Parameters.IgnoreFields
-		secrets := constraint.Parameters.KeyConfigs
-		ignoreFields = append(ignoreFields, rhconfig.RequestFilterProfile.IgnoreFields...)
-		results := ObserveResources(resources, constraint.Parameters.SignatureRef, ignoreFields, secrets)
-		for _, res := range results {
-			// simple result
-			if res.Violation {
-				vres := vrres.VerifyResult{
-					Namespace:  res.Namespace,
-					Name:       res.Name,
-					Kind:       res.Kind,
-					ApiGroup:   res.ApiGroup,
-					ApiVersion: res.ApiVersion,
-					Result:     res.Message,
-				}
-				violations = append(violations, vres)
-			} else {
-				vres := vrres.VerifyResult{
-					Namespace:  res.Namespace,
-					Name:       res.Name,
-					Kind:       res.Kind,
-					ApiGroup:   res.ApiGroup,
-					ApiVersion: res.ApiVersion,
-					Signer:     res.VerifyResourceResult.Signer,
-					SigRef:     res.VerifyResourceResult.SigRef,
-					SignedTime: res.VerifyResourceResult.SignedTime,
-					Result:     res.Message,
-				}
-				nonViolations = append(nonViolations, vres)
-			}
-			log.WithFields(log.Fields{
-				"constraintName": constraintName,
-				"violation":      res.Violation,
-				"kind":           res.Kind,
-				"name":           res.Name,
-				"namespace":      res.Namespace,
-			}).Info(res.Message)
-		}
-		// summarize results
-		var violated bool
-		if len(violations) != 0 {
-			violated = true
-		} else {
-			violated = false
-		}
-		count := len(violations)
-
-		vrr := vrres.VerifyResourceStatusSpec{
-			ConstraintName:  constraintName,
-			Violation:       violated,
-			TotalViolations: count,
-			Violations:      violations,
-			NonViolations:   nonViolations,
-			ObservationTime: time.Now().Format(timeFormat),
-		}
-
-		// check if targeted constraint
-		ignored := k8smnfconfig.CheckIfIgnoredConstraint(constraintName, cconfig.Constraints)
-
-		// export VerifyResult
-		_ = exportVerifyResult(vrr, ignored, violated)
-		// VerifyResultDetail
-		cres := ConstraintResult{
-			ConstraintName:  constraintName,
-			Results:         results,
-			Violation:       violated,
-			TotalViolations: count,
-		}
-		constraintResults = append(constraintResults, cres)
-	}
-
-	// export ConstraintResult
-	res := ObservationDetailResults{
-		ConstraintResults: constraintResults,
-	}
-	_ = exportResultDetail(res)
-	return
+// toVerifyResult converts a result detail into a result in VerifyResourceStatus.
+func toVerifyResult(res VerifyResultDetail) vrres.VerifyResult {
+	vres := vrres.VerifyResult{
+		Namespace:  res.Namespace,
+		Name:       res.Name,
+		Kind:       res.Kind,
+		ApiGroup:   res.ApiGroup,
+		ApiVersion: res.ApiVersion,
+		Result:     res.Message,
+		Provenance: res.ProvenanceStatus,
+	}
+	if !res.Violation && res.VerifyResourceResult != nil {
+		vres.Signer = res.VerifyResourceResult.Signer
+		vres.SigRef = res.VerifyResourceResult.SigRef
+		vres.SignedTime = res.VerifyResourceResult.SignedTime
+	}
+	return vres
 }
 
 func exportVerifyResult(vrr vrres.VerifyResourceStatusSpec, ignored bool, violated bool) error {
@@ -408,7 +341,11 @@ func (self *Observer) getAPIResources(kubeconfig *rest.Config) error {
 
 	apiResourceLists, err := discoveryClient.ServerPreferredResources()
 	if err != nil {
-		return err
+		// resources of available groups are returned even if some groups are unavailable
+		if !discovery.IsGroupDiscoveryFailedError(err) || apiResourceLists == nil {
+			return err
+		}
+		log.Warning("failed to discover some API groups; ", err.Error())
 	}
 
 	resources := []groupResource{}
@@ -421,7 +358,8 @@ func (self *Observer) getAPIResources(kubeconfig *rest.Config) error {
 			continue
 		}
 		for _, resource := range apiResourceList.APIResources {
-			if len(resource.Verbs) == 0 {
+			// resources are watched by informers; subresources cannot be watched
+			if strings.Contains(resource.Name, "/") || !Contains(resource.Verbs, "list") || !Contains(resource.Verbs, "watch") {
 				continue
 			}
 			resources = append(resources, groupResource{
@@ -435,41 +373,6 @@ func (self *Observer) getAPIResources(kubeconfig *rest.Config) error {
 	return nil
 }
 
-func (self *Observer) getAllResoucesByGroupResource(gResourceWithTargetNS groupResourceWithTargetNS) ([]unstructured.Unstructured, error) {
-	var resources []unstructured.Unstructured
-	var err error
-	gResource := gResourceWithTargetNS.groupResource
-	targetNSs := gResourceWithTargetNS.TargetNamespaces
-	namespaced := gResource.APIResource.Namespaced
-	gvr := schema.GroupVersionResource{
-		Group:    gResource.APIGroup,
-		Version:  gResource.APIVersion,
-		Resource: gResource.APIResource.Name,
-	}
-
-	var tmpResourceList *unstructured.UnstructuredList
-	if namespaced {
-		for _, ns := range targetNSs {
-			tmpResourceList, err = self.dynamicClient.Resource(gvr).Namespace(ns).List(context.Background(), metav1.ListOptions{})
-			if err != nil {
-				log.Error("failed to get tmpResourceList:", err.Error())
-				break
-			}
-			resources = append(resources, tmpResourceList.Items...)
-		}
-
-	} else {
-		tmpResourceList, err = self.dynamicClient.Resource(gvr).List(context.Background(), metav1.ListOptions{})
-		resources = append(resources, tmpResourceList.Items...)
-	}
-	if err != nil {
-		// ignore RBAC error - IShield SA
-		log.Error("RBAC error when listing resources; error:", err.Error())
-		return []unstructured.Unstructured{}, nil
-	}
-	return resources, nil
-}
-
 func LoadKeySecret(keySecertNamespace, keySecertName string) (string, error) {
 	obj, err := kubeutil.GetResource("v1", "Secret", keySecertNamespace, keySecertName)
 	if err != nil {
@@ -485,7 +388,7 @@ func LoadKeySecret(keySecertNamespace, keySecertName string) (string, error) {
 	for fname, keyData := range secret.Data {
 		os.MkdirAll(keyDir, os.ModePerm)
 		fpath := filepath.Join(keyDir, fname)
-		err = ioutil.WriteFile(fpath, keyData, 0644)
+		err = writeFileAtomically(fpath, keyData)
 		if err != nil {
 			sumErr = append(sumErr, err.Error())
 			continue
@@ -503,6 +406,27 @@ func LoadKeySecret(keySecertNamespace, keySecertName string) (string, error) {
 	return keyPath, nil
 }
 
+// writeFileAtomically replaces a file by renaming a temporary file, so that workers verifying
+// other resources never read a partially written key.
+func writeFileAtomically(fpath string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fpath), filepath.Base(fpath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fpath)
+}
+
 //
 // Constraint
 //
@@ -556,57 +480,35 @@ func (self *Observer) loadConstraints() ([]ConstraintSpec, error) {
 	return micList, nil
 }
 
-func (self *Observer) getPossibleProtectedGVKs(match MatchCondition) []groupResourceWithTargetNS {
-	possibleProtectedGVKs := []groupResourceWithTargetNS{}
-	for _, apiResource := range self.APIResources {
-		matched, tmpGvks := checkIfRuleMatchWithGVK(match, apiResource)
-		if matched {
-			possibleProtectedGVKs = append(possibleProtectedGVKs, tmpGvks...)
-			break
-		}
+// condition returns the condition of the matcher shared with the admission controller.
+func (self MatchCondition) condition() shieldmatch.Condition {
+	kinds := []shieldmatch.Kinds{}
+	for _, k := range self.Kinds {
+		kinds = append(kinds, shieldmatch.Kinds{Kinds: k.Kinds, ApiGroups: k.ApiGroups})
+	}
+	return shieldmatch.Condition{
+		Kinds:              kinds,
+		Namespaces:         self.Namespaces,
+		ExcludedNamespaces: self.ExcludedNamespaces,
+		LabelSelector:      self.LabelSelector,
+		NamespaceSelector:  self.NamespaceSelector,
 	}
-	return possibleProtectedGVKs
 }
 
-// TODO: check logic
-func checkIfRuleMatchWithGVK(match MatchCondition, apiResource groupResource) (bool, []groupResourceWithTargetNS) {
-	possibleProtectedGVKs := []groupResourceWithTargetNS{}
-	// TODO: support "LabelSelector"
-	if len(match.Kinds) == 0 {
-		return false, nil
-	}
-	matched := false
-	for _, kinds := range match.Kinds {
-		kmatch := false
-		agmatch := false
-		if len(kinds.ApiGroups) != 0 {
-			agmatch = Contains(kinds.ApiGroups, apiResource.APIGroup)
-		} else {
-			agmatch = true
-		}
-		if len(kinds.ApiGroups) != 0 {
-			kmatch = Contains(kinds.Kinds, apiResource.APIResource.Kind)
-		} else {
-			kmatch = true
-		}
-		if kmatch && agmatch {
-			matched = true
-			namespaces := match.Namespaces
-			if match.NamespaceSelector != nil {
-				labeledNS := getLabelMatchedNamespace(match.NamespaceSelector)
-				namespaces = append(namespaces, labeledNS...)
-			}
-			possibleProtectedGVKs = append(possibleProtectedGVKs, groupResourceWithTargetNS{
-				groupResource:    apiResource,
-				TargetNamespaces: namespaces,
-			})
+// getPossibleProtectedGVKs returns all resources whose kind matches the condition. Other conditions
+// such as namespaces and selectors are checked for each resource.
+func (self *Observer) getPossibleProtectedGVKs(match MatchCondition) []groupResource {
+	possibleProtectedGVKs := []groupResource{}
+	kinds := match.condition().Kinds
+	for _, apiResource := range self.APIResources {
+		if shieldmatch.MatchKinds(kinds, apiResource.APIGroup, apiResource.APIResource.Kind) {
+			possibleProtectedGVKs = append(possibleProtectedGVKs, apiResource)
 		}
 	}
 	log.WithFields(log.Fields{
-		"matched":               matched,
-		"possibleProtectedGVKs": possibleProtectedGVKs,
+		"possibleProtectedGVKs": len(possibleProtectedGVKs),
 	}).Debug("check match condition")
-	return matched, possibleProtectedGVKs
+	return possibleProtectedGVKs
 }
 
 func Contains(pattern []string, value string) bool {
@@ -617,39 +519,3 @@ func Contains(pattern []string, value string) bool {
 	}
 	return false
 }
-
-func getLabelMatchedNamespace(labelSelector *metav1.LabelSelector) []string {
-	matchedNs := []string{}
-	if labelSelector == nil {
-		return []string{}
-	}
-	config, err := kubeutil.GetKubeConfig()
-	if err != nil {
-		return []string{}
-	}
-	clientset, err := kubeclient.NewForConfig(config)
-	if err != nil {
-		log.Error(err)
-		return []string{}
-	}
-	namespaces, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		log.Errorf("failed to list a namespace:`%s`", err.Error())
-		return []string{}
-	}
-	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
-	if err != nil {
-		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
-		return []string{}
-	}
-
-	for _, ns := range namespaces.Items {
-		labelsMap := ns.GetLabels()
-		labelsSet := labels.Set(labelsMap)
-		matched := selector.Matches(labelsSet)
-		if matched {
-			matchedNs = append(matchedNs, ns.Name)
-		}
-	}
-	return matchedNs
-}
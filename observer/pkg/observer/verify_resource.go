@@ -17,18 +17,21 @@
 package observer
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	"github.com/IBM/integrity-shield/integrity-shield-server/pkg/shield"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
-func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnfconfig.SignatureRef, ignoreFields k8smanifest.ObjectFieldBindingList, secrets []k8smnfconfig.KeyConfig) []VerifyResultDetail {
+func ObserveResources(resources []unstructured.Unstructured, parameters k8smnfconfig.ParameterObject, ignoreFields k8smanifest.ObjectFieldBindingList) []VerifyResultDetail {
+	secrets := parameters.KeyConfigs
 	results := []VerifyResultDetail{}
 	namespace := os.Getenv("POD_NAMESPACE")
 	if namespace == "" {
@@ -39,20 +42,14 @@ func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnf
 		vo := &k8smanifest.VerifyResourceOption{}
 		vo.IgnoreFields = ignoreFields
 		// vo.CheckDryRunForApply = true
-		// vo.Provenance = true
-		vo.DryRunNamespace = namespace
-
-		if signatureRef.ImageRef != "" {
-			vo.ImageRef = signatureRef.ImageRef
+		if parameters.ProvenanceRequirements != nil {
+			vo.Provenance = true
 		}
-		if signatureRef.SignatureResourceRef.Name != "" && signatureRef.SignatureResourceRef.Namespace != "" {
-			ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", signatureRef.SignatureResourceRef.Namespace, signatureRef.SignatureResourceRef.Name)
-			vo.SignatureResourceRef = ref
-		}
-		if signatureRef.ProvenanceResourceRef.Name != "" && signatureRef.ProvenanceResourceRef.Namespace != "" {
-			ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", signatureRef.ProvenanceResourceRef.Namespace, signatureRef.ProvenanceResourceRef.Name)
-			vo.ProvenanceResourceRef = ref
+		vo.DryRunNamespace = namespace
+		if domain, _ := parameters.MatchAnnotationDomain(resource.GetAnnotations()); domain != "" {
+			vo.AnnotationConfig.AnnotationKeyDomain = domain
 		}
+
 		// secret
 		for _, s := range secrets {
 			if s.KeySecretNamespace == resource.GetNamespace() {
@@ -65,8 +62,7 @@ func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnf
 			}
 		}
 		log.Debug("VerifyResourceOption", vo)
-		result, err := k8smanifest.VerifyResource(resource, vo)
-		log.Debug("VerifyResource result: ", result)
+		result, err := shield.VerifyResourceWithSignatureRefs(context.Background(), resource, resource.GetNamespace(), resource.GetName(), vo, parameters.SignatureRef)
 		if err != nil {
 			log.Warning("Signature verification is required for this request, but verifyResource return error ; %s", err.Error())
 			results = append(results, VerifyResultDetail{
@@ -98,6 +94,14 @@ func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnf
 		} else {
 			message = "not protected"
 		}
+		provenanceStatus := ""
+		if result.InScope && parameters.ProvenanceRequirements != nil {
+			provStatus, provMessage := shield.CheckProvenance(result, parameters.ProvenanceRequirements, vo.KeyPath)
+			provenanceStatus = provStatus
+			if result.Verified && provStatus != shield.ProvenanceStatusVerified {
+				message = provMessage
+			}
+		}
 		tmpMsg := strings.Split(message, " (Request: {")
 		resultMsg := ""
 		if len(tmpMsg) > 0 {
@@ -105,7 +109,7 @@ func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnf
 		}
 
 		violation := true
-		if result.Verified {
+		if result.Verified && (provenanceStatus == "" || provenanceStatus == shield.ProvenanceStatusVerified) {
 			violation = false
 		}
 		results = append(results, VerifyResultDetail{
@@ -118,6 +122,7 @@ func ObserveResources(resources []unstructured.Unstructured, signatureRef k8smnf
 			Message:              resultMsg,
 			VerifyResourceResult: result,
 			Violation:            violation,
+			ProvenanceStatus:     provenanceStatus,
 		})
 	}
 	return results
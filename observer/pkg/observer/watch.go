@@ -0,0 +1,618 @@
+//
+// Copyright 2021 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/integrity-shield-server/pkg/config"
+	shieldmatch "github.com/IBM/integrity-shield/integrity-shield-server/pkg/match"
+	vrres "github.com/IBM/integrity-shield/observer/pkg/apis/verifyresourcestatus/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const intervalEnvKey = "INTERVAL"
+const workersEnvKey = "OBSERVER_WORKERS"
+
+const defaultResyncIntervalMinutes = 5
+const defaultWorkers = 4
+
+// results are exported at most once per exportInterval, not on each verification
+const exportInterval = 10 * time.Second
+
+// a resource whose verification failed with an error is retried up to maxRetries times with backoff
+const maxRetries = 5
+
+const informerSyncTimeout = 1 * time.Minute
+
+// observedConstraint is a constraint with the resources which it observes.
+type observedConstraint struct {
+	ConstraintSpec
+	ignored      bool
+	ignoreFields k8smanifest.ObjectFieldBindingList
+	targets      map[schema.GroupVersionResource]groupResource
+}
+
+// queueItem identifies a resource in the work queue.
+type queueItem struct {
+	gvr schema.GroupVersionResource
+	// namespace/name of a namespaced resource, or name of a cluster-scoped resource
+	key string
+}
+
+// observes checks a resource with the match condition of the constraint in the same way as the admission controller.
+func (c *observedConstraint) observes(gvr schema.GroupVersionResource, resource *unstructured.Unstructured, namespaceLabels func(string) (map[string]string, error)) (bool, error) {
+	target, ok := c.targets[gvr]
+	if !ok {
+		return false, nil
+	}
+	namespace := resource.GetNamespace()
+	matched, _, err := shieldmatch.Match(c.Match.condition(), shieldmatch.Target{
+		Group:     target.APIGroup,
+		Kind:      target.APIResource.Kind,
+		Namespace: namespace,
+		Name:      resource.GetName(),
+		Object:    resource,
+		NamespaceLabels: func() (map[string]string, error) {
+			return namespaceLabels(namespace)
+		},
+	})
+	return matched, err
+}
+
+// Start watches resources matched by constraints and verifies them until stopCh is closed.
+// A resource is verified when it is added or updated. All resources are verified again on each
+// periodic resync, which also reloads constraints and configs so that changes of keys are caught.
+func (self *Observer) Start(stopCh <-chan struct{}) {
+	self.queue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "observer")
+	defer self.queue.ShutDown()
+
+	self.startNamespaceInformer(stopCh)
+	workers := getWorkers()
+	for i := 0; i < workers; i++ {
+		go wait.Until(self.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(func() { self.resync(stopCh) }, getResyncInterval(), stopCh)
+	go wait.Until(self.exportResults, exportInterval, stopCh)
+	log.Infof("observer is started with %d workers; resync interval: %s", workers, getResyncInterval())
+
+	<-stopCh
+	self.mu.Lock()
+	if self.informerStopCh != nil {
+		close(self.informerStopCh)
+		self.informerStopCh = nil
+	}
+	self.mu.Unlock()
+	log.Info("observer is stopped")
+}
+
+// startNamespaceInformer caches namespaces for namespace selectors of constraints. When labels of a namespace
+// are changed, resources in the namespace are verified again because they may be matched differently.
+func (self *Observer) startNamespaceInformer(stopCh <-chan struct{}) {
+	factory := kubeinformers.NewSharedInformerFactory(self.kubeClient, 0)
+	informer := factory.Core().V1().Namespaces()
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err1 := meta.Accessor(oldObj)
+			newMeta, err2 := meta.Accessor(newObj)
+			if err1 != nil || err2 != nil || reflect.DeepEqual(oldMeta.GetLabels(), newMeta.GetLabels()) {
+				return
+			}
+			self.enqueueNamespace(newMeta.GetName())
+		},
+	})
+	self.namespaceLister = informer.Lister()
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced) {
+		log.Warning("namespace informer is not synced")
+	}
+}
+
+// namespaceLabels returns labels of a namespace. A namespace which is created just now may not be in the cache yet.
+func (self *Observer) namespaceLabels(namespace string) (map[string]string, error) {
+	ns, err := self.namespaceLister.Get(namespace)
+	if err == nil {
+		return ns.GetLabels(), nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s` from cache", namespace))
+	}
+	ns, err = self.kubeClient.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s`", namespace))
+	}
+	return ns.GetLabels(), nil
+}
+
+func (self *Observer) enqueueNamespace(namespace string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for gvr, informer := range self.informers {
+		keys, err := informer.GetIndexer().IndexKeys(cache.NamespaceIndex, namespace)
+		if err != nil {
+			log.Errorf("failed to list %s in namespace `%s` from cache; %s", gvr.String(), namespace, err.Error())
+			continue
+		}
+		for _, key := range keys {
+			self.queue.Add(queueItem{gvr: gvr, key: key})
+		}
+	}
+}
+
+// resync reloads constraints and configs, starts informers for resources newly matched by constraints
+// and queues all watched resources.
+func (self *Observer) resync(stopCh <-chan struct{}) {
+	log.Info("resync observed resources...")
+	// CRDs may be added since the last resync
+	if err := self.getAPIResources(self.kubeconfig); err != nil {
+		log.Error("Failed to get API resources; err: ", err.Error())
+	}
+	constraints := self.loadObservedConstraints()
+
+	wanted := map[schema.GroupVersionResource]bool{}
+	for _, c := range constraints {
+		for gvr := range c.targets {
+			wanted[gvr] = true
+		}
+	}
+	newInformers := self.updateInformers(wanted)
+
+	self.mu.Lock()
+	self.constraints = constraints
+	// drop results of constraints which are removed, and of resources which are no longer matched
+	for name, results := range self.results {
+		c, ok := constraints[name]
+		if !ok {
+			delete(self.results, name)
+			continue
+		}
+		// other results are updated when resources are verified again
+		for item := range results {
+			if _, ok := c.targets[item.gvr]; !ok {
+				delete(results, item)
+			}
+		}
+	}
+	for name := range constraints {
+		if _, ok := self.results[name]; !ok {
+			self.results[name] = map[queueItem]VerifyResultDetail{}
+		}
+	}
+	self.dirty = true
+	informers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+	for gvr, informer := range self.informers {
+		informers[gvr] = informer
+	}
+	self.mu.Unlock()
+
+	// wait for new informers so that their resources are verified in this resync;
+	// an informer which cannot list its resources, e.g. by RBAC, keeps retrying in background
+	ctx, cancel := context.WithTimeout(context.Background(), informerSyncTimeout)
+	syncStopCh := mergeChannels(stopCh, ctx.Done())
+	for gvr, informer := range newInformers {
+		if !cache.WaitForCacheSync(syncStopCh, informer.HasSynced) {
+			log.Warningf("informer of `%s` is not synced yet", gvr.String())
+		}
+	}
+	cancel()
+
+	count := 0
+	for gvr, informer := range informers {
+		for _, key := range informer.GetStore().ListKeys() {
+			self.queue.Add(queueItem{gvr: gvr, key: key})
+			count++
+		}
+	}
+	log.Infof("%d resources of %d constraints are queued to be verified", count, len(constraints))
+}
+
+// loadObservedConstraints loads constraints with configs, and finds resources which each constraint observes.
+func (self *Observer) loadObservedConstraints() map[string]*observedConstraint {
+	// load config -> requestHandlerConfig
+	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig(context.Background())
+	if err != nil {
+		log.Error("Failed to load RequestHandlerConfig; err: ", err.Error())
+	}
+	if rhconfig == nil {
+		rhconfig = &k8smnfconfig.RequestHandlerConfig{}
+	}
+	// load observer config
+	cconfig, err := k8smnfconfig.LoadConstraintConfig(context.Background())
+	if err != nil {
+		log.Error("Failed to load Observer config; err: ", err.Error())
+	}
+	// load constraints
+	constraints, err := self.loadConstraints()
+	if err != nil {
+		log.Error("Failed to load constraints; err: ", err.Error())
+	}
+
+	observed := map[string]*observedConstraint{}
+	for _, constraint := range constraints {
+		constraintName := constraint.Parameters.ConstraintName
+		ignoreFields := constraint.Parameters.IgnoreFields
+		ignoreFields = append(ignoreFields, rhconfig.RequestFilterProfile.IgnoreFields...)
+		ignoreFields = append(ignoreFields, k8smnfconfig.VerificationAnnotationIgnoreFields())
+		c := &observedConstraint{
+			ConstraintSpec: constraint,
+			ignored:        k8smnfconfig.CheckIfIgnoredConstraint(constraintName, cconfig.Constraints),
+			ignoreFields:   ignoreFields,
+			targets:        map[schema.GroupVersionResource]groupResource{},
+		}
+		for _, gResource := range self.getPossibleProtectedGVKs(constraint.Match) {
+			gvr := schema.GroupVersionResource{
+				Group:    gResource.APIGroup,
+				Version:  gResource.APIVersion,
+				Resource: gResource.APIResource.Name,
+			}
+			c.targets[gvr] = gResource
+		}
+		if len(c.targets) == 0 {
+			log.Info("there is no resources to observe in the constraint:", constraintName)
+		}
+		observed[constraintName] = c
+	}
+	return observed
+}
+
+// updateInformers starts informers of resources which are newly matched. Informers are shared by
+// constraints; if any watched resource is no longer matched, all informers are recreated.
+// It returns the informers which are started.
+func (self *Observer) updateInformers(wanted map[schema.GroupVersionResource]bool) map[schema.GroupVersionResource]cache.SharedIndexInformer {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for gvr := range self.informers {
+		if !wanted[gvr] {
+			log.Info("resources to observe are reduced; recreating informers...")
+			if self.informerStopCh != nil {
+				close(self.informerStopCh)
+			}
+			self.informerFactory = nil
+			self.informers = map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+			break
+		}
+	}
+	if self.informerFactory == nil {
+		self.informerFactory = dynamicinformer.NewDynamicSharedInformerFactory(self.dynamicClient, 0)
+		self.informerStopCh = make(chan struct{})
+	}
+
+	newInformers := map[schema.GroupVersionResource]cache.SharedIndexInformer{}
+	for gvr := range wanted {
+		if _, ok := self.informers[gvr]; ok {
+			continue
+		}
+		informer := self.informerFactory.ForResource(gvr).Informer()
+		informer.AddEventHandler(self.eventHandler(gvr))
+		self.informers[gvr] = informer
+		newInformers[gvr] = informer
+	}
+	self.informerFactory.Start(self.informerStopCh)
+	return newInformers
+}
+
+func (self *Observer) eventHandler(gvr schema.GroupVersionResource) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			self.enqueue(gvr, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !verifiedContentChanged(oldObj, newObj) {
+				return
+			}
+			self.enqueue(gvr, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			self.enqueue(gvr, obj)
+		},
+	}
+}
+
+// verifiedContentChanged reports whether an update may change the verification result. Updates of status
+// or of metadata maintained by controllers, such as resourceVersion and managedFields, are ignored.
+func verifiedContentChanged(oldObj, newObj interface{}) bool {
+	oldRes, ok1 := oldObj.(*unstructured.Unstructured)
+	newRes, ok2 := newObj.(*unstructured.Unstructured)
+	if !ok1 || !ok2 {
+		return true
+	}
+	if oldRes.GetResourceVersion() == newRes.GetResourceVersion() {
+		return false
+	}
+	// signatures and message annotations are not counted in the generation
+	if !reflect.DeepEqual(oldRes.GetLabels(), newRes.GetLabels()) || !reflect.DeepEqual(oldRes.GetAnnotations(), newRes.GetAnnotations()) {
+		return true
+	}
+	// the generation is incremented when the spec is changed, if the resource has it
+	if oldRes.GetGeneration() > 0 && newRes.GetGeneration() > 0 {
+		return oldRes.GetGeneration() != newRes.GetGeneration()
+	}
+	// otherwise, fields other than metadata and status are compared, e.g. data of a ConfigMap
+	return !reflect.DeepEqual(contentWithoutMetadata(oldRes), contentWithoutMetadata(newRes))
+}
+
+func contentWithoutMetadata(res *unstructured.Unstructured) map[string]interface{} {
+	content := map[string]interface{}{}
+	for k, v := range res.Object {
+		if k == "metadata" || k == "status" {
+			continue
+		}
+		content[k] = v
+	}
+	return content
+}
+
+func (self *Observer) enqueue(gvr schema.GroupVersionResource, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("failed to get a key of a resource; %s", err.Error())
+		return
+	}
+	self.queue.Add(queueItem{gvr: gvr, key: key})
+}
+
+func (self *Observer) runWorker() {
+	for self.processNextItem() {
+	}
+}
+
+func (self *Observer) processNextItem() bool {
+	obj, shutdown := self.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer self.queue.Done(obj)
+	item := obj.(queueItem)
+
+	retry := self.verify(item)
+	if retry && self.queue.NumRequeues(item) < maxRetries {
+		self.queue.AddRateLimited(item)
+		return true
+	}
+	self.queue.Forget(item)
+	return true
+}
+
+// verify verifies a resource with constraints which observe it, and records the results.
+// It returns true if the verification should be retried.
+func (self *Observer) verify(item queueItem) bool {
+	self.mu.Lock()
+	informer, ok := self.informers[item.gvr]
+	constraints := []*observedConstraint{}
+	for _, c := range self.constraints {
+		constraints = append(constraints, c)
+	}
+	self.mu.Unlock()
+	if !ok {
+		// the informer is recreated; the resource is queued again by the resync
+		return false
+	}
+
+	obj, exists, err := informer.GetIndexer().GetByKey(item.key)
+	if err != nil {
+		log.Errorf("failed to get `%s` of %s from cache; %s", item.key, item.gvr.String(), err.Error())
+		return true
+	}
+	if !exists {
+		self.removeResults(item)
+		return false
+	}
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		log.Errorf("unexpected object type of `%s` of %s: %T", item.key, item.gvr.String(), obj)
+		return false
+	}
+
+	retry := false
+	for _, c := range constraints {
+		constraintName := c.Parameters.ConstraintName
+		observed, err := c.observes(item.gvr, resource, self.namespaceLabels)
+		if err != nil {
+			retry = true
+			continue
+		}
+		if !observed {
+			self.removeResult(constraintName, item)
+			continue
+		}
+		results := ObserveResources([]unstructured.Unstructured{*resource.DeepCopy()}, c.Parameters, c.ignoreFields)
+		for _, res := range results {
+			if res.Error {
+				retry = true
+			}
+			log.WithFields(log.Fields{
+				"constraintName": constraintName,
+				"violation":      res.Violation,
+				"kind":           res.Kind,
+				"name":           res.Name,
+				"namespace":      res.Namespace,
+			}).Info(res.Message)
+			self.setResult(constraintName, item, res)
+		}
+	}
+	return retry
+}
+
+func (self *Observer) setResult(constraintName string, item queueItem, res VerifyResultDetail) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	results, ok := self.results[constraintName]
+	if !ok {
+		// the constraint is removed during the verification
+		return
+	}
+	results[item] = res
+	self.dirty = true
+}
+
+func (self *Observer) removeResult(constraintName string, item queueItem) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if _, ok := self.results[constraintName][item]; ok {
+		delete(self.results[constraintName], item)
+		self.dirty = true
+	}
+}
+
+func (self *Observer) removeResults(item queueItem) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, results := range self.results {
+		if _, ok := results[item]; ok {
+			delete(results, item)
+			self.dirty = true
+		}
+	}
+}
+
+// exportResults exports VerifyResourceStatus of each constraint and the result detail if any result is changed.
+func (self *Observer) exportResults() {
+	self.mu.Lock()
+	if !self.dirty {
+		self.mu.Unlock()
+		return
+	}
+	self.dirty = false
+	names := []string{}
+	for name := range self.constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	type constraintExport struct {
+		vrr     vrres.VerifyResourceStatusSpec
+		ignored bool
+		detail  ConstraintResult
+	}
+	exports := []constraintExport{}
+	now := time.Now().Format(timeFormat)
+	for _, name := range names {
+		results := sortedResults(self.results[name])
+		var violations []vrres.VerifyResult
+		var nonViolations []vrres.VerifyResult
+		for _, res := range results {
+			if res.Violation {
+				violations = append(violations, toVerifyResult(res))
+			} else {
+				nonViolations = append(nonViolations, toVerifyResult(res))
+			}
+		}
+		violated := len(violations) != 0
+		count := len(violations)
+		exports = append(exports, constraintExport{
+			vrr: vrres.VerifyResourceStatusSpec{
+				ConstraintName:  name,
+				Violation:       violated,
+				TotalViolations: count,
+				Violations:      violations,
+				NonViolations:   nonViolations,
+				ObservationTime: now,
+			},
+			ignored: self.constraints[name].ignored,
+			detail: ConstraintResult{
+				ConstraintName:  name,
+				Results:         results,
+				Violation:       violated,
+				TotalViolations: count,
+			},
+		})
+	}
+	self.mu.Unlock()
+
+	failed := false
+	detail := ObservationDetailResults{}
+	for _, e := range exports {
+		if err := exportVerifyResult(e.vrr, e.ignored, e.vrr.Violation); err != nil {
+			failed = true
+		}
+		detail.ConstraintResults = append(detail.ConstraintResults, e.detail)
+	}
+	if err := exportResultDetail(detail); err != nil {
+		failed = true
+	}
+	if failed {
+		// export again in the next interval
+		self.mu.Lock()
+		self.dirty = true
+		self.mu.Unlock()
+	}
+}
+
+func sortedResults(results map[queueItem]VerifyResultDetail) []VerifyResultDetail {
+	items := []queueItem{}
+	for item := range results {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].gvr.String() != items[j].gvr.String() {
+			return items[i].gvr.String() < items[j].gvr.String()
+		}
+		return items[i].key < items[j].key
+	})
+	sorted := []VerifyResultDetail{}
+	for _, item := range items {
+		sorted = append(sorted, results[item])
+	}
+	return sorted
+}
+
+func getResyncInterval() time.Duration {
+	interval, err := strconv.Atoi(os.Getenv(intervalEnvKey))
+	if err != nil || interval <= 0 {
+		interval = defaultResyncIntervalMinutes
+	}
+	return time.Duration(interval) * time.Minute
+}
+
+func getWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv(workersEnvKey))
+	if err != nil || workers <= 0 {
+		return defaultWorkers
+	}
+	return workers
+}
+
+// mergeChannels returns a channel which is closed when either of the channels is closed.
+func mergeChannels(a, b <-chan struct{}) <-chan struct{} {
+	merged := make(chan struct{})
+	go func() {
+		defer close(merged)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return merged
+}